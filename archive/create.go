@@ -0,0 +1,217 @@
+package archive
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// overlayXattrPrefixes prefix the extended attributes overlayfs keeps its metadata in, in the upper
+// directory: the trusted namespace is used by privileged mounts, the user one inside user namespaces
+var overlayXattrPrefixes = []string{"trusted.overlay.", "user.overlay."}
+
+// CreateOptions controls how directories are archived
+type CreateOptions struct {
+	// MapOwner maps the owner of a file on the host to the owner stored in the archive.
+	// The ownership is preserved when nil.
+	MapOwner func(uid, gid int) (int, int, error)
+	// Whiteouts turns the whiteouts of an overlayfs upper directory into OCI whiteouts: character devices
+	// with number 0/0 become .wh.<name> entries, and opaque directories get a .wh..wh..opq entry
+	Whiteouts bool
+}
+
+// Create writes a tar archive of the content of src to w, with the entry names relative to src.
+// Hardlinks are archived as links to the first name found, while sockets are skipped.
+func Create(w io.Writer, src string, opts CreateOptions) error {
+	tw := tar.NewWriter(w)
+	// the first name archived of each file with several links, by device and inode
+	links := map[[2]uint64]string{}
+
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == src {
+			return nil
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+
+		var stat unix.Stat_t
+		if err := unix.Lstat(path, &stat); err != nil {
+			return &os.PathError{Op: "lstat", Path: path, Err: err}
+		}
+		if opts.Whiteouts && stat.Mode&unix.S_IFMT == unix.S_IFCHR && stat.Rdev == 0 {
+			dir, base := filepath.Split(name)
+			return writeWhiteout(tw, dir+whiteoutPrefix+base)
+		}
+
+		hdr, err := entryHeader(path, name, info, &stat, opts)
+		if err != nil || hdr == nil {
+			return err
+		}
+		if hdr.Typeflag == tar.TypeReg && stat.Nlink > 1 {
+			key := [2]uint64{uint64(stat.Dev), uint64(stat.Ino)}
+			if first, found := links[key]; found {
+				hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeLink, first, 0
+				return tw.WriteHeader(hdr)
+			}
+			links[key] = name
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		switch {
+		case hdr.Typeflag == tar.TypeReg:
+			return copyFile(tw, path)
+		case hdr.Typeflag == tar.TypeDir && opts.Whiteouts && isOpaque(path):
+			return writeWhiteout(tw, name+"/"+opaqueWhiteout)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// entryHeader returns the header of the file at path, archived with the given name, or nil for
+// the files which can't be archived
+func entryHeader(path, name string, info os.FileInfo, stat *unix.Stat_t, opts CreateOptions) (*tar.Header, error) {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    int64(stat.Mode & 07777),
+		Uid:     int(stat.Uid),
+		Gid:     int(stat.Gid),
+		ModTime: info.ModTime(),
+		Format:  tar.FormatPAX,
+	}
+	switch stat.Mode & unix.S_IFMT {
+	case unix.S_IFDIR:
+		hdr.Typeflag = tar.TypeDir
+		hdr.Name += "/"
+	case unix.S_IFREG:
+		hdr.Typeflag = tar.TypeReg
+		hdr.Size = info.Size()
+	case unix.S_IFLNK:
+		target, err := os.Readlink(path)
+		if err != nil {
+			return nil, err
+		}
+		hdr.Typeflag = tar.TypeSymlink
+		hdr.Linkname = target
+	case unix.S_IFCHR, unix.S_IFBLK:
+		hdr.Typeflag = tar.TypeChar
+		if stat.Mode&unix.S_IFMT == unix.S_IFBLK {
+			hdr.Typeflag = tar.TypeBlock
+		}
+		hdr.Devmajor = int64(unix.Major(uint64(stat.Rdev)))
+		hdr.Devminor = int64(unix.Minor(uint64(stat.Rdev)))
+	case unix.S_IFIFO:
+		hdr.Typeflag = tar.TypeFifo
+	default:
+		return nil, nil
+	}
+
+	if opts.MapOwner != nil {
+		var err error
+		if hdr.Uid, hdr.Gid, err = opts.MapOwner(hdr.Uid, hdr.Gid); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	xattrs, err := readXattrs(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading the extended attributes of %s: %w", path, err)
+	}
+	for key, value := range xattrs {
+		if hdr.PAXRecords == nil {
+			hdr.PAXRecords = map[string]string{}
+		}
+		hdr.PAXRecords[xattrPrefix+key] = value
+	}
+	return hdr, nil
+}
+
+// writeWhiteout writes the whiteout entry with the given name
+func writeWhiteout(tw *tar.Writer, name string) error {
+	return tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Format: tar.FormatPAX})
+}
+
+// copyFile copies the content of the regular file at path to the archive
+func copyFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// isOpaque reports whether overlayfs marked the directory at path as opaque, hiding the content
+// the lower layers have in it
+func isOpaque(path string) bool {
+	for _, prefix := range overlayXattrPrefixes {
+		value := make([]byte, 1)
+		n, err := unix.Lgetxattr(path, prefix+"opaque", value)
+		if err == nil && n == 1 && value[0] == 'y' {
+			return true
+		}
+	}
+	return false
+}
+
+// readXattrs returns the extended attributes of the file at path, except the overlayfs metadata and
+// the SELinux label, which the host policy assigns
+func readXattrs(path string) (map[string]string, error) {
+	size, err := unix.Llistxattr(path, nil)
+	if err == unix.ENOTSUP || size == 0 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	list := make([]byte, size)
+	if size, err = unix.Llistxattr(path, list); err != nil {
+		return nil, err
+	}
+
+	xattrs := map[string]string{}
+	for _, key := range strings.Split(strings.TrimRight(string(list[:size]), "\x00"), "\x00") {
+		if key == "security.selinux" || isOverlayXattr(key) {
+			continue
+		}
+		size, err := unix.Lgetxattr(path, key, nil)
+		if err == unix.ENODATA {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, size)
+		if size, err = unix.Lgetxattr(path, key, value); err != nil {
+			return nil, err
+		}
+		xattrs[key] = string(value[:size])
+	}
+	return xattrs, nil
+}
+
+func isOverlayXattr(key string) bool {
+	for _, prefix := range overlayXattrPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
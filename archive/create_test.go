@@ -0,0 +1,107 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"golang.org/x/sys/unix"
+)
+
+// readTar returns the headers of the entries of the archive, by name
+func readTar(archive []byte) map[string]*tar.Header {
+	headers := map[string]*tar.Header{}
+	tr := tar.NewReader(bytes.NewReader(archive))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return headers
+		}
+		Expect(err).NotTo(HaveOccurred())
+		headers[hdr.Name] = hdr
+	}
+}
+
+var _ = Describe("Create", func() {
+
+	var (
+		dir string
+		src string
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "coso-archive")
+		Expect(err).NotTo(HaveOccurred())
+		src = filepath.Join(dir, "upper")
+		Expect(os.MkdirAll(filepath.Join(src, "etc"), 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(src, "etc", "hostname"), []byte("coso"), 0600)).To(Succeed())
+		Expect(os.Symlink("/usr/share/zoneinfo/UTC", filepath.Join(src, "etc", "localtime"))).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	create := func(opts CreateOptions) []byte {
+		var buf bytes.Buffer
+		Expect(Create(&buf, src, opts)).To(Succeed())
+		return buf.Bytes()
+	}
+
+	It("archives directories which can be extracted back", func() {
+		archive := create(CreateOptions{})
+
+		headers := readTar(archive)
+		Expect(headers).To(HaveKey("etc/"))
+		Expect(headers["etc/hostname"].Mode).To(Equal(int64(0600)))
+		Expect(headers["etc/localtime"].Linkname).To(Equal("/usr/share/zoneinfo/UTC"))
+
+		dest := filepath.Join(dir, "rootfs")
+		Expect(Extract(bytes.NewReader(archive), dest, Options{Rootless: os.Geteuid() != 0})).To(Succeed())
+		content, err := os.ReadFile(filepath.Join(dest, "etc", "hostname"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).To(Equal("coso"))
+	})
+
+	It("archives hardlinks as links to the first name", func() {
+		Expect(os.Link(filepath.Join(src, "etc", "hostname"), filepath.Join(src, "etc", "name"))).To(Succeed())
+
+		headers := readTar(create(CreateOptions{}))
+		Expect(headers["etc/hostname"].Typeflag).To(Equal(byte(tar.TypeReg)))
+		Expect(headers["etc/name"].Typeflag).To(Equal(byte(tar.TypeLink)))
+		Expect(headers["etc/name"].Linkname).To(Equal("etc/hostname"))
+	})
+
+	It("maps the owners of the files", func() {
+		headers := readTar(create(CreateOptions{
+			MapOwner: func(uid, gid int) (int, int, error) { return uid + 1000, gid + 2000, nil },
+		}))
+		Expect(headers["etc/hostname"].Uid).To(Equal(os.Getuid() + 1000))
+		Expect(headers["etc/hostname"].Gid).To(Equal(os.Getgid() + 2000))
+	})
+
+	It("turns overlayfs whiteouts into OCI whiteouts", func() {
+		if os.Geteuid() != 0 {
+			Skip("overlayfs whiteouts can only be created by root")
+		}
+		Expect(unix.Mknod(filepath.Join(src, "etc", "motd"), unix.S_IFCHR|0, 0)).To(Succeed())
+		Expect(os.Mkdir(filepath.Join(src, "var"), 0755)).To(Succeed())
+		Expect(unix.Lsetxattr(filepath.Join(src, "var"), "trusted.overlay.opaque", []byte("y"), 0)).To(Succeed())
+
+		headers := readTar(create(CreateOptions{Whiteouts: true}))
+		Expect(headers).To(HaveKey("etc/.wh.motd"))
+		Expect(headers).NotTo(HaveKey("etc/motd"))
+		Expect(headers).To(HaveKey("var/.wh..wh..opq"))
+		Expect(headers["var/"].PAXRecords).NotTo(HaveKey("SCHILY.xattr.trusted.overlay.opaque"))
+
+		headers = readTar(create(CreateOptions{}))
+		Expect(headers["etc/motd"].Typeflag).To(Equal(byte(tar.TypeChar)))
+		Expect(headers).NotTo(HaveKey("var/.wh..wh..opq"))
+	})
+})
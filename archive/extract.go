@@ -0,0 +1,284 @@
+// Package archive safely extracts tar archives, such as root filesystems, into a directory, and archives
+// directories, such as the writable layers of containers
+package archive
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/NamelessOne91/coso/filesystem"
+)
+
+const (
+	// xattrPrefix prefixes the PAX records holding extended attributes
+	xattrPrefix = "SCHILY.xattr."
+
+	whiteoutPrefix = ".wh."
+	opaqueWhiteout = ".wh..wh..opq"
+)
+
+// Options controls how entries are extracted
+type Options struct {
+	// MapOwner maps the owner of an entry, as stored in the archive, to the owner on the host.
+	// The ownership is preserved when nil.
+	MapOwner func(uid, gid int) (int, int, error)
+	// Rootless skips device nodes and ignores failures to set ownership and extended attributes,
+	// which require privileges
+	Rootless bool
+	// Whiteouts applies the OCI whiteouts of image layers extracted on top of each other: .wh.<name>
+	// entries delete <name>, while .wh..wh..opq entries delete what the lower layers put in their directory
+	Whiteouts bool
+}
+
+// Extract extracts the tar archive read from r into dest, which is created if missing.
+//
+// Entry names and hardlink targets are resolved inside dest, so that neither ".." components nor
+// symlinks extracted earlier can make an entry be written outside of it.
+func Extract(r io.Reader, dest string, opts Options) error {
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+
+	// directory permissions and times are restored last: extracting their content changes the times,
+	// and read only directories couldn't be written to when running without CAP_DAC_OVERRIDE
+	type dirAttributes struct {
+		path  string
+		mode  os.FileMode
+		mtime time.Time
+	}
+	var dirs []dirAttributes
+	// the entries of this archive are kept by opaque whiteouts
+	extracted := map[string]bool{}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		target, err := entryPath(dest, hdr.Name)
+		if err != nil {
+			return err
+		}
+		if opts.Whiteouts && strings.HasPrefix(filepath.Base(target), whiteoutPrefix) {
+			if err := applyWhiteout(dest, target, extracted); err != nil {
+				return fmt.Errorf("applying whiteout %s: %w", hdr.Name, err)
+			}
+			continue
+		}
+
+		ok, err := extractEntry(tr, hdr, dest, target, opts)
+		if err != nil {
+			return fmt.Errorf("extracting %s: %w", hdr.Name, err)
+		}
+		if ok {
+			extracted[target] = true
+		}
+		if ok && hdr.Typeflag == tar.TypeDir {
+			dirs = append(dirs, dirAttributes{target, entryMode(hdr), hdr.ModTime})
+		}
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Chmod(dirs[i].path, dirs[i].mode); err != nil {
+			return err
+		}
+		if err := setTimes(dirs[i].path, dirs[i].mtime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyWhiteout deletes the file hidden by the whiteout at target or, for opaque whiteouts,
+// the content of its directory which wasn't extracted from the same archive
+func applyWhiteout(dest, target string, extracted map[string]bool) error {
+	dir, name := filepath.Split(target)
+	if name == opaqueWhiteout {
+		entries, err := os.ReadDir(dir)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+			if !extracted[path] {
+				if err := os.RemoveAll(path); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	name = strings.TrimPrefix(name, whiteoutPrefix)
+	// other .wh..wh. entries are metadata of the aufs storage driver
+	if strings.HasPrefix(name, whiteoutPrefix) || name == "" || name == "." || name == ".." {
+		return nil
+	}
+	return os.RemoveAll(filepath.Join(dir, name))
+}
+
+// entryPath returns the path an entry is extracted to: its parent directory is resolved inside dest,
+// while its last component is never followed, so that the entry replaces any existing symlink
+func entryPath(dest, name string) (string, error) {
+	name = filepath.Clean("/" + name)
+	if name == "/" {
+		return dest, nil
+	}
+
+	parent, err := filesystem.SecureJoin(dest, filepath.Dir(name))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(parent, filepath.Base(name)), nil
+}
+
+// extractEntry creates the file described by hdr at target, reporting whether it was extracted
+func extractEntry(tr *tar.Reader, hdr *tar.Header, dest, target string, opts Options) (bool, error) {
+	switch hdr.Typeflag {
+	case tar.TypeChar, tar.TypeBlock:
+		// device nodes can't be created inside a user namespace
+		if opts.Rootless {
+			return false, nil
+		}
+	case tar.TypeDir, tar.TypeReg, tar.TypeRegA, tar.TypeSymlink, tar.TypeLink, tar.TypeFifo:
+	default:
+		return false, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return false, err
+	}
+	if err := removeExisting(target, hdr.Typeflag == tar.TypeDir); err != nil {
+		return false, err
+	}
+
+	mode := uint32(hdr.Mode & 07777)
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.Mkdir(target, 0755); err != nil && !os.IsExist(err) {
+			return false, err
+		}
+	case tar.TypeReg, tar.TypeRegA:
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY|unix.O_NOFOLLOW, 0600)
+		if err != nil {
+			return false, err
+		}
+		_, err = io.Copy(f, tr)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return false, err
+		}
+	case tar.TypeSymlink:
+		if err := os.Symlink(hdr.Linkname, target); err != nil {
+			return false, err
+		}
+	case tar.TypeLink:
+		source, err := entryPath(dest, hdr.Linkname)
+		if err != nil {
+			return false, err
+		}
+		// hardlinks share the attributes of their source, which have already been set
+		return true, os.Link(source, target)
+	case tar.TypeChar:
+		if err := unix.Mknod(target, unix.S_IFCHR|mode, int(unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor)))); err != nil {
+			return false, err
+		}
+	case tar.TypeBlock:
+		if err := unix.Mknod(target, unix.S_IFBLK|mode, int(unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor)))); err != nil {
+			return false, err
+		}
+	case tar.TypeFifo:
+		if err := unix.Mkfifo(target, mode); err != nil {
+			return false, err
+		}
+	}
+
+	return true, setAttributes(hdr, target, opts)
+}
+
+// removeExisting removes the file at target, unless both it and the new entry are directories,
+// so that entries of later archives, or later entries of the same archive, replace the earlier ones
+func removeExisting(target string, dir bool) error {
+	info, err := os.Lstat(target)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if dir && info.IsDir() {
+		return nil
+	}
+	return os.RemoveAll(target)
+}
+
+// setAttributes applies the ownership, extended attributes, permissions and times of the entry,
+// in this order since changing the owner clears the setuid and setgid bits.
+// The permissions and times of directories are left to Extract.
+func setAttributes(hdr *tar.Header, target string, opts Options) error {
+	uid, gid := hdr.Uid, hdr.Gid
+	if opts.MapOwner != nil {
+		var err error
+		if uid, gid, err = opts.MapOwner(uid, gid); err != nil {
+			return err
+		}
+	}
+	if err := os.Lchown(target, uid, gid); err != nil && !(opts.Rootless && errors.Is(err, os.ErrPermission)) {
+		return err
+	}
+
+	for key, value := range hdr.PAXRecords {
+		name, found := strings.CutPrefix(key, xattrPrefix)
+		if !found {
+			continue
+		}
+		err := unix.Lsetxattr(target, name, []byte(value), 0)
+		// not every filesystem supports extended attributes, and unprivileged users can't set every namespace
+		if err == unix.ENOTSUP || (opts.Rootless && err == unix.EPERM) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("setting extended attribute %s: %w", name, err)
+		}
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		return nil
+	case tar.TypeSymlink:
+		return setTimes(target, hdr.ModTime)
+	}
+	if err := os.Chmod(target, entryMode(hdr)); err != nil {
+		return err
+	}
+	return setTimes(target, hdr.ModTime)
+}
+
+// entryMode returns the permission bits of the entry, including the setuid, setgid and sticky bits
+func entryMode(hdr *tar.Header) os.FileMode {
+	return hdr.FileInfo().Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+}
+
+// setTimes sets the access and modification times of the file at path, without following symlinks
+func setTimes(path string, mtime time.Time) error {
+	ts := unix.NsecToTimespec(mtime.UnixNano())
+	return unix.UtimesNanoAt(unix.AT_FDCWD, path, []unix.Timespec{ts, ts}, unix.AT_SYMLINK_NOFOLLOW)
+}
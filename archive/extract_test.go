@@ -0,0 +1,258 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// buildTar returns a tar archive holding the given entries, regular files when no type is set
+func buildTar(headers ...*tar.Header) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range headers {
+		if hdr.Typeflag == 0 {
+			hdr.Typeflag = tar.TypeReg
+		}
+		if hdr.Mode == 0 {
+			hdr.Mode = 0644
+		}
+		Expect(tw.WriteHeader(hdr)).To(Succeed())
+		if hdr.Typeflag == tar.TypeReg {
+			_, err := tw.Write([]byte(hdr.Name))
+			Expect(err).NotTo(HaveOccurred())
+		}
+	}
+	Expect(tw.Close()).To(Succeed())
+	return buf.Bytes()
+}
+
+// regular returns the header of a regular file whose content is its name
+func regular(name string) *tar.Header {
+	return &tar.Header{Name: name, Size: int64(len(name)), Uid: os.Getuid(), Gid: os.Getgid()}
+}
+
+var _ = Describe("Extract", func() {
+
+	var (
+		dir  string
+		dest string
+		opts Options
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "coso-archive")
+		Expect(err).NotTo(HaveOccurred())
+		dest = filepath.Join(dir, "rootfs")
+		opts = Options{Rootless: os.Geteuid() != 0}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("extracts directories, files and symlinks", func() {
+		mtime := time.Date(2023, 6, 30, 0, 0, 0, 0, time.UTC)
+		archive := buildTar(
+			&tar.Header{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: mtime, Uid: os.Getuid(), Gid: os.Getgid()},
+			&tar.Header{Name: "etc/hostname", Size: 12, Mode: 0600, Uid: os.Getuid(), Gid: os.Getgid()},
+			&tar.Header{Name: "etc/localtime", Typeflag: tar.TypeSymlink, Linkname: "/usr/share/zoneinfo/UTC"},
+		)
+
+		Expect(Extract(bytes.NewReader(archive), dest, opts)).To(Succeed())
+
+		content, err := os.ReadFile(filepath.Join(dest, "etc", "hostname"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).To(Equal("etc/hostname"))
+
+		info, err := os.Stat(filepath.Join(dest, "etc", "hostname"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+
+		target, err := os.Readlink(filepath.Join(dest, "etc", "localtime"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(target).To(Equal("/usr/share/zoneinfo/UTC"))
+
+		info, err = os.Stat(filepath.Join(dest, "etc"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.ModTime().Equal(mtime)).To(BeTrue())
+	})
+
+	It("applies the permissions of directories after extracting their content", func() {
+		archive := buildTar(
+			&tar.Header{Name: "usr/", Typeflag: tar.TypeDir, Mode: 0555, Uid: os.Getuid(), Gid: os.Getgid()},
+			regular("usr/file"),
+		)
+
+		Expect(Extract(bytes.NewReader(archive), dest, opts)).To(Succeed())
+		defer os.Chmod(filepath.Join(dest, "usr"), 0755)
+
+		Expect(filepath.Join(dest, "usr", "file")).To(BeARegularFile())
+		info, err := os.Stat(filepath.Join(dest, "usr"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0555)))
+	})
+
+	It("keeps entries with .. components inside the destination", func() {
+		archive := buildTar(regular("../../escaped"))
+
+		Expect(Extract(bytes.NewReader(archive), dest, opts)).To(Succeed())
+
+		Expect(filepath.Join(dest, "escaped")).To(BeARegularFile())
+		Expect(filepath.Join(dir, "escaped")).NotTo(BeAnExistingFile())
+	})
+
+	It("resolves symlinks extracted earlier inside the destination", func() {
+		archive := buildTar(
+			&tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: dir},
+			regular("link/escaped"),
+		)
+
+		Expect(Extract(bytes.NewReader(archive), dest, opts)).To(Succeed())
+
+		Expect(filepath.Join(dir, "escaped")).NotTo(BeAnExistingFile())
+		Expect(filepath.Join(dest, dir, "escaped")).To(BeARegularFile())
+	})
+
+	It("replaces symlinks instead of writing through them", func() {
+		outside := filepath.Join(dir, "outside")
+		Expect(os.WriteFile(outside, []byte("host"), 0644)).To(Succeed())
+		archive := buildTar(
+			&tar.Header{Name: "file", Typeflag: tar.TypeSymlink, Linkname: outside},
+			regular("file"),
+		)
+
+		Expect(Extract(bytes.NewReader(archive), dest, opts)).To(Succeed())
+
+		content, err := os.ReadFile(outside)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).To(Equal("host"))
+		Expect(filepath.Join(dest, "file")).To(BeARegularFile())
+	})
+
+	It("creates hardlinks to entries inside the destination", func() {
+		archive := buildTar(
+			regular("bin/busybox"),
+			&tar.Header{Name: "bin/sh", Typeflag: tar.TypeLink, Linkname: "bin/busybox"},
+			&tar.Header{Name: "bin/escaped", Typeflag: tar.TypeLink, Linkname: "../../../etc/passwd"},
+		)
+
+		err := Extract(bytes.NewReader(archive), dest, opts)
+		Expect(err).To(HaveOccurred())
+
+		source, err := os.Stat(filepath.Join(dest, "bin", "busybox"))
+		Expect(err).NotTo(HaveOccurred())
+		link, err := os.Stat(filepath.Join(dest, "bin", "sh"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.SameFile(source, link)).To(BeTrue())
+		Expect(filepath.Join(dest, "bin", "escaped")).NotTo(BeAnExistingFile())
+	})
+
+	It("maps the owners of the entries", func() {
+		var owners [][2]int
+		opts.MapOwner = func(uid, gid int) (int, int, error) {
+			owners = append(owners, [2]int{uid, gid})
+			return os.Getuid(), os.Getgid(), nil
+		}
+		archive := buildTar(&tar.Header{Name: "file", Size: 4, Uid: 100, Gid: 101})
+
+		Expect(Extract(bytes.NewReader(archive), dest, opts)).To(Succeed())
+		Expect(owners).To(Equal([][2]int{{100, 101}}))
+	})
+
+	It("applies whiteouts", func() {
+		lower := buildTar(regular("etc/motd"), regular("etc/hostname"), regular("cache/index"), regular("cache/keep"))
+		Expect(Extract(bytes.NewReader(lower), dest, opts)).To(Succeed())
+
+		opts.Whiteouts = true
+		upper := buildTar(
+			regular("etc/.wh.motd"),
+			regular("cache/keep"),
+			regular("cache/.wh..wh..opq"),
+			regular(".wh..."),
+		)
+		Expect(Extract(bytes.NewReader(upper), dest, opts)).To(Succeed())
+
+		Expect(filepath.Join(dest, "etc", "motd")).NotTo(BeAnExistingFile())
+		Expect(filepath.Join(dest, "etc", ".wh.motd")).NotTo(BeAnExistingFile())
+		Expect(filepath.Join(dest, "etc", "hostname")).To(BeARegularFile())
+		Expect(filepath.Join(dest, "cache", "index")).NotTo(BeAnExistingFile())
+		Expect(filepath.Join(dest, "cache", "keep")).To(BeARegularFile())
+		Expect(dest).To(BeADirectory())
+	})
+
+	It("skips device nodes when rootless", func() {
+		opts.Rootless = true
+		archive := buildTar(
+			&tar.Header{Name: "dev/null", Typeflag: tar.TypeChar, Mode: 0666, Devmajor: 1, Devminor: 3},
+			regular("etc/hostname"),
+		)
+
+		Expect(Extract(bytes.NewReader(archive), dest, opts)).To(Succeed())
+		Expect(filepath.Join(dest, "dev", "null")).NotTo(BeAnExistingFile())
+		Expect(filepath.Join(dest, "etc", "hostname")).To(BeARegularFile())
+	})
+})
+
+var _ = Describe("Decompress", func() {
+
+	archive := func() []byte {
+		return buildTar(regular("etc/hostname"))
+	}
+
+	readAll := func(r io.Reader) []byte {
+		rc, err := Decompress(r)
+		Expect(err).NotTo(HaveOccurred())
+		defer rc.Close()
+		content, err := io.ReadAll(rc)
+		Expect(err).NotTo(HaveOccurred())
+		return content
+	}
+
+	It("returns uncompressed streams as they are", func() {
+		Expect(readAll(bytes.NewReader(archive()))).To(Equal(archive()))
+	})
+
+	It("decompresses gzip streams", func() {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		_, err := zw.Write(archive())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(zw.Close()).To(Succeed())
+
+		Expect(readAll(&buf)).To(Equal(archive()))
+	})
+
+	It("decompresses xz streams", func() {
+		var buf bytes.Buffer
+		xw, err := xz.NewWriter(&buf)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = xw.Write(archive())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(xw.Close()).To(Succeed())
+
+		Expect(readAll(&buf)).To(Equal(archive()))
+	})
+
+	It("decompresses zstd streams", func() {
+		var buf bytes.Buffer
+		zw, err := zstd.NewWriter(&buf)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = zw.Write(archive())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(zw.Close()).To(Succeed())
+
+		Expect(readAll(&buf)).To(Equal(archive()))
+	})
+})
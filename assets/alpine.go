@@ -0,0 +1,18 @@
+//go:build embedrootfs && amd64
+
+// the archive holds x86_64 binaries, so it is only embedded in amd64 builds
+
+package assets
+
+import _ "embed"
+
+//go:embed alpine-minirootfs-3.18.2-x86_64.tar.gz
+var alpine []byte
+
+func init() {
+	EmbeddedRootfs = &Rootfs{
+		Name:    "alpine-3.18.2",
+		SHA256:  "6c0be6213d2718087e1f4e7847e711cea288dd6cbd92c436af2c22756ac7db53",
+		Archive: alpine,
+	}
+}
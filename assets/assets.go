@@ -0,0 +1,45 @@
+// Package assets holds the files which can be embedded in the coso binary.
+//
+// The Alpine minirootfs is only embedded when building for amd64 with the embedrootfs tag:
+//
+//	go build -tags embedrootfs ./cmd/coso
+package assets
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+// Rootfs is a root filesystem archive embedded in the binary
+type Rootfs struct {
+	// Name is the name the root filesystem is imported with
+	Name string
+	// SHA256 is the expected hex encoded digest of Archive
+	SHA256  string
+	Archive []byte
+}
+
+// EmbeddedRootfs is nil unless the binary is built for amd64 with the embedrootfs tag
+var EmbeddedRootfs *Rootfs
+
+// StoreName returns the name the root filesystem is imported with when extracted with the options
+// identified by extractID, so that each ID mapping gets its own copy: Name itself for an empty ID
+func (r *Rootfs) StoreName(extractID string) string {
+	if extractID == "" {
+		return r.Name
+	}
+	sum := sha256.Sum256([]byte(extractID))
+	return r.Name + "-" + hex.EncodeToString(sum[:])[:12]
+}
+
+// Open verifies the digest of the archive and returns a reader of its content
+func (r *Rootfs) Open() (io.Reader, error) {
+	sum := sha256.Sum256(r.Archive)
+	if digest := hex.EncodeToString(sum[:]); digest != r.SHA256 {
+		return nil, fmt.Errorf("the embedded root filesystem '%s' is corrupted: sha256 %s, expected %s", r.Name, digest, r.SHA256)
+	}
+	return bytes.NewReader(r.Archive), nil
+}
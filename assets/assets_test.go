@@ -0,0 +1,51 @@
+package assets
+
+import (
+	"io"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Rootfs", func() {
+
+	It("returns the archive when its digest matches", func() {
+		r := &Rootfs{
+			Name:    "test",
+			SHA256:  "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+			Archive: []byte("foo"),
+		}
+
+		reader, err := r.Open()
+		Expect(err).NotTo(HaveOccurred())
+		content, err := io.ReadAll(reader)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).To(Equal("foo"))
+	})
+
+	It("fails when the archive is corrupted", func() {
+		r := &Rootfs{
+			Name:    "test",
+			SHA256:  "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+			Archive: []byte("bar"),
+		}
+
+		_, err := r.Open()
+		Expect(err).To(MatchError(ContainSubstring("corrupted")))
+	})
+
+	It("names a copy for each set of extract options", func() {
+		r := &Rootfs{Name: "alpine"}
+		Expect(r.StoreName("")).To(Equal("alpine"))
+		Expect(r.StoreName("uid=0:0:1 gid=0:0:1")).To(MatchRegexp(`^alpine-[0-9a-f]{12}$`))
+		Expect(r.StoreName("uid=0:0:1 gid=0:0:1")).NotTo(Equal(r.StoreName("uid=0:1000:1 gid=0:1000:1")))
+	})
+
+	It("verifies the embedded root filesystem, when built in", func() {
+		if EmbeddedRootfs == nil {
+			Skip("built without the embedrootfs tag")
+		}
+		_, err := EmbeddedRootfs.Open()
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
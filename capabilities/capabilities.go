@@ -0,0 +1,244 @@
+// Package capabilities restricts the Linux capabilities available to a container's workload
+package capabilities
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// all can be passed to cap-add and cap-drop to refer to every known capability
+	all = "ALL"
+	// lastCapPath holds the highest capability supported by the running kernel
+	lastCapPath = "/proc/sys/kernel/cap_last_cap"
+)
+
+// DefaultSet is the reduced set of capabilities granted to a container, the same Docker uses
+var DefaultSet = []string{
+	"CAP_AUDIT_WRITE",
+	"CAP_CHOWN",
+	"CAP_DAC_OVERRIDE",
+	"CAP_FOWNER",
+	"CAP_FSETID",
+	"CAP_KILL",
+	"CAP_MKNOD",
+	"CAP_NET_BIND_SERVICE",
+	"CAP_NET_RAW",
+	"CAP_SETFCAP",
+	"CAP_SETGID",
+	"CAP_SETPCAP",
+	"CAP_SETUID",
+	"CAP_SYS_CHROOT",
+}
+
+// byName maps each capability name to its number
+var byName = map[string]int{
+	"CAP_CHOWN":              unix.CAP_CHOWN,
+	"CAP_DAC_OVERRIDE":       unix.CAP_DAC_OVERRIDE,
+	"CAP_DAC_READ_SEARCH":    unix.CAP_DAC_READ_SEARCH,
+	"CAP_FOWNER":             unix.CAP_FOWNER,
+	"CAP_FSETID":             unix.CAP_FSETID,
+	"CAP_KILL":               unix.CAP_KILL,
+	"CAP_SETGID":             unix.CAP_SETGID,
+	"CAP_SETUID":             unix.CAP_SETUID,
+	"CAP_SETPCAP":            unix.CAP_SETPCAP,
+	"CAP_LINUX_IMMUTABLE":    unix.CAP_LINUX_IMMUTABLE,
+	"CAP_NET_BIND_SERVICE":   unix.CAP_NET_BIND_SERVICE,
+	"CAP_NET_BROADCAST":      unix.CAP_NET_BROADCAST,
+	"CAP_NET_ADMIN":          unix.CAP_NET_ADMIN,
+	"CAP_NET_RAW":            unix.CAP_NET_RAW,
+	"CAP_IPC_LOCK":           unix.CAP_IPC_LOCK,
+	"CAP_IPC_OWNER":          unix.CAP_IPC_OWNER,
+	"CAP_SYS_MODULE":         unix.CAP_SYS_MODULE,
+	"CAP_SYS_RAWIO":          unix.CAP_SYS_RAWIO,
+	"CAP_SYS_CHROOT":         unix.CAP_SYS_CHROOT,
+	"CAP_SYS_PTRACE":         unix.CAP_SYS_PTRACE,
+	"CAP_SYS_PACCT":          unix.CAP_SYS_PACCT,
+	"CAP_SYS_ADMIN":          unix.CAP_SYS_ADMIN,
+	"CAP_SYS_BOOT":           unix.CAP_SYS_BOOT,
+	"CAP_SYS_NICE":           unix.CAP_SYS_NICE,
+	"CAP_SYS_RESOURCE":       unix.CAP_SYS_RESOURCE,
+	"CAP_SYS_TIME":           unix.CAP_SYS_TIME,
+	"CAP_SYS_TTY_CONFIG":     unix.CAP_SYS_TTY_CONFIG,
+	"CAP_MKNOD":              unix.CAP_MKNOD,
+	"CAP_LEASE":              unix.CAP_LEASE,
+	"CAP_AUDIT_WRITE":        unix.CAP_AUDIT_WRITE,
+	"CAP_AUDIT_CONTROL":      unix.CAP_AUDIT_CONTROL,
+	"CAP_SETFCAP":            unix.CAP_SETFCAP,
+	"CAP_MAC_OVERRIDE":       unix.CAP_MAC_OVERRIDE,
+	"CAP_MAC_ADMIN":          unix.CAP_MAC_ADMIN,
+	"CAP_SYSLOG":             unix.CAP_SYSLOG,
+	"CAP_WAKE_ALARM":         unix.CAP_WAKE_ALARM,
+	"CAP_BLOCK_SUSPEND":      unix.CAP_BLOCK_SUSPEND,
+	"CAP_AUDIT_READ":         unix.CAP_AUDIT_READ,
+	"CAP_PERFMON":            unix.CAP_PERFMON,
+	"CAP_BPF":                unix.CAP_BPF,
+	"CAP_CHECKPOINT_RESTORE": unix.CAP_CHECKPOINT_RESTORE,
+}
+
+// Normalize returns the canonical, upper case and CAP_ prefixed, form of a capability name
+func Normalize(name string) (string, error) {
+	normalized := strings.ToUpper(name)
+	if normalized == all {
+		return all, nil
+	}
+	if !strings.HasPrefix(normalized, "CAP_") {
+		normalized = "CAP_" + normalized
+	}
+	if _, known := byName[normalized]; !known {
+		return "", fmt.Errorf("unknown capability '%s'", name)
+	}
+	return normalized, nil
+}
+
+// Resolve adds and removes capabilities from the base set, returning the sorted result.
+// ALL can be used in both add and drop: drops are applied after additions, unless ALL is dropped.
+func Resolve(base, add, drop []string) ([]string, error) {
+	set := make(map[string]bool)
+	for _, c := range base {
+		set[c] = true
+	}
+
+	normalizedDrop := make([]string, 0, len(drop))
+	for _, c := range drop {
+		n, err := Normalize(c)
+		if err != nil {
+			return nil, err
+		}
+		if n == all {
+			set = make(map[string]bool)
+			continue
+		}
+		normalizedDrop = append(normalizedDrop, n)
+	}
+
+	for _, c := range add {
+		n, err := Normalize(c)
+		if err != nil {
+			return nil, err
+		}
+		if n == all {
+			for name := range byName {
+				set[name] = true
+			}
+			continue
+		}
+		set[n] = true
+	}
+
+	for _, c := range normalizedDrop {
+		delete(set, c)
+	}
+
+	caps := make([]string, 0, len(set))
+	for c := range set {
+		caps = append(caps, c)
+	}
+	sort.Strings(caps)
+	return caps, nil
+}
+
+// LimitBoundingSet drops from the calling thread's bounding set every capability not in caps.
+// Capabilities outside of the bounding set can never be gained again, not even executing setuid or file capable binaries.
+func LimitBoundingSet(caps []string) error {
+	keep, err := toNumbers(caps)
+	if err != nil {
+		return err
+	}
+
+	lastCap, err := lastSupported()
+	if err != nil {
+		return err
+	}
+	for c := 0; c <= lastCap; c++ {
+		if keep[c] {
+			continue
+		}
+		if err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(c), 0, 0, 0); err != nil {
+			return fmt.Errorf("unable to drop capability %d from the bounding set: %s", c, err)
+		}
+	}
+	return nil
+}
+
+// KeepOnSetuid tells whether the calling thread retains its permitted capabilities
+// when switching all of its user IDs to non-zero values
+func KeepOnSetuid(keep bool) error {
+	value := 0
+	if keep {
+		value = 1
+	}
+	return unix.Prctl(unix.PR_SET_KEEPCAPS, uintptr(value), 0, 0, 0)
+}
+
+// Set sets the effective, permitted and inheritable capabilities of the calling thread to caps
+func Set(caps []string) error {
+	numbers, err := toNumbers(caps)
+	if err != nil {
+		return err
+	}
+
+	// the version 3 header uses two 32 bit words for each set
+	header := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	data := [2]unix.CapUserData{}
+	for c := range numbers {
+		word, bit := c/32, uint32(1)<<(c%32)
+		data[word].Effective |= bit
+		data[word].Permitted |= bit
+		data[word].Inheritable |= bit
+	}
+
+	return unix.Capset(&header, &data[0])
+}
+
+// RaiseAmbient adds caps to the ambient set of the calling thread, so that they are preserved
+// when a non root user executes a binary. They must be both permitted and inheritable.
+func RaiseAmbient(caps []string) error {
+	numbers, err := toNumbers(caps)
+	if err != nil {
+		return err
+	}
+
+	for c := range numbers {
+		if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_RAISE, uintptr(c), 0, 0); err != nil {
+			return fmt.Errorf("unable to raise ambient capability %d: %s", c, err)
+		}
+	}
+	return nil
+}
+
+// SetNoNewPrivileges ensures execve can't grant privileges the calling thread doesn't already have,
+// for example through setuid binaries or file capabilities
+func SetNoNewPrivileges() error {
+	return unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0)
+}
+
+// toNumbers converts capability names to a set of capability numbers
+func toNumbers(caps []string) (map[int]bool, error) {
+	numbers := make(map[int]bool, len(caps))
+	for _, c := range caps {
+		n, err := Normalize(c)
+		if err != nil {
+			return nil, err
+		}
+		if n == all {
+			return nil, fmt.Errorf("ALL is not allowed in a resolved capability set")
+		}
+		numbers[byName[n]] = true
+	}
+	return numbers, nil
+}
+
+// lastSupported returns the highest capability number supported by the running kernel
+func lastSupported() (int, error) {
+	content, err := os.ReadFile(lastCapPath)
+	if err != nil {
+		return unix.CAP_LAST_CAP, nil
+	}
+	return strconv.Atoi(strings.TrimSpace(string(content)))
+}
@@ -0,0 +1,54 @@
+package capabilities
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Capabilities", func() {
+
+	Describe("Normalize", func() {
+		It("adds the CAP_ prefix and upper cases names", func() {
+			name, err := Normalize("net_admin")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(name).To(Equal("CAP_NET_ADMIN"))
+		})
+
+		It("accepts ALL", func() {
+			name, err := Normalize("all")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(name).To(Equal("ALL"))
+		})
+
+		It("rejects unknown capabilities", func() {
+			_, err := Normalize("CAP_FLY")
+			Expect(err).To(MatchError(ContainSubstring("unknown capability")))
+		})
+	})
+
+	Describe("Resolve", func() {
+		It("adds and drops capabilities from the base set", func() {
+			caps, err := Resolve([]string{"CAP_CHOWN", "CAP_KILL"}, []string{"SYS_ADMIN"}, []string{"kill"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(caps).To(Equal([]string{"CAP_CHOWN", "CAP_SYS_ADMIN"}))
+		})
+
+		It("drops everything but the added capabilities when dropping ALL", func() {
+			caps, err := Resolve(DefaultSet, []string{"NET_BIND_SERVICE"}, []string{"ALL"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(caps).To(Equal([]string{"CAP_NET_BIND_SERVICE"}))
+		})
+
+		It("adds every known capability when adding ALL", func() {
+			caps, err := Resolve(nil, []string{"ALL"}, []string{"SYS_MODULE"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(caps).To(HaveLen(len(byName) - 1))
+			Expect(caps).NotTo(ContainElement("CAP_SYS_MODULE"))
+		})
+
+		It("returns an error for unknown capabilities", func() {
+			_, err := Resolve(DefaultSet, []string{"CAP_FLY"}, nil)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
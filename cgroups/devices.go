@@ -0,0 +1,142 @@
+package cgroups
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	cgroupRoot = "/sys/fs/cgroup"
+	// devicesV1Path is the cgroup v1 devices hierarchy holding the containers' cgroups
+	devicesV1Path = cgroupRoot + "/devices/coso"
+	// unifiedPath is the cgroup v2 directory holding the containers' cgroups
+	unifiedPath = cgroupRoot + "/coso"
+	// Wildcard matches any major or minor device number
+	Wildcard int64 = -1
+)
+
+// DeviceRule allows or denies access to the devices matching its type and numbers
+type DeviceRule struct {
+	Allow bool
+	// Type is 'c' for character devices, 'b' for block devices and 'a' for both
+	Type rune
+	// Major and Minor are the device numbers, or Wildcard
+	Major int64
+	Minor int64
+	// Access is a combination of 'r' (read), 'w' (write) and 'm' (mknod)
+	Access string
+}
+
+// DefaultDeviceRules are allowed in every container, besides the default device nodes:
+// creating any device node, which doesn't grant access to it, and using pseudo terminals
+var DefaultDeviceRules = []DeviceRule{
+	{Allow: true, Type: 'c', Major: Wildcard, Minor: Wildcard, Access: "m"},
+	{Allow: true, Type: 'b', Major: Wildcard, Minor: Wildcard, Access: "m"},
+	{Allow: true, Type: 'c', Major: 5, Minor: 2, Access: "rwm"},
+	{Allow: true, Type: 'c', Major: 136, Minor: Wildcard, Access: "rwm"},
+}
+
+// String formats the rule as expected by the devices.allow and devices.deny files of cgroup v1
+func (r DeviceRule) String() string {
+	number := func(n int64) string {
+		if n == Wildcard {
+			return "*"
+		}
+		return strconv.FormatInt(n, 10)
+	}
+	return fmt.Sprintf("%c %s:%s %s", r.Type, number(r.Major), number(r.Minor), r.Access)
+}
+
+// LimitDevices moves the process with the given pid to a new cgroup, named after the container,
+// which denies access to every device not allowed by rules.
+//
+// On cgroup v1 the rules are written to the devices controller, on cgroup v2 they are
+// compiled into an eBPF program attached to the cgroup.
+func LimitDevices(name string, pid int, rules []DeviceRule) error {
+	unified, err := isUnified()
+	if err != nil {
+		return err
+	}
+
+	path := deviceCgroupPath(name, unified)
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+
+	if unified {
+		if err := attachDeviceFilter(path, rules); err != nil {
+			return err
+		}
+	} else if err := writeDeviceRules(path, rules); err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(path, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644)
+}
+
+// RemoveDeviceCgroup removes the devices cgroup of the container, once all of its processes have exited
+func RemoveDeviceCgroup(name string) error {
+	unified, err := isUnified()
+	if err != nil {
+		return err
+	}
+	if err := unix.Rmdir(deviceCgroupPath(name, unified)); err != nil && err != unix.ENOENT {
+		return err
+	}
+	return nil
+}
+
+// writeDeviceRules denies access to all devices and then applies the rules, in order
+func writeDeviceRules(path string, rules []DeviceRule) error {
+	if err := os.WriteFile(filepath.Join(path, "devices.deny"), []byte("a"), 0644); err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		file := "devices.deny"
+		if rule.Allow {
+			file = "devices.allow"
+		}
+		if err := os.WriteFile(filepath.Join(path, file), []byte(rule.String()), 0644); err != nil {
+			return fmt.Errorf("%s: %w", rule, err)
+		}
+	}
+	return nil
+}
+
+func deviceCgroupPath(name string, unified bool) string {
+	if unified {
+		return filepath.Join(unifiedPath, name)
+	}
+	return filepath.Join(devicesV1Path, name)
+}
+
+// isUnified reports whether the host uses the cgroup v2 unified hierarchy
+func isUnified() (bool, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(cgroupRoot, &stat); err != nil {
+		return false, err
+	}
+	return stat.Type == unix.CGROUP2_SUPER_MAGIC, nil
+}
+
+// accessMask converts the access string of a rule into BPF_DEVCG_ACC_* flags
+func accessMask(access string) (uint32, error) {
+	var mask uint32
+	for _, c := range access {
+		switch c {
+		case 'r':
+			mask |= unix.BPF_DEVCG_ACC_READ
+		case 'w':
+			mask |= unix.BPF_DEVCG_ACC_WRITE
+		case 'm':
+			mask |= unix.BPF_DEVCG_ACC_MKNOD
+		default:
+			return 0, fmt.Errorf("invalid device access %q", access)
+		}
+	}
+	return mask, nil
+}
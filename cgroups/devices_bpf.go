@@ -0,0 +1,184 @@
+package cgroups
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// eBPF registers used by the device filter
+const (
+	regRet    = 0 // return value
+	regCtx    = 1 // struct bpf_cgroup_dev_ctx *, then scratch
+	regType   = 2
+	regAccess = 3
+	regMajor  = 4
+	regMinor  = 5
+)
+
+// insn is an eBPF instruction, as struct bpf_insn
+type insn struct {
+	code uint8
+	regs uint8 // dst_reg in the low nibble, src_reg in the high one
+	off  int16
+	imm  int32
+}
+
+// bpfProgLoadAttr is the prefix of union bpf_attr used by BPF_PROG_LOAD
+type bpfProgLoadAttr struct {
+	progType    uint32
+	insnCnt     uint32
+	insns       uint64
+	license     uint64
+	logLevel    uint32
+	logSize     uint32
+	logBuf      uint64
+	kernVersion uint32
+	progFlags   uint32
+}
+
+// bpfProgAttachAttr is the prefix of union bpf_attr used by BPF_PROG_ATTACH
+type bpfProgAttachAttr struct {
+	targetFd    uint32
+	attachBpfFd uint32
+	attachType  uint32
+	attachFlags uint32
+}
+
+func loadCtx(dst uint8, off int16) insn {
+	return insn{code: unix.BPF_LDX | unix.BPF_MEM | unix.BPF_W, regs: dst | regCtx<<4, off: off}
+}
+
+func alu(op uint8, dst uint8, imm int32) insn {
+	return insn{code: unix.BPF_ALU64 | op | unix.BPF_K, regs: dst, imm: imm}
+}
+
+func movReg(dst, src uint8) insn {
+	return insn{code: unix.BPF_ALU64 | unix.BPF_MOV | unix.BPF_X, regs: dst | src<<4}
+}
+
+func jumpNotEqual(dst uint8, imm int32, off int16) insn {
+	return insn{code: unix.BPF_JMP | unix.BPF_JNE | unix.BPF_K, regs: dst, imm: imm, off: off}
+}
+
+func jumpNotEqualReg(dst, src uint8, off int16) insn {
+	return insn{code: unix.BPF_JMP | unix.BPF_JNE | unix.BPF_X, regs: dst | src<<4, off: off}
+}
+
+func exit(verdict int32) []insn {
+	return []insn{
+		alu(unix.BPF_MOV, regRet, verdict),
+		{code: unix.BPF_JMP | unix.BPF_EXIT},
+	}
+}
+
+// compileDeviceFilter builds a BPF_PROG_TYPE_CGROUP_DEVICE program returning the verdict
+// of the first rule matching the accessed device, and denying access when none does
+func compileDeviceFilter(rules []DeviceRule) ([]insn, error) {
+	program := []insn{
+		loadCtx(regType, 0),
+		alu(unix.BPF_AND, regType, 0xffff),
+		loadCtx(regAccess, 0),
+		alu(unix.BPF_RSH, regAccess, 16),
+		loadCtx(regMajor, 4),
+		loadCtx(regMinor, 8),
+	}
+
+	for _, rule := range rules {
+		var checks []insn
+
+		switch rule.Type {
+		case 'a':
+		case 'c':
+			checks = append(checks, jumpNotEqual(regType, unix.BPF_DEVCG_DEV_CHAR, 0))
+		case 'b':
+			checks = append(checks, jumpNotEqual(regType, unix.BPF_DEVCG_DEV_BLOCK, 0))
+		default:
+			return nil, fmt.Errorf("invalid device type %q", rule.Type)
+		}
+
+		mask, err := accessMask(rule.Access)
+		if err != nil {
+			return nil, err
+		}
+		// the requested access must be a subset of the rule's one
+		if mask != unix.BPF_DEVCG_ACC_READ|unix.BPF_DEVCG_ACC_WRITE|unix.BPF_DEVCG_ACC_MKNOD {
+			checks = append(checks,
+				movReg(regCtx, regAccess),
+				alu(unix.BPF_AND, regCtx, int32(mask)),
+				jumpNotEqualReg(regCtx, regAccess, 0),
+			)
+		}
+
+		if rule.Major != Wildcard {
+			checks = append(checks, jumpNotEqual(regMajor, int32(rule.Major), 0))
+		}
+		if rule.Minor != Wildcard {
+			checks = append(checks, jumpNotEqual(regMinor, int32(rule.Minor), 0))
+		}
+
+		verdict := int32(0)
+		if rule.Allow {
+			verdict = 1
+		}
+		block := append(checks, exit(verdict)...)
+		// failed checks skip to the next rule
+		for i := range checks {
+			if checks[i].code&0x07 == unix.BPF_JMP {
+				block[i].off = int16(len(block) - i - 1)
+			}
+		}
+		program = append(program, block...)
+	}
+
+	return append(program, exit(0)...), nil
+}
+
+// attachDeviceFilter loads the device filter and attaches it to the cgroup at path.
+// The program stays attached until the cgroup is removed.
+func attachDeviceFilter(path string, rules []DeviceRule) error {
+	program, err := compileDeviceFilter(rules)
+	if err != nil {
+		return err
+	}
+
+	code := make([]byte, 0, len(program)*8)
+	for _, ins := range program {
+		code = append(code, ins.code, ins.regs)
+		code = binary.LittleEndian.AppendUint16(code, uint16(ins.off))
+		code = binary.LittleEndian.AppendUint32(code, uint32(ins.imm))
+	}
+	license := []byte("Apache\x00")
+
+	load := bpfProgLoadAttr{
+		progType: unix.BPF_PROG_TYPE_CGROUP_DEVICE,
+		insnCnt:  uint32(len(program)),
+		insns:    uint64(uintptr(unsafe.Pointer(&code[0]))),
+		license:  uint64(uintptr(unsafe.Pointer(&license[0]))),
+	}
+	progFd, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_PROG_LOAD, uintptr(unsafe.Pointer(&load)), unsafe.Sizeof(load))
+	if errno != 0 {
+		return fmt.Errorf("loading the device filter: %w", errno)
+	}
+	defer unix.Close(int(progFd))
+
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	attach := bpfProgAttachAttr{
+		targetFd:    uint32(dir.Fd()),
+		attachBpfFd: uint32(progFd),
+		attachType:  unix.BPF_CGROUP_DEVICE,
+		attachFlags: unix.BPF_F_ALLOW_MULTI,
+	}
+	if _, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_PROG_ATTACH, uintptr(unsafe.Pointer(&attach)), unsafe.Sizeof(attach)); errno != 0 {
+		return fmt.Errorf("attaching the device filter: %w", errno)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package cgroups
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"golang.org/x/sys/unix"
+)
+
+var _ = Describe("Devices", func() {
+
+	Describe("DeviceRule", func() {
+		DescribeTable("formats cgroup v1 rules",
+			func(rule DeviceRule, expected string) {
+				Expect(rule.String()).To(Equal(expected))
+			},
+			Entry("a single device", DeviceRule{Type: 'c', Major: 1, Minor: 3, Access: "rwm"}, "c 1:3 rwm"),
+			Entry("any minor", DeviceRule{Type: 'c', Major: 136, Minor: Wildcard, Access: "rw"}, "c 136:* rw"),
+			Entry("any device", DeviceRule{Type: 'a', Major: Wildcard, Minor: Wildcard, Access: "m"}, "a *:* m"),
+		)
+	})
+
+	Describe("compileDeviceFilter", func() {
+		rules := []DeviceRule{
+			{Allow: false, Type: 'c', Major: 1, Minor: 9, Access: "rwm"},
+			{Allow: true, Type: 'c', Major: 1, Minor: Wildcard, Access: "rw"},
+			{Allow: true, Type: 'b', Major: 7, Minor: 0, Access: "r"},
+			{Allow: true, Type: 'a', Major: Wildcard, Minor: Wildcard, Access: "m"},
+		}
+
+		DescribeTable("applies the first matching rule",
+			func(devType, access, major, minor uint32, allowed bool) {
+				program, err := compileDeviceFilter(rules)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(run(program, devType|access<<16, major, minor)).To(Equal(allowed))
+			},
+			Entry("denied device", uint32(unix.BPF_DEVCG_DEV_CHAR), uint32(unix.BPF_DEVCG_ACC_READ), uint32(1), uint32(9), false),
+			Entry("allowed major", uint32(unix.BPF_DEVCG_DEV_CHAR), uint32(unix.BPF_DEVCG_ACC_READ|unix.BPF_DEVCG_ACC_WRITE), uint32(1), uint32(3), true),
+			Entry("access outside the rule", uint32(unix.BPF_DEVCG_DEV_CHAR), uint32(unix.BPF_DEVCG_ACC_MKNOD|unix.BPF_DEVCG_ACC_READ), uint32(1), uint32(3), false),
+			Entry("read only block device", uint32(unix.BPF_DEVCG_DEV_BLOCK), uint32(unix.BPF_DEVCG_ACC_READ), uint32(7), uint32(0), true),
+			Entry("write to a read only block device", uint32(unix.BPF_DEVCG_DEV_BLOCK), uint32(unix.BPF_DEVCG_ACC_WRITE), uint32(7), uint32(0), false),
+			Entry("type mismatch", uint32(unix.BPF_DEVCG_DEV_CHAR), uint32(unix.BPF_DEVCG_ACC_READ), uint32(7), uint32(0), false),
+			Entry("mknod of any device", uint32(unix.BPF_DEVCG_DEV_BLOCK), uint32(unix.BPF_DEVCG_ACC_MKNOD), uint32(8), uint32(1), true),
+			Entry("unlisted device", uint32(unix.BPF_DEVCG_DEV_CHAR), uint32(unix.BPF_DEVCG_ACC_READ), uint32(10), uint32(229), false),
+		)
+
+		It("rejects invalid rules", func() {
+			_, err := compileDeviceFilter([]DeviceRule{{Allow: true, Type: 'x', Access: "r"}})
+			Expect(err).To(HaveOccurred())
+
+			_, err = compileDeviceFilter([]DeviceRule{{Allow: true, Type: 'c', Access: "rx"}})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
+
+// run interprets the subset of eBPF used by the device filter against a bpf_cgroup_dev_ctx,
+// reporting whether access is allowed
+func run(program []insn, accessType, major, minor uint32) bool {
+	ctx := []uint32{accessType, major, minor}
+	var regs [11]uint64
+
+	for pc := 0; pc < len(program); pc++ {
+		ins := program[pc]
+		dst, src := ins.regs&0x0f, ins.regs>>4
+		operand := uint64(int64(ins.imm))
+		if ins.code&unix.BPF_X != 0 {
+			operand = regs[src]
+		}
+
+		switch ins.code & 0x07 {
+		case unix.BPF_LDX:
+			regs[dst] = uint64(ctx[ins.off/4])
+		case unix.BPF_ALU64:
+			switch ins.code & 0xf0 {
+			case unix.BPF_MOV:
+				regs[dst] = operand
+			case unix.BPF_AND:
+				regs[dst] &= operand
+			case unix.BPF_RSH:
+				regs[dst] >>= operand
+			default:
+				Fail("unexpected ALU operation")
+			}
+		case unix.BPF_JMP:
+			switch ins.code & 0xf0 {
+			case unix.BPF_EXIT:
+				return regs[0] == 1
+			case unix.BPF_JNE:
+				if regs[dst] != operand {
+					pc += int(ins.off)
+				}
+			default:
+				Fail("unexpected jump")
+			}
+		default:
+			Fail("unexpected instruction class")
+		}
+	}
+	Fail("the program didn't exit")
+	return false
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/NamelessOne91/coso/filesystem"
+	"github.com/NamelessOne91/coso/image"
+	"github.com/NamelessOne91/coso/spec"
+)
+
+// errNoSuchContainer is returned when no container matches a reference
+var errNoSuchContainer = errors.New("no such container")
+
+// containerCommand handles the container ls, rm and commit commands
+func containerCommand(args []string) {
+	if len(args) == 0 {
+		fmt.Println("Usage: coso container ls|rm|commit")
+		os.Exit(1)
+	}
+
+	var err error
+	switch args[0] {
+	case "ls":
+		err = containerList(args[1:])
+	case "rm":
+		err = containerRemove(args[1:])
+	case "commit":
+		err = containerCommit(args[1:])
+	default:
+		err = fmt.Errorf("unknown container command '%s', expected one of ls, rm or commit", args[0])
+	}
+	if err != nil {
+		fmt.Printf("Error - %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// containerState describes a container whose directory exists
+type containerState struct {
+	spec    *spec.Spec
+	path    string
+	running bool
+}
+
+// listContainers returns the running containers and the ones whose writable layer was kept
+func listContainers() ([]containerState, error) {
+	ids, err := filesystem.ContainerIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	containers := make([]containerState, 0, len(ids))
+	for _, id := range ids {
+		path := filesystem.ContainerPath(id)
+		s, err := spec.Load(filepath.Join(path, filesystem.SpecFile))
+		if err != nil {
+			// the container is still being created, or was run by a version not saving the spec
+			s = &spec.Spec{ID: id}
+		}
+		containers = append(containers, containerState{spec: s, path: path, running: filesystem.IsRunning(path)})
+	}
+	return containers, nil
+}
+
+// findContainer returns the container with the given ID, unique ID prefix or name
+func findContainer(ref string) (containerState, error) {
+	containers, err := listContainers()
+	if err != nil {
+		return containerState{}, err
+	}
+
+	var matches []containerState
+	for _, c := range containers {
+		if c.spec.ID == ref {
+			return c, nil
+		}
+		if c.spec.Name == ref || strings.HasPrefix(c.spec.ID, ref) {
+			matches = append(matches, c)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return containerState{}, fmt.Errorf("%w: %s", errNoSuchContainer, ref)
+	case 1:
+		return matches[0], nil
+	default:
+		return containerState{}, fmt.Errorf("%s matches %d containers, use a longer ID", ref, len(matches))
+	}
+}
+
+func containerList(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("usage: coso container ls")
+	}
+	containers, err := listContainers()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(w, "CONTAINER ID\tNAME\tSTATUS\tROOTFS")
+	for _, c := range containers {
+		status := "exited"
+		if c.running {
+			status = "running"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", c.spec.ShortID(), c.spec.Name, status, c.spec.Rootfs)
+	}
+	return w.Flush()
+}
+
+func containerRemove(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: coso container rm CONTAINER...")
+	}
+
+	for _, ref := range args {
+		c, err := findContainer(ref)
+		if err != nil {
+			return err
+		}
+		if c.running {
+			return fmt.Errorf("container %s is running", ref)
+		}
+		if err := filesystem.RemoveContainer(c.path); err != nil {
+			return err
+		}
+		fmt.Println(ref)
+	}
+	return nil
+}
+
+func containerCommit(args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return fmt.Errorf("usage: coso container commit CONTAINER [REPOSITORY[:TAG]]")
+	}
+	var tags []string
+	if len(args) == 2 {
+		tags = args[1:]
+	}
+
+	img, err := commitContainer(args[0], tags)
+	if err != nil {
+		return err
+	}
+	fmt.Println(img.ID)
+	return nil
+}
+
+// commitContainer adds the image made of the root filesystem of the exited container, with the changes
+// stored in its writable layer, to the image store, tagged with tags
+func commitContainer(ref string, tags []string) (*image.Image, error) {
+	c, err := findContainer(ref)
+	if err != nil {
+		return nil, err
+	}
+	if c.running {
+		return nil, fmt.Errorf("container %s is running, it can be committed once it exited", ref)
+	}
+	s := c.spec
+	if s.Rootfs == "" {
+		return nil, fmt.Errorf("container %s can't be committed: its spec wasn't saved", ref)
+	}
+
+	store, err := imageStore()
+	if err != nil {
+		return nil, err
+	}
+	upper, err := filesystem.MountUpper(c.path, s.StorageQuota)
+	if err != nil {
+		return nil, fmt.Errorf("container %s: %w", ref, err)
+	}
+	defer filesystem.ReleaseStorage(c.path, s.StorageQuota)
+
+	opts := image.CommitOptions{
+		Parent:    s.Image,
+		Rootfs:    s.Rootfs,
+		Tags:      tags,
+		Archive:   archiveOptions(s.UIDMappings, s.GIDMappings),
+		Extract:   extractOptions(s.UIDMappings, s.GIDMappings),
+		ExtractID: extractID(s.UIDMappings, s.GIDMappings),
+	}
+	// containers created from an image keep its defaults, the other ones get the ones they ran with
+	if s.Image == "" {
+		opts.Config = image.ContainerConfig{User: s.Process.User, Env: s.Process.Env, Cmd: s.Process.Args, WorkingDir: s.Process.Cwd}
+	}
+	return store.Commit(upper, opts)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"flag"
+	"strings"
+)
+
+// stringSlice is a flag.Value collecting the values of a flag which can be repeated
+type stringSlice []string
+
+func (s *stringSlice) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSlice) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// isFlagSet reports whether the flag with the given name has been explicitly set on the command line
+func isFlagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/NamelessOne91/coso/command"
+	"github.com/NamelessOne91/coso/image"
+	"github.com/NamelessOne91/coso/spec"
+)
+
+// imageCommand handles the image load, ls, rm and inspect commands
+func imageCommand(args []string) {
+	if len(args) == 0 {
+		fmt.Println("Usage: coso image load|ls|rm|inspect")
+		os.Exit(1)
+	}
+
+	store, err := imageStore()
+	if err != nil {
+		fmt.Printf("Error opening the image store - %s\n", err)
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "load":
+		err = imageLoad(store, args[1:])
+	case "ls":
+		err = imageList(store, args[1:])
+	case "rm":
+		err = imageRemove(store, args[1:])
+	case "inspect":
+		err = imageInspect(store, args[1:])
+	default:
+		err = fmt.Errorf("unknown image command '%s', expected one of load, ls, rm or inspect", args[0])
+	}
+	if err != nil {
+		fmt.Printf("Error - %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func imageStore() (*image.Store, error) {
+	path, err := image.UserStorePath()
+	if err != nil {
+		return nil, err
+	}
+	return image.NewStore(path), nil
+}
+
+func imageLoad(store *image.Store, args []string) error {
+	fs := flag.NewFlagSet("image load", flag.ExitOnError)
+	var tags, uidMaps, gidMaps stringSlice
+	var platform string
+	fs.Var(&tags, "tag", "Reference to tag the loaded image with, replacing the ones in the archive (repeatable)")
+	fs.StringVar(&platform, "platform", "", "Platform, in the os/arch[/variant] format, to load from multi platform images (default: the host one)")
+	fs.Var(&uidMaps, "uidmap", "UID mapping, in the containerID:hostID:size format, the owners of the files are mapped with (repeatable)")
+	fs.Var(&gidMaps, "gidmap", "GID mapping, in the containerID:hostID:size format, the groups of the files are mapped with (repeatable)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: coso image load [--tag REFERENCE] [--platform os/arch] OCI-LAYOUT-DIR|ARCHIVE")
+	}
+
+	opts := image.LoadOptions{Tags: tags}
+	if platform != "" {
+		var err error
+		if opts.Platform, err = image.ParsePlatform(platform); err != nil {
+			return err
+		}
+	}
+	uidMappings, gidMappings, err := idMappings(uidMaps, gidMaps)
+	if err != nil {
+		return err
+	}
+	opts.Extract = extractOptions(uidMappings, gidMappings)
+	opts.ExtractID = extractID(uidMappings, gidMappings)
+
+	images, err := store.Load(fs.Arg(0), opts)
+	for _, img := range images {
+		if len(img.Tags) == 0 {
+			fmt.Printf("Loaded image ID: %s\n", img.ID)
+		}
+		for _, tag := range img.Tags {
+			fmt.Printf("Loaded image: %s\n", image.FamiliarName(tag))
+		}
+	}
+	return err
+}
+
+func imageList(store *image.Store, args []string) error {
+	fs := flag.NewFlagSet("image ls", flag.ExitOnError)
+	quiet := fs.Bool("q", false, "Only print the image IDs")
+	fs.Parse(args)
+
+	images, err := store.List()
+	if err != nil {
+		return err
+	}
+
+	if *quiet {
+		for _, img := range images {
+			fmt.Println(img.ShortID())
+		}
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(w, "REPOSITORY\tTAG\tIMAGE ID\tCREATED\tSIZE")
+	for _, img := range images {
+		created := ""
+		if !img.Created.IsZero() {
+			created = img.Created.Format("2006-01-02 15:04")
+		}
+		tags := img.Tags
+		if len(tags) == 0 {
+			tags = []string{"<none>:<none>"}
+		}
+		for _, tag := range tags {
+			ref := image.FamiliarName(tag)
+			i := strings.LastIndex(ref, ":")
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", ref[:i], ref[i+1:], img.ShortID(), created, formatSize(img.Size))
+		}
+	}
+	return w.Flush()
+}
+
+func imageRemove(store *image.Store, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: coso image rm IMAGE...")
+	}
+
+	for _, ref := range args {
+		deleted, err := store.Remove(ref)
+		if err != nil {
+			return err
+		}
+		if deleted {
+			fmt.Printf("Deleted: %s\n", ref)
+		} else {
+			fmt.Printf("Untagged: %s\n", ref)
+		}
+	}
+	return nil
+}
+
+func imageInspect(store *image.Store, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: coso image inspect IMAGE...")
+	}
+
+	type inspected struct {
+		*image.Image
+		Config *image.Config `json:"config"`
+	}
+	images := make([]inspected, 0, len(args))
+	for _, ref := range args {
+		img, err := store.Get(ref)
+		if err != nil {
+			return err
+		}
+		config, err := store.Config(img)
+		if err != nil {
+			return err
+		}
+		images = append(images, inspected{img, config})
+	}
+
+	out, err := json.MarshalIndent(images, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+// formatSize formats a size in bytes with the largest unit keeping it above 1, e.g. 3.2MB
+func formatSize(size int64) string {
+	units := []string{"B", "kB", "MB", "GB", "TB"}
+	value := float64(size)
+	i := 0
+	for ; value >= 1000 && i < len(units)-1; i++ {
+		value /= 1000
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d%s", size, units[i])
+	}
+	return fmt.Sprintf("%.3g%s", value, units[i])
+}
+
+// containerImage is the image a container is created from
+type containerImage struct {
+	id     string
+	rootfs string
+	config image.ContainerConfig
+}
+
+// openImage looks up the image matching ref in the image store
+func openImage(ref string) (*containerImage, error) {
+	store, err := imageStore()
+	if err != nil {
+		return nil, err
+	}
+	img, err := store.Get(ref)
+	if err != nil {
+		return nil, err
+	}
+	config, err := store.Config(img)
+	if err != nil {
+		return nil, err
+	}
+	return &containerImage{id: img.ID, rootfs: store.RootfsPath(img), config: config.Config}, nil
+}
+
+// resolveImage returns the image the container is created from, named by the first argument, and the
+// command following it. The arguments are just the command when the root filesystem is set with --rootfs
+// or by the spec file, or when the first one names no image, so that any root filesystem can run a command.
+func resolveImage(configPath string, args []string) (*containerImage, []string, error) {
+	if len(args) == 0 || isFlagSet(flag.CommandLine, "rootfs") {
+		return nil, args, nil
+	}
+	if configPath != "" {
+		s, err := spec.Load(configPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		if s.Rootfs != "" {
+			return nil, args, nil
+		}
+	}
+
+	img, err := openImage(args[0])
+	if errors.Is(err, image.ErrNotFound) {
+		return nil, args, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return img, args[1:], nil
+}
+
+// applyImageConfig makes the container use the root filesystem of the image, and the defaults of its
+// configuration for the values the container spec doesn't set. The entrypoint and the command are
+// handled by setupProcess, since they interact with the command line.
+func applyImageConfig(s *spec.Spec, img *containerImage) error {
+	s.Image, s.Rootfs = img.id, img.rootfs
+
+	config := img.config
+	if s.Process.User == "" {
+		s.Process.User = config.User
+	}
+	if s.Process.Cwd == "" {
+		s.Process.Cwd = config.WorkingDir
+	}
+	s.Process.Env = command.MergeEnv(config.Env, s.Process.Env)
+	if s.StopSignal == "" {
+		s.StopSignal = config.StopSignal
+	}
+
+	labels := make(map[string]string, len(config.Labels)+len(s.Labels))
+	for _, values := range []map[string]string{config.Labels, s.Labels} {
+		for key, value := range values {
+			labels[key] = value
+		}
+	}
+	if len(labels) > 0 {
+		s.Labels = labels
+	}
+
+	for value := range config.ExposedPorts {
+		port, err := parseExposedPort(value)
+		if err != nil {
+			return fmt.Errorf("image %s: %w", img.id, err)
+		}
+		s.ExposedPorts = appendUnique(s.ExposedPorts, port)
+	}
+	sort.Strings(s.ExposedPorts)
+	return nil
+}
+
+// parseExposedPort parses a port in the port[/protocol] format, tcp being the default protocol
+func parseExposedPort(value string) (string, error) {
+	port, protocol, found := strings.Cut(value, "/")
+	if !found {
+		protocol = "tcp"
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("invalid port '%s'", value)
+	}
+	if protocol != "tcp" && protocol != "udp" && protocol != "sctp" {
+		return "", fmt.Errorf("invalid protocol '%s' of port '%s', expected tcp, udp or sctp", protocol, value)
+	}
+	return fmt.Sprintf("%d/%s", n, protocol), nil
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
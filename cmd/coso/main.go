@@ -5,11 +5,28 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"os/signal"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
 
+	"github.com/NamelessOne91/coso/assets"
+	"github.com/NamelessOne91/coso/capabilities"
+	"github.com/NamelessOne91/coso/cgroups"
 	"github.com/NamelessOne91/coso/command"
 	"github.com/NamelessOne91/coso/filesystem"
+	"github.com/NamelessOne91/coso/image"
+	"github.com/NamelessOne91/coso/internal/stringslice"
+	"github.com/NamelessOne91/coso/landlock"
 	"github.com/NamelessOne91/coso/namespaces"
 	"github.com/NamelessOne91/coso/network"
+	"github.com/NamelessOne91/coso/rootfs"
+	"github.com/NamelessOne91/coso/seccomp"
+	"github.com/NamelessOne91/coso/spec"
+	"github.com/NamelessOne91/coso/volumes"
 )
 
 func init() {
@@ -21,17 +38,220 @@ func init() {
 }
 
 func main() {
-	var rootfsPath, networkPath string
-	flag.StringVar(&rootfsPath, "rootfs", filesystem.DefaultRootfsPath, "Path to the root filesystem to use")
+	args := os.Args[1:]
+	// run is the default command, so that coso can still be invoked with flags only
+	subcommand := "run"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		subcommand, args = args[0], args[1:]
+	}
+
+	switch subcommand {
+	case "run":
+		run(args)
+	case "volume":
+		volume(args)
+	case "rootfs":
+		rootfsCommand(args)
+	case "image":
+		imageCommand(args)
+	case "pull":
+		pull(args)
+	case "push":
+		push(args)
+	case "container":
+		containerCommand(args)
+	default:
+		fmt.Printf("Unknown command '%s', expected one of run, container, volume, rootfs, image, pull or push\n", subcommand)
+		os.Exit(1)
+	}
+}
+
+// run creates a container and runs its workload, until it exits
+func run(args []string) {
+	var configPath, rootfsPath, networkPath, seccompAuditPath string
+	var name, hostname, domainname, userSpec, workdir, rootfsPropagation, storageSize, storageType string
+	var noNewPrivileges, autoRemove, readOnly bool
+	var uidMaps, gidMaps, groupAdd, env, envFiles, capAdd, capDrop, securityOpts stringSlice
+	var landlockRO, landlockRW, devices, tmpfs, volumes stringSlice
+	var entrypoint, stopSignal string
+	var labels, expose stringSlice
+	flag.StringVar(&configPath, "config", "", "Path to a JSON container spec")
+	flag.StringVar(&rootfsPath, "rootfs", filesystem.DefaultRootfsPath, "Path to the root filesystem to use, or name of an imported one")
 	flag.StringVar(&networkPath, "network", network.DefaultCosonetPath, "Path to the executable handling network devices")
-	flag.Parse()
+	flag.BoolVar(&autoRemove, "rm", true, "Discard the container's writable layer when it exits, --rm=false keeps it")
+	flag.StringVar(&rootfsPropagation, "rootfs-propagation", filesystem.DefaultRootfsPropagation, "Propagation mode of the container's root mount: [r]private, [r]slave or [r]shared")
+	flag.BoolVar(&readOnly, "read-only", false, "Mount the container's root filesystem as read only")
+	flag.Var(&tmpfs, "tmpfs", "Tmpfs mount in the path[:options] format, e.g. /run:size=64m,mode=755 (repeatable)")
+	flag.Var(&volumes, "v", "Bind mount in the host-path|volume-name:container-path[:options] format, options being ro|rw and a propagation mode (repeatable)")
+	flag.Var(&volumes, "volume", "Bind mount in the host-path|volume-name:container-path[:options] format, options being ro|rw and a propagation mode (repeatable)")
+	flag.StringVar(&storageSize, "storage-size", "", "Maximum size of the container's writable layer, e.g. 2g")
+	flag.StringVar(&storageType, "storage-type", filesystem.StorageImage, "Filesystem limiting the size of the writable layer: image (ext4 image on a loop device) or tmpfs")
+	flag.StringVar(&name, "name", "", "Name of the container")
+	flag.StringVar(&hostname, "hostname", "", "Hostname of the container (default: the container name or short ID)")
+	flag.StringVar(&domainname, "domainname", "", "NIS domain name of the container")
+	flag.Var(&uidMaps, "uidmap", "UID mapping for the user namespace in the containerID:hostID:size format (repeatable)")
+	flag.Var(&gidMaps, "gidmap", "GID mapping for the user namespace in the containerID:hostID:size format (repeatable)")
+	flag.StringVar(&userSpec, "user", "", "User to run the workload as, in the name|uid[:group|gid] format")
+	flag.Var(&groupAdd, "group-add", "Additional group, name or gid, the workload belongs to (repeatable)")
+	flag.Var(&env, "e", "Environment variable of the workload in the KEY[=VALUE] format (repeatable)")
+	flag.Var(&env, "env", "Environment variable of the workload in the KEY[=VALUE] format (repeatable)")
+	flag.Var(&envFiles, "env-file", "File listing environment variables of the workload, one per line (repeatable)")
+	flag.StringVar(&workdir, "w", "", "Working directory of the workload inside the container")
+	flag.StringVar(&workdir, "workdir", "", "Working directory of the workload inside the container")
+	flag.Var(&capAdd, "cap-add", "Linux capability to add to the default set, or ALL (repeatable)")
+	flag.Var(&capDrop, "cap-drop", "Linux capability to drop from the default set, or ALL (repeatable)")
+	flag.Var(&securityOpts, "security-opt", "Security option: seccomp=<profile.json>|unconfined (repeatable)")
+	flag.StringVar(&seccompAuditPath, "seccomp-audit", "", "Path to a JSON policy listing the syscalls to log and allow or deny")
+	flag.Var(&landlockRO, "landlock-ro", "Path inside the container the workload can read and execute from, restricting it with Landlock (repeatable)")
+	flag.Var(&landlockRW, "landlock-rw", "Path inside the container the workload can fully access, restricting it with Landlock (repeatable)")
+	flag.Var(&devices, "device", "Host device to expose in the container, in the host-path[:container-path][:permissions] format (repeatable)")
+	flag.BoolVar(&noNewPrivileges, "no-new-privileges", true, "Prevent the workload from gaining new privileges through setuid binaries or file capabilities")
+	flag.StringVar(&entrypoint, "entrypoint", "", "Executable overriding the image entrypoint, an empty string resets it")
+	flag.Var(&labels, "label", "Label of the container in the KEY=VALUE format (repeatable)")
+	flag.Var(&expose, "expose", "Port, in the port[/protocol] format, the workload listens on (repeatable)")
+	flag.StringVar(&stopSignal, "stop-signal", "", "Signal the workload receives when coso is terminated (default: the image one, or SIGTERM)")
+	flag.CommandLine.Parse(args)
+
+	img, cmdArgs, err := resolveImage(configPath, flag.CommandLine.Args())
+	if err != nil {
+		fmt.Printf("Error opening the image - %s\n", err)
+		os.Exit(1)
+	}
+
+	containerSpec, err := buildSpec(configPath, rootfsPath, img, name, hostname, domainname, userSpec, workdir, uidMaps, gidMaps, groupAdd, env, envFiles)
+	if err != nil {
+		fmt.Printf("Error building the container spec - %s\n", err)
+		os.Exit(1)
+	}
+	if err := setupProcess(containerSpec, img, entrypoint, cmdArgs, labels, expose, stopSignal); err != nil {
+		fmt.Printf("Error configuring the workload - %s\n", err)
+		os.Exit(1)
+	}
+	if err := setupSecurity(containerSpec, capAdd, capDrop, securityOpts, seccompAuditPath, noNewPrivileges); err != nil {
+		fmt.Printf("Error configuring the container security options - %s\n", err)
+		os.Exit(1)
+	}
+	if err := setupLandlock(containerSpec, landlockRO, landlockRW); err != nil {
+		fmt.Printf("Error configuring Landlock - %s\n", err)
+		os.Exit(1)
+	}
+
+	if err := setupDevices(containerSpec, devices); err != nil {
+		fmt.Printf("Error configuring devices - %s\n", err)
+		os.Exit(1)
+	}
+
+	if err := setupMounts(containerSpec, rootfsPropagation, readOnly, volumes, tmpfs); err != nil {
+		fmt.Printf("Error configuring mounts - %s\n", err)
+		os.Exit(1)
+	}
+	if isFlagSet(flag.CommandLine, "rm") {
+		containerSpec.AutoRemove = autoRemove
+	}
+	if err := setupStorage(containerSpec, storageSize, storageType); err != nil {
+		fmt.Printf("Error configuring the storage quota - %s\n", err)
+		os.Exit(1)
+	}
 
-	filesystem.VerifyRootfsExists(rootfsPath)
+	filesystem.VerifyRootfsExists(containerSpec.Rootfs)
 	network.VerifyNetworkManagerExists(networkPath)
 
+	if err := runContainer(containerSpec, networkPath); err != nil {
+		fmt.Printf("Error - %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// runContainer starts the container and waits for its workload to exit. The host resources
+// prepared for the container are released however it ends, even when it fails to start.
+func runContainer(containerSpec *spec.Spec, networkPath string) error {
+	containerPath := filesystem.ContainerPath(containerSpec.ID)
+	if err := filesystem.PrepareOverlay(containerPath, containerSpec.StorageQuota); err != nil {
+		return fmt.Errorf("preparing the container's writable layer: %w", err)
+	}
+	defer func() {
+		if err := filesystem.ReleaseStorage(containerPath, containerSpec.StorageQuota); err != nil {
+			fmt.Printf("Error unmounting the container's storage - %s\n", err)
+		}
+		if containerSpec.AutoRemove {
+			if err := filesystem.RemoveContainer(containerPath); err != nil {
+				fmt.Printf("Error removing the container's writable layer - %s\n", err)
+			}
+		}
+	}()
+
+	running, err := filesystem.LockRunning(containerPath)
+	if err != nil {
+		return err
+	}
+	defer running.Close()
+
+	// neither the image nor the imported root filesystem can be removed while the container runs on it
+	if containerSpec.Image != "" {
+		lock, err := image.LockRootfs(containerSpec.Rootfs)
+		if err != nil {
+			return fmt.Errorf("using the root filesystem of image %s: %w", containerSpec.Image, err)
+		}
+		defer lock.Close()
+	} else {
+		lock, err := rootfs.Lock(containerSpec.Rootfs)
+		if err != nil {
+			return fmt.Errorf("using the root filesystem %s: %w", containerSpec.Rootfs, err)
+		}
+		defer lock.Close()
+	}
+
+	// volumes are referenced by the container while it runs
+	store, err := volumeStore()
+	if err != nil {
+		return fmt.Errorf("opening the volume store: %w", err)
+	}
+	volumeNames, err := acquireVolumes(store, containerSpec)
+	if err != nil {
+		return fmt.Errorf("preparing volumes: %w", err)
+	}
+	defer releaseVolumes(store, containerSpec.ID, volumeNames)
+
+	encodedSpec, err := containerSpec.Encode()
+	if err != nil {
+		return fmt.Errorf("encoding the container spec: %w", err)
+	}
+	// the spec is kept together with the writable layer, so that the container can be listed and removed
+	if err := os.WriteFile(filepath.Join(containerPath, filesystem.SpecFile), []byte(encodedSpec), 0600); err != nil {
+		return fmt.Errorf("saving the container spec: %w", err)
+	}
+
 	// rexec is used to bypass forking limitations of Go
 	// allowing to run code after the namespace creation but before the process starts
-	cmd := command.NewReexecCommand("nsInit", rootfsPath)
+	cmd := command.NewReexecCommand("nsInit")
+
+	uidMappings := spec.SysProcIDMaps(containerSpec.UIDMappings)
+	gidMappings := spec.SysProcIDMaps(containerSpec.GIDMappings)
+	useIDMapHelpers := command.SetIDMappings(cmd, uidMappings, gidMappings)
+
+	// the init process waits on this pipe until the host side setup is done, and receives the spec through it
+	syncPipe, err := command.NewSyncPipe(cmd)
+	if err != nil {
+		return fmt.Errorf("creating the sync pipe: %w", err)
+	}
+
+	// the init process hands the seccomp notification fd over through this socket,
+	// and receives the trees of the idmapped mounts
+	var fdSocket *command.FdSocket
+	if containerSpec.UsesFdSocket() {
+		if fdSocket, err = command.NewFdSocket(cmd); err != nil {
+			return fmt.Errorf("creating the fd socket: %w", err)
+		}
+	}
+
+	// opened before starting the container: once the audit filter is installed, its syscalls block
+	// until coso handles them
+	var auditLog *os.File
+	if containerSpec.Process.SeccompAudit != nil {
+		if auditLog, err = openAuditLog(containerSpec.Process.SeccompAudit); err != nil {
+			return fmt.Errorf("opening the seccomp audit log: %w", err)
+		}
+	}
 
 	// syscalls here
 	// 1) clone: creates process
@@ -40,9 +260,51 @@ func main() {
 
 	// not blocking
 	if err := cmd.Start(); err != nil {
-		fmt.Printf("Error starting the reexec.Command - %s\n", err)
-		os.Exit(1)
+		return fmt.Errorf("starting the reexec.Command: %w", err)
+	}
+	syncPipe.CloseChildEnd()
+	if fdSocket != nil {
+		fdSocket.CloseChildEnd()
+	}
+	if containerSpec.Process.SeccompAudit != nil {
+		go auditSyscalls(fdSocket, containerSpec.Process.SeccompAudit, auditLog)
+	}
+	go forwardStopSignal(cmd.Process, containerSpec.StopSignal)
+
+	if useIDMapHelpers {
+		if err := command.WriteIDMappings(cmd.Process.Pid, uidMappings, gidMappings); err != nil {
+			syncPipe.Abort(err)
+			cmd.Wait()
+			return fmt.Errorf("writing user namespace ID mappings: %w", err)
+		}
+	}
+	// the user namespace must be fully set up before idmapping mounts with it
+	if err := sendIDMappedTrees(fdSocket, containerSpec, cmd.Process.Pid); err != nil {
+		syncPipe.Abort(err)
+		cmd.Wait()
+		return fmt.Errorf("preparing idmapped mounts: %w", err)
+	}
+
+	// the device controller can only be configured by root in the host's user namespace: rootless containers
+	// are already limited to the devices the invoking user can access on the host
+	if os.Geteuid() == 0 {
+		defer func() {
+			if err := cgroups.RemoveDeviceCgroup(containerSpec.ID); err != nil {
+				fmt.Printf("Error removing the devices cgroup - %s\n", err)
+			}
+		}()
+		if err := cgroups.LimitDevices(containerSpec.ID, cmd.Process.Pid, deviceRules(containerSpec)); err != nil {
+			syncPipe.Abort(err)
+			cmd.Wait()
+			return fmt.Errorf("restricting device access: %w", err)
+		}
 	}
+	if err := syncPipe.Release([]byte(encodedSpec)); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("sending the container spec: %w", err)
+	}
+
 	// child process PID
 	pid := fmt.Sprintf("%d", cmd.Process.Pid)
 
@@ -50,11 +312,494 @@ func main() {
 	netManagerCmd := exec.Command(networkPath, "-pid", pid)
 	if out, err := netManagerCmd.CombinedOutput(); err != nil {
 		fmt.Print(string(out))
-		fmt.Printf("Error running external network manager (default: cosonet) - %s\n", err)
-		os.Exit(1)
+		// the container must be gone before its resources are released
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("running external network manager (default: cosonet): %w", err)
 	}
 
 	if err := cmd.Wait(); err != nil {
 		fmt.Printf("Error waiting for reexec.Command - %s\n", err)
 	}
+	return nil
+}
+
+// buildSpec loads the container spec found at configPath, if any, and overrides its values
+// with the ones explicitly set on the command line
+func buildSpec(configPath, rootfsPath string, img *containerImage, name, hostname, domainname, userSpec, workdir string, uidMaps, gidMaps, groupAdd, env, envFiles []string) (*spec.Spec, error) {
+	s := spec.New()
+	if configPath != "" {
+		var err error
+		if s, err = spec.Load(configPath); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.Rootfs == "" || isFlagSet(flag.CommandLine, "rootfs") {
+		s.Rootfs = rootfsPath
+	}
+	// the values set by the image are overridden by the ones set on the command line
+	if img != nil {
+		if err := applyImageConfig(s, img); err != nil {
+			return nil, err
+		}
+	}
+
+	id, err := spec.NewID()
+	if err != nil {
+		return nil, err
+	}
+	s.ID = id
+	if name != "" {
+		s.Name = name
+	}
+	if hostname != "" {
+		s.Hostname = hostname
+	}
+	if domainname != "" {
+		s.Domainname = domainname
+	}
+	// only an explicit hostname is validated, the one derived from the name is made valid instead
+	if s.Hostname != "" {
+		if err := namespaces.ValidateHostname(s.Hostname); err != nil {
+			return nil, fmt.Errorf("invalid hostname: %s", err)
+		}
+	} else {
+		s.Hostname = namespaces.SanitizeHostname(s.Name)
+	}
+	if s.Hostname == "" {
+		s.Hostname = s.ShortID()
+	}
+	if s.Domainname != "" {
+		if err := namespaces.ValidateHostname(s.Domainname); err != nil {
+			return nil, fmt.Errorf("invalid domain name: %s", err)
+		}
+	}
+	if isFlagSet(flag.CommandLine, "user") {
+		s.Process.User = userSpec
+	}
+	if len(groupAdd) > 0 {
+		s.Process.AdditionalGroups = groupAdd
+	}
+	if workdir != "" {
+		if !filepath.IsAbs(workdir) {
+			return nil, fmt.Errorf("the working directory '%s' must be an absolute path", workdir)
+		}
+		s.Process.Cwd = workdir
+	}
+
+	// variables from env files are overridden by the ones passed with -e
+	var cliEnv []string
+	for _, path := range envFiles {
+		fileEnv, err := command.ReadEnvFile(path)
+		if err != nil {
+			return nil, err
+		}
+		cliEnv = append(cliEnv, fileEnv...)
+	}
+	for _, variable := range env {
+		variable, found, err := command.ParseEnv(variable)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			cliEnv = append(cliEnv, variable)
+		}
+	}
+	s.Process.Env = command.MergeEnv(s.Process.Env, cliEnv)
+
+	if len(uidMaps) > 0 {
+		if s.UIDMappings, err = parseIDMappings(uidMaps); err != nil {
+			return nil, err
+		}
+	}
+	if len(gidMaps) > 0 {
+		if s.GIDMappings, err = parseIDMappings(gidMaps); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(s.UIDMappings) == 0 {
+		s.UIDMappings = defaultIDMappings(spec.SubUIDPath, os.Getuid())
+	}
+	if len(s.GIDMappings) == 0 {
+		s.GIDMappings = defaultIDMappings(spec.SubGIDPath, os.Getgid())
+	}
+
+	// without a root filesystem set up, the embedded one is used
+	if s.Rootfs == filesystem.DefaultRootfsPath && assets.EmbeddedRootfs != nil {
+		if _, err := os.Stat(s.Rootfs); os.IsNotExist(err) {
+			s.Rootfs = assets.EmbeddedRootfs.Name
+		}
+	}
+	if s.Rootfs, err = resolveRootfs(s); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// setupProcess sets the command line of the workload following Docker's precedence: the command given
+// on the command line replaces the image one, while --entrypoint replaces the image entrypoint and
+// resets its command. A spec file setting the arguments replaces both the image entrypoint and command.
+// Without an image and a command, the workload is a shell.
+func setupProcess(s *spec.Spec, img *containerImage, entrypoint string, cmdArgs, labels, expose []string, stopSignal string) error {
+	var entrypointArgs, cmd []string
+	if img != nil {
+		entrypointArgs, cmd = img.config.Entrypoint, img.config.Cmd
+	}
+	if len(s.Process.Args) > 0 {
+		entrypointArgs, cmd = nil, s.Process.Args
+	}
+	if isFlagSet(flag.CommandLine, "entrypoint") {
+		entrypointArgs, cmd = nil, nil
+		if entrypoint != "" {
+			entrypointArgs = []string{entrypoint}
+		}
+	}
+	if len(cmdArgs) > 0 {
+		cmd = cmdArgs
+	}
+	s.Process.Args = append(append([]string(nil), entrypointArgs...), cmd...)
+	if len(s.Process.Args) == 0 {
+		if img != nil {
+			return fmt.Errorf("no command specified: the image sets neither an entrypoint nor a command")
+		}
+		s.Process.Args = []string{"/bin/sh"}
+	}
+
+	for _, label := range labels {
+		key, value, _ := strings.Cut(label, "=")
+		if key == "" {
+			return fmt.Errorf("invalid label '%s', expected KEY=VALUE", label)
+		}
+		if s.Labels == nil {
+			s.Labels = map[string]string{}
+		}
+		s.Labels[key] = value
+	}
+
+	// the ports of the spec file are normalized too, so that 80 and 80/tcp aren't both listed
+	ports := append(s.ExposedPorts, expose...)
+	s.ExposedPorts = nil
+	for _, value := range ports {
+		port, err := parseExposedPort(value)
+		if err != nil {
+			return err
+		}
+		s.ExposedPorts = appendUnique(s.ExposedPorts, port)
+	}
+	sort.Strings(s.ExposedPorts)
+
+	if stopSignal != "" {
+		s.StopSignal = stopSignal
+	}
+	if s.StopSignal != "" {
+		if _, err := command.ParseSignal(s.StopSignal); err != nil {
+			return fmt.Errorf("invalid stop signal: %w", err)
+		}
+	}
+	return nil
+}
+
+// setupSecurity applies the capabilities and privileges related flags to the container spec
+func setupSecurity(s *spec.Spec, capAdd, capDrop, securityOpts []string, seccompAuditPath string, noNewPrivileges bool) error {
+	base := s.Process.Capabilities
+	if base == nil {
+		base = capabilities.DefaultSet
+	}
+
+	caps, err := capabilities.Resolve(base, capAdd, capDrop)
+	if err != nil {
+		return err
+	}
+	s.Process.Capabilities = caps
+
+	// capabilities explicitly added are also granted to non root users, through the ambient set
+	ambient, err := capabilities.Resolve(nil, capAdd, nil)
+	if err != nil {
+		return err
+	}
+	for _, c := range ambient {
+		if stringslice.Contains(caps, c) && !stringslice.Contains(s.Process.AmbientCapabilities, c) {
+			s.Process.AmbientCapabilities = append(s.Process.AmbientCapabilities, c)
+		}
+	}
+
+	if isFlagSet(flag.CommandLine, "no-new-privileges") {
+		s.Process.NoNewPrivileges = noNewPrivileges
+	}
+
+	for _, opt := range securityOpts {
+		key, value, _ := strings.Cut(opt, "=")
+		switch key {
+		case "seccomp":
+			if value == "unconfined" {
+				s.Process.Seccomp = nil
+				continue
+			}
+			profile, err := seccomp.LoadProfile(value)
+			if err != nil {
+				return err
+			}
+			s.Process.Seccomp = profile
+		default:
+			return fmt.Errorf("unknown security option '%s'", opt)
+		}
+	}
+
+	if seccompAuditPath != "" {
+		policy, err := seccomp.LoadAuditPolicy(seccompAuditPath)
+		if err != nil {
+			return err
+		}
+		s.Process.SeccompAudit = policy
+	}
+	return nil
+}
+
+// setupLandlock adds to the container spec the Landlock rules for the given read only and read write paths
+func setupLandlock(s *spec.Spec, readOnly, readWrite []string) error {
+	var rules []landlock.Rule
+	for _, path := range readOnly {
+		rules = append(rules, landlock.Rule{Path: path, Access: landlock.ReadOnly})
+	}
+	for _, path := range readWrite {
+		rules = append(rules, landlock.Rule{Path: path, Access: landlock.ReadWrite})
+	}
+	s.Process.Landlock = append(s.Process.Landlock, rules...)
+
+	for _, rule := range s.Process.Landlock {
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setupMounts applies the root filesystem and mounts related flags to the container spec
+func setupMounts(s *spec.Spec, rootfsPropagation string, readOnly bool, volumes, tmpfs []string) error {
+	if isFlagSet(flag.CommandLine, "rootfs-propagation") {
+		s.RootfsPropagation = rootfsPropagation
+	}
+	if s.RootfsPropagation != "" {
+		if err := filesystem.ValidatePropagation(s.RootfsPropagation); err != nil {
+			return err
+		}
+	}
+	if isFlagSet(flag.CommandLine, "read-only") {
+		s.ReadonlyRootfs = readOnly
+	}
+	for _, v := range volumes {
+		m, err := filesystem.ParseVolume(v)
+		if err != nil {
+			return err
+		}
+		s.Mounts = append(s.Mounts, m)
+	}
+	for _, t := range tmpfs {
+		m, err := filesystem.ParseTmpfs(t)
+		if err != nil {
+			return err
+		}
+		s.Mounts = append(s.Mounts, m)
+	}
+	return nil
+}
+
+// setupStorage applies the storage quota flags to the container spec
+func setupStorage(s *spec.Spec, size, storageType string) error {
+	if size != "" {
+		bytes, err := filesystem.ParseSize(size)
+		if err != nil {
+			return err
+		}
+		s.StorageQuota = &filesystem.StorageQuota{Size: bytes, Type: storageType}
+	} else if s.StorageQuota != nil && isFlagSet(flag.CommandLine, "storage-type") {
+		s.StorageQuota.Type = storageType
+	}
+
+	if s.StorageQuota == nil {
+		return nil
+	}
+	switch s.StorageQuota.Type {
+	case filesystem.StorageTmpfs:
+	case filesystem.StorageImage:
+		// loop devices can only be attached, and ext4 mounted, by root
+		if os.Geteuid() != 0 {
+			return fmt.Errorf("image backed storage requires COSO to be run by root, use --storage-type tmpfs")
+		}
+	default:
+		return fmt.Errorf("unknown storage type '%s', expected image or tmpfs", s.StorageQuota.Type)
+	}
+	return nil
+}
+
+// acquireVolumes references the named volumes mounted by the container, creating the missing ones,
+// and turns their mounts into bind mounts of the volumes' data directories. On failure, the volumes
+// already acquired are released.
+func acquireVolumes(store *volumes.Store, s *spec.Spec) ([]string, error) {
+	var names []string
+	for i, m := range s.Mounts {
+		if m.Type != "volume" {
+			continue
+		}
+		v, err := store.Acquire(m.Source, s.ID)
+		if err != nil {
+			releaseVolumes(store, s.ID, names)
+			return nil, err
+		}
+		names = append(names, v.Name)
+
+		s.Mounts[i].Type = "bind"
+		s.Mounts[i].Source = v.Mountpoint
+	}
+	return names, nil
+}
+
+// releaseVolumes drops the references from the container to the named volumes
+func releaseVolumes(store *volumes.Store, containerID string, names []string) {
+	for _, name := range names {
+		if err := store.Release(name, containerID); err != nil {
+			fmt.Printf("Error releasing volume '%s' - %s\n", name, err)
+		}
+	}
+}
+
+// sendIDMappedTrees idmaps the sources of the idmapped mounts with the user namespace of the init
+// process, and hands them over to it
+func sendIDMappedTrees(fdSocket *command.FdSocket, s *spec.Spec, pid int) error {
+	mounts := s.IDMappedMounts()
+	if len(mounts) == 0 {
+		return nil
+	}
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("idmapped mounts require COSO to be run by root")
+	}
+
+	usernsPath := fmt.Sprintf("/proc/%d/ns/user", pid)
+	trees := make([]*os.File, 0, len(mounts))
+	defer func() {
+		for _, tree := range trees {
+			tree.Close()
+		}
+	}()
+	for _, m := range mounts {
+		tree, err := filesystem.OpenIDMappedTree(m, usernsPath)
+		if err != nil {
+			return err
+		}
+		trees = append(trees, tree)
+	}
+	return fdSocket.Send(trees...)
+}
+
+// setupDevices adds to the container spec the host devices passed with --device, after checking
+// the type of the ones the spec file lists
+func setupDevices(s *spec.Spec, devices []string) error {
+	for _, device := range s.Devices {
+		if device.Type != "c" && device.Type != "b" {
+			return fmt.Errorf("invalid type %q of device %s, expected c or b", device.Type, device.Path)
+		}
+	}
+	for _, d := range devices {
+		device, err := filesystem.ParseDevice(d)
+		if err != nil {
+			return err
+		}
+		s.Devices = append(s.Devices, device)
+	}
+	return nil
+}
+
+// deviceRules lists the device controller rules allowing access only to the default
+// devices and to the ones passed to the container, matching the nodes created by SetupDev
+func deviceRules(s *spec.Spec) []cgroups.DeviceRule {
+	rules := append([]cgroups.DeviceRule(nil), cgroups.DefaultDeviceRules...)
+	for _, device := range filesystem.ContainerDevices(s.Devices) {
+		access := device.Permissions
+		if access == "" {
+			access = "rwm"
+		}
+		rules = append(rules, cgroups.DeviceRule{
+			Allow:  true,
+			Type:   rune(device.Type[0]),
+			Major:  int64(device.Major),
+			Minor:  int64(device.Minor),
+			Access: access,
+		})
+	}
+	return rules
+}
+
+// openAuditLog opens the file the audited syscalls are appended to, stderr unless the policy names one
+func openAuditLog(policy *seccomp.AuditPolicy) (*os.File, error) {
+	if policy.LogPath == "" {
+		return os.Stderr, nil
+	}
+	return os.OpenFile(policy.LogPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+}
+
+// auditSyscalls receives the seccomp notification fd from the init process and
+// handles the audited syscalls until the container exits, logging them to log
+func auditSyscalls(fdSocket *command.FdSocket, policy *seccomp.AuditPolicy, log *os.File) {
+	if log != os.Stderr {
+		defer log.Close()
+	}
+
+	notifyFd, err := fdSocket.Receive()
+	if err != nil {
+		fmt.Printf("Error receiving the seccomp notification fd - %s\n", err)
+		return
+	}
+
+	if err := seccomp.NewAuditor(policy, log).Run(notifyFd); err != nil {
+		fmt.Printf("Error auditing syscalls - %s\n", err)
+	}
+}
+
+// forwardStopSignal sends the stop signal of the container to the workload whenever coso is asked
+// to terminate, so that it can shut down gracefully
+func forwardStopSignal(process *os.Process, stopSignal string) {
+	stop := syscall.SIGTERM
+	if stopSignal != "" {
+		// already validated by setupProcess
+		stop, _ = command.ParseSignal(stopSignal)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM)
+	for range signals {
+		process.Signal(stop)
+	}
+}
+
+// parseIDMappings parses the values of the --uidmap/--gidmap flags
+func parseIDMappings(values []string) ([]spec.IDMapping, error) {
+	mappings := make([]spec.IDMapping, 0, len(values))
+	for _, v := range values {
+		m, err := spec.ParseIDMapping(v)
+		if err != nil {
+			return nil, err
+		}
+		mappings = append(mappings, m)
+	}
+	return mappings, nil
+}
+
+// defaultIDMappings maps root in the container to the given host ID and, when possible,
+// the rest of the container IDs to the invoking user's subordinate range listed in subIDPath
+func defaultIDMappings(subIDPath string, id int) []spec.IDMapping {
+	if !command.CanMapIDRanges() {
+		return []spec.IDMapping{{ContainerID: 0, HostID: id, Size: 1}}
+	}
+
+	// both /etc/subuid and /etc/subgid identify users by name, or by numeric user ID
+	uid := strconv.Itoa(os.Getuid())
+	name := uid
+	if u, err := user.LookupId(uid); err == nil {
+		name = u.Username
+	}
+
+	return spec.DefaultIDMappings(subIDPath, name, os.Getuid(), id)
 }
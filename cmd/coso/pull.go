@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/NamelessOne91/coso/image"
+	"github.com/NamelessOne91/coso/registry"
+)
+
+// pull handles the pull command, downloading an image from its registry into the image store
+func pull(args []string) {
+	fs := flag.NewFlagSet("pull", flag.ExitOnError)
+	var uidMaps, gidMaps stringSlice
+	var platform string
+	fs.StringVar(&platform, "platform", "", "Platform, in the os/arch[/variant] format, to pull from multi platform images (default: the host one)")
+	fs.Var(&uidMaps, "uidmap", "UID mapping, in the containerID:hostID:size format, the owners of the files are mapped with (repeatable)")
+	fs.Var(&gidMaps, "gidmap", "GID mapping, in the containerID:hostID:size format, the groups of the files are mapped with (repeatable)")
+	insecure := fs.Bool("insecure", false, "Reach the registry over plain HTTP, as always done for registries on the loopback interface")
+	quiet := fs.Bool("q", false, "Don't print the downloaded blobs")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Println("Usage: coso pull [--platform os/arch] [--insecure] [REGISTRY/]REPOSITORY[:TAG|@DIGEST]")
+		os.Exit(1)
+	}
+
+	if err := pullImage(fs.Arg(0), platform, uidMaps, gidMaps, *insecure, *quiet); err != nil {
+		fmt.Printf("Error - %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func pullImage(ref, platform string, uidMaps, gidMaps []string, insecure, quiet bool) error {
+	store, err := imageStore()
+	if err != nil {
+		return err
+	}
+
+	var opts image.PullOptions
+	if platform != "" {
+		if opts.Platform, err = image.ParsePlatform(platform); err != nil {
+			return err
+		}
+	}
+	uidMappings, gidMappings, err := idMappings(uidMaps, gidMaps)
+	if err != nil {
+		return err
+	}
+	opts.Extract = extractOptions(uidMappings, gidMappings)
+	opts.ExtractID = extractID(uidMappings, gidMappings)
+	if !quiet {
+		opts.Progress = os.Stdout
+	}
+
+	img, err := store.Pull(registryClient(insecure), ref, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Digest: %s\n", img.ManifestDigest)
+	// the image may have been tagged with other references before
+	reference, err := image.ParseReference(ref)
+	if err != nil {
+		return err
+	}
+	if reference.Tag != "" {
+		fmt.Printf("Pulled image: %s\n", reference.Familiar())
+	}
+	return nil
+}
+
+// registryClient returns a client using the credentials of docker login, reaching every registry
+// over plain HTTP when insecure
+func registryClient(insecure bool) *registry.Client {
+	client := registry.NewClient()
+	if insecure {
+		client.PlainHTTP = func(string) bool { return true }
+	}
+	return client
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/NamelessOne91/coso/filesystem"
+	"github.com/NamelessOne91/coso/image"
+)
+
+// push handles the push command, uploading an image of the image store, or an exited container, to a registry
+func push(args []string) {
+	fs := flag.NewFlagSet("push", flag.ExitOnError)
+	chunkSize := fs.String("chunk-size", "", "Upload the blobs larger than the given size, e.g. 16m, in chunks of that size (default: with a single request)")
+	insecure := fs.Bool("insecure", false, "Reach the registry over plain HTTP, as always done for registries on the loopback interface")
+	quiet := fs.Bool("q", false, "Don't print the uploaded blobs")
+	fs.Parse(args)
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fmt.Println("Usage: coso push [--chunk-size SIZE] [--insecure] IMAGE|CONTAINER [[REGISTRY/]REPOSITORY[:TAG|@DIGEST]]")
+		os.Exit(1)
+	}
+
+	// images are pushed to the reference they are tagged with, unless another one is given
+	source, destination := fs.Arg(0), fs.Arg(0)
+	if fs.NArg() == 2 {
+		destination = fs.Arg(1)
+	}
+	if err := pushImage(source, destination, *chunkSize, *insecure, *quiet); err != nil {
+		fmt.Printf("Error - %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func pushImage(source, destination, chunkSize string, insecure, quiet bool) error {
+	store, err := imageStore()
+	if err != nil {
+		return err
+	}
+
+	var opts image.PushOptions
+	if chunkSize != "" {
+		if opts.ChunkSize, err = filesystem.ParseSize(chunkSize); err != nil {
+			return err
+		}
+	}
+	if !quiet {
+		opts.Progress = os.Stdout
+	}
+
+	if source, err = resolvePushSource(store, source, destination); err != nil {
+		return err
+	}
+	digest, err := store.Push(registryClient(insecure), source, destination, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Pushed %s, digest: %s\n", destination, digest)
+	return nil
+}
+
+// resolvePushSource returns the image to push named by source: an image of the store or, when none
+// matches, an exited container, which is committed into an image tagged with destination
+func resolvePushSource(store *image.Store, source, destination string) (string, error) {
+	_, err := store.Get(source)
+	if !errors.Is(err, image.ErrNotFound) {
+		return source, err
+	}
+	if _, containerErr := findContainer(source); errors.Is(containerErr, errNoSuchContainer) {
+		return "", fmt.Errorf("no such image or container: %s", source)
+	}
+
+	if source == destination {
+		return "", fmt.Errorf("containers must be pushed to a given reference, e.g. coso push %s registry.example.com/team/app:1.0", source)
+	}
+	ref, err := image.ParseReference(destination)
+	if err != nil {
+		return "", err
+	}
+	if ref.Digest != "" {
+		return "", fmt.Errorf("containers must be pushed to a tag, the digest of their image isn't known yet")
+	}
+	img, err := commitContainer(source, []string{destination})
+	if err != nil {
+		return "", err
+	}
+	fmt.Printf("Committed container %s as image %s\n", source, img.ShortID())
+	return img.ID, nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/NamelessOne91/coso/archive"
+	"github.com/NamelessOne91/coso/assets"
+	"github.com/NamelessOne91/coso/rootfs"
+	"github.com/NamelessOne91/coso/spec"
+)
+
+// rootfsCommand handles the rootfs import, ls and rm commands
+func rootfsCommand(args []string) {
+	if len(args) == 0 {
+		fmt.Println("Usage: coso rootfs import|ls|rm")
+		os.Exit(1)
+	}
+
+	store, err := rootfsStore()
+	if err != nil {
+		fmt.Printf("Error opening the root filesystem store - %s\n", err)
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "import":
+		err = rootfsImport(store, args[1:])
+	case "ls":
+		err = rootfsList(store)
+	case "rm":
+		err = rootfsRemove(store, args[1:])
+	default:
+		err = fmt.Errorf("unknown rootfs command '%s', expected one of import, ls or rm", args[0])
+	}
+	if err != nil {
+		fmt.Printf("Error - %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func rootfsStore() (*rootfs.Store, error) {
+	path, err := rootfs.UserStorePath()
+	if err != nil {
+		return nil, err
+	}
+	return rootfs.NewStore(path), nil
+}
+
+func rootfsImport(store *rootfs.Store, args []string) error {
+	fs := flag.NewFlagSet("rootfs import", flag.ExitOnError)
+	var name string
+	var uidMaps, gidMaps stringSlice
+	fs.StringVar(&name, "name", "", "Name of the root filesystem (default: the archive name without extensions)")
+	fs.Var(&uidMaps, "uidmap", "UID mapping, in the containerID:hostID:size format, the owners of the files are mapped with (repeatable)")
+	fs.Var(&gidMaps, "gidmap", "GID mapping, in the containerID:hostID:size format, the groups of the files are mapped with (repeatable)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: coso rootfs import [--name NAME] ARCHIVE")
+	}
+
+	archivePath := fs.Arg(0)
+	if name == "" {
+		name = rootfs.NameFromArchive(archivePath)
+	}
+
+	uidMappings, gidMappings, err := idMappings(uidMaps, gidMaps)
+	if err != nil {
+		return err
+	}
+	opts := extractOptions(uidMappings, gidMappings)
+
+	path, err := store.Import(name, archivePath, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s imported at %s\n", name, path)
+	return nil
+}
+
+// idMappings parses the given ID mappings, or returns the same default mappings used by containers when empty
+func idMappings(uidMaps, gidMaps []string) ([]spec.IDMapping, []spec.IDMapping, error) {
+	uidMappings, err := parseIDMappings(uidMaps)
+	if err != nil {
+		return nil, nil, err
+	}
+	gidMappings, err := parseIDMappings(gidMaps)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(uidMappings) == 0 {
+		uidMappings = defaultIDMappings(spec.SubUIDPath, os.Getuid())
+	}
+	if len(gidMappings) == 0 {
+		gidMappings = defaultIDMappings(spec.SubGIDPath, os.Getgid())
+	}
+	return uidMappings, gidMappings, nil
+}
+
+// extractID identifies the extract options of the given ID mappings, so that images unpacked with
+// different mappings don't share their root filesystem
+func extractID(uidMappings, gidMappings []spec.IDMapping) string {
+	if os.Geteuid() != 0 {
+		return ""
+	}
+	format := func(mappings []spec.IDMapping) string {
+		values := make([]string, len(mappings))
+		for i, m := range mappings {
+			values[i] = fmt.Sprintf("%d:%d:%d", m.ContainerID, m.HostID, m.Size)
+		}
+		return strings.Join(values, ",")
+	}
+	return fmt.Sprintf("uid=%s gid=%s", format(uidMappings), format(gidMappings))
+}
+
+// extractOptions maps the owners of the extracted files with the given ID mappings, so that
+// the files show up inside the container with the owners they have in the archive.
+//
+// Unprivileged users can only own files as themselves, so that rootless the files are left owned
+// by the invoking user, the container root.
+func extractOptions(uidMappings, gidMappings []spec.IDMapping) archive.Options {
+	if os.Geteuid() != 0 {
+		return archive.Options{Rootless: true}
+	}
+
+	// IDs outside of the mappings, e.g. when no subordinate range is available, fall back to
+	// the container root, since they would otherwise show up as nobody inside the container
+	warned := false
+	hostID := func(mappings []spec.IDMapping, id int) (int, error) {
+		hostID, err := spec.HostID(mappings, id)
+		if err == nil {
+			return hostID, nil
+		}
+		if !warned {
+			fmt.Printf("Warning - %s, files owned by unmapped IDs are owned by the container root\n", err)
+			warned = true
+		}
+		return spec.HostID(mappings, 0)
+	}
+
+	return archive.Options{
+		MapOwner: func(uid, gid int) (int, int, error) {
+			hostUID, err := hostID(uidMappings, uid)
+			if err != nil {
+				return 0, 0, err
+			}
+			hostGID, err := hostID(gidMappings, gid)
+			return hostUID, hostGID, err
+		},
+	}
+}
+
+// archiveOptions maps the owners of the archived files back with the given ID mappings, so that the
+// archive holds the owners the files have inside the container.
+//
+// Host IDs outside of the mappings, which show up as nobody inside the container, are archived as the
+// container root, as they are extracted.
+func archiveOptions(uidMappings, gidMappings []spec.IDMapping) archive.CreateOptions {
+	warned := false
+	containerID := func(mappings []spec.IDMapping, id int) int {
+		containerID, err := spec.ContainerID(mappings, id)
+		if err != nil && !warned {
+			fmt.Printf("Warning - %s, files owned by unmapped IDs are archived as owned by root\n", err)
+			warned = true
+		}
+		return containerID
+	}
+
+	return archive.CreateOptions{
+		MapOwner: func(uid, gid int) (int, int, error) {
+			return containerID(uidMappings, uid), containerID(gidMappings, gid), nil
+		},
+	}
+}
+
+func rootfsList(store *rootfs.Store) error {
+	names, err := store.List()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		fmt.Println(name)
+	}
+	return nil
+}
+
+func rootfsRemove(store *rootfs.Store, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: coso rootfs rm NAME...")
+	}
+	for _, name := range args {
+		if err := store.Remove(name); err != nil {
+			return err
+		}
+		fmt.Println(name)
+	}
+	return nil
+}
+
+// resolveRootfs turns the root filesystem of the container spec into a path: values without slashes
+// are names of imported root filesystems. Unless a root filesystem was imported with its name, the
+// embedded one, if any, is imported on first use with the ID mappings of the container, once for
+// each set of mappings, like image root filesystems.
+func resolveRootfs(s *spec.Spec) (string, error) {
+	if strings.Contains(s.Rootfs, "/") {
+		return filepath.Abs(s.Rootfs)
+	}
+
+	store, err := rootfsStore()
+	if err != nil {
+		return "", err
+	}
+	embedded := assets.EmbeddedRootfs
+	if embedded == nil || s.Rootfs != embedded.Name || store.Exists(embedded.Name) {
+		return store.Path(s.Rootfs)
+	}
+
+	name := embedded.StoreName(extractID(s.UIDMappings, s.GIDMappings))
+	if store.Exists(name) {
+		return store.Path(name)
+	}
+	r, err := embedded.Open()
+	if err != nil {
+		return "", err
+	}
+	fmt.Printf("Extracting the embedded root filesystem '%s'\n", embedded.Name)
+	path, err := store.ImportReader(name, r, extractOptions(s.UIDMappings, s.GIDMappings))
+	// another container may have imported it in the meantime
+	if err != nil && store.Exists(name) {
+		return store.Path(name)
+	}
+	return path, err
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/NamelessOne91/coso/filesystem"
+	"github.com/NamelessOne91/coso/volumes"
+)
+
+// volume handles the volume create, ls, rm and inspect commands
+func volume(args []string) {
+	if len(args) == 0 {
+		fmt.Println("Usage: coso volume create|ls|rm|inspect")
+		os.Exit(1)
+	}
+
+	store, err := volumeStore()
+	if err != nil {
+		fmt.Printf("Error opening the volume store - %s\n", err)
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "create":
+		err = volumeCreate(store, args[1:])
+	case "ls":
+		err = volumeList(store, args[1:])
+	case "rm":
+		err = volumeRemove(store, args[1:])
+	case "inspect":
+		err = volumeInspect(store, args[1:])
+	default:
+		err = fmt.Errorf("unknown volume command '%s', expected one of create, ls, rm or inspect", args[0])
+	}
+	if err != nil {
+		fmt.Printf("Error - %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// volumeStore opens the volume store of the calling user, where a volume is referenced
+// by a container as long as the container runs
+func volumeStore() (*volumes.Store, error) {
+	path, err := volumes.UserStorePath()
+	if err != nil {
+		return nil, err
+	}
+	return volumes.NewStore(path, func(id string) bool {
+		return filesystem.IsRunning(filesystem.ContainerPath(id))
+	}), nil
+}
+
+func volumeCreate(store *volumes.Store, args []string) error {
+	fs := flag.NewFlagSet("volume create", flag.ExitOnError)
+	var labels stringSlice
+	fs.Var(&labels, "label", "Label of the volume in the KEY=VALUE format (repeatable)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: coso volume create [--label KEY=VALUE] NAME")
+	}
+
+	var labelMap map[string]string
+	for _, label := range labels {
+		key, value, _ := strings.Cut(label, "=")
+		if labelMap == nil {
+			labelMap = map[string]string{}
+		}
+		labelMap[key] = value
+	}
+
+	v, err := store.Create(fs.Arg(0), labelMap)
+	if err != nil {
+		return err
+	}
+	fmt.Println(v.Name)
+	return nil
+}
+
+func volumeList(store *volumes.Store, args []string) error {
+	fs := flag.NewFlagSet("volume ls", flag.ExitOnError)
+	quiet := fs.Bool("q", false, "Only print the volume names")
+	fs.Parse(args)
+
+	vols, err := store.List()
+	if err != nil {
+		return err
+	}
+
+	if *quiet {
+		for _, v := range vols {
+			fmt.Println(v.Name)
+		}
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(w, "NAME\tCONTAINERS\tMOUNTPOINT")
+	for _, v := range vols {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", v.Name, len(v.Containers), v.Mountpoint)
+	}
+	return w.Flush()
+}
+
+func volumeRemove(store *volumes.Store, args []string) error {
+	fs := flag.NewFlagSet("volume rm", flag.ExitOnError)
+	force := fs.Bool("f", false, "Remove the volumes even if referenced by containers")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: coso volume rm [-f] NAME...")
+	}
+
+	for _, name := range fs.Args() {
+		if err := store.Remove(name, *force); err != nil {
+			return err
+		}
+		fmt.Println(name)
+	}
+	return nil
+}
+
+func volumeInspect(store *volumes.Store, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: coso volume inspect NAME...")
+	}
+
+	vols := make([]*volumes.Volume, 0, len(args))
+	for _, name := range args {
+		v, err := store.Get(name)
+		if err != nil {
+			return err
+		}
+		vols = append(vols, v)
+	}
+
+	out, err := json.MarshalIndent(vols, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
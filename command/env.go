@@ -0,0 +1,77 @@
+package command
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// ParseEnv parses a variable in the KEY[=VALUE] format.
+// When the value is omitted it is taken from the calling process' environment,
+// and false is returned if the variable is not set there either.
+func ParseEnv(variable string) (string, bool, error) {
+	key, _, hasValue := strings.Cut(variable, "=")
+	if key == "" {
+		return "", false, fmt.Errorf("invalid variable '%s': missing name", variable)
+	}
+	if hasValue {
+		return variable, true, nil
+	}
+
+	value, found := os.LookupEnv(key)
+	if !found {
+		return "", false, nil
+	}
+	return key + "=" + value, true, nil
+}
+
+// ReadEnvFile reads the environment variables listed in the file at the given path,
+// one KEY[=VALUE] variable per line. Blank lines and lines starting with # are ignored,
+// while values are kept verbatim, trailing whitespace included.
+func ReadEnvFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var env []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimLeftFunc(scanner.Text(), unicode.IsSpace)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		variable, found, err := ParseEnv(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if found {
+			env = append(env, variable)
+		}
+	}
+	return env, scanner.Err()
+}
+
+// MergeEnv returns the defaults variables overridden by the ones in env with the same key.
+// Variables keep the order they first appeared in.
+func MergeEnv(defaults, env []string) []string {
+	merged := make([]string, 0, len(defaults)+len(env))
+	indexes := make(map[string]int, len(defaults)+len(env))
+
+	for _, variables := range [][]string{defaults, env} {
+		for _, variable := range variables {
+			key, _, _ := strings.Cut(variable, "=")
+			if i, exists := indexes[key]; exists {
+				merged[i] = variable
+				continue
+			}
+			indexes[key] = len(merged)
+			merged = append(merged, variable)
+		}
+	}
+	return merged
+}
@@ -0,0 +1,85 @@
+package command
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Env", func() {
+
+	BeforeEach(func() {
+		Expect(os.Setenv("COSO_TEST_VAR", "from-host")).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(os.Unsetenv("COSO_TEST_VAR")).To(Succeed())
+	})
+
+	Describe("ParseEnv", func() {
+		It("keeps variables with a value", func() {
+			variable, found, err := ParseEnv("KEY=a=b")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(found).To(BeTrue())
+			Expect(variable).To(Equal("KEY=a=b"))
+		})
+
+		It("takes the value of variables without one from the host", func() {
+			variable, found, err := ParseEnv("COSO_TEST_VAR")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(found).To(BeTrue())
+			Expect(variable).To(Equal("COSO_TEST_VAR=from-host"))
+		})
+
+		It("skips variables without a value not set on the host", func() {
+			_, found, err := ParseEnv("COSO_UNSET_VAR")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(found).To(BeFalse())
+		})
+
+		It("rejects variables without a name", func() {
+			_, _, err := ParseEnv("=value")
+			Expect(err).To(MatchError("invalid variable '=value': missing name"))
+		})
+	})
+
+	Describe("ReadEnvFile", func() {
+		var dir, path string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = os.MkdirTemp("", "coso-command")
+			Expect(err).NotTo(HaveOccurred())
+			path = filepath.Join(dir, "env")
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(dir)).To(Succeed())
+		})
+
+		It("reads one variable per line, skipping comments and blank lines", func() {
+			content := "# comment\n\n  \t\nA=1\n  B=2  \n  # indented comment\nCOSO_TEST_VAR\nCOSO_UNSET_VAR\n"
+			Expect(os.WriteFile(path, []byte(content), 0644)).To(Succeed())
+
+			env, err := ReadEnvFile(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(env).To(Equal([]string{"A=1", "B=2  ", "COSO_TEST_VAR=from-host"}))
+		})
+
+		It("rejects variables without a name", func() {
+			Expect(os.WriteFile(path, []byte("=value\n"), 0644)).To(Succeed())
+
+			_, err := ReadEnvFile(path)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("MergeEnv", func() {
+		It("overrides defaults with the same key, keeping their position", func() {
+			merged := MergeEnv([]string{"PATH=/bin", "HOME=/root"}, []string{"HOME=/home/app", "A=1"})
+			Expect(merged).To(Equal([]string{"PATH=/bin", "HOME=/home/app", "A=1"}))
+		})
+	})
+})
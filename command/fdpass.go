@@ -0,0 +1,133 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"golang.org/x/sys/unix"
+)
+
+// fdSocketFd is the file descriptor the init process finds its end of the socket at,
+// right after the sync pipe
+const fdSocketFd = syncFd + 1
+
+// FdSocket is a unix socket pair allowing the init process and the parent to hand file descriptors over
+// to each other (e.g. the seccomp notification fd, or the trees of idmapped mounts)
+type FdSocket struct {
+	parent *os.File
+	child  *os.File
+}
+
+// NewFdSocket creates a new socket pair and passes one of its ends to the process which will be started by cmd.
+// It must be called right after NewSyncPipe.
+func NewFdSocket(cmd *exec.Cmd) (*FdSocket, error) {
+	if len(cmd.ExtraFiles) != fdSocketFd-3 {
+		return nil, fmt.Errorf("the fd socket must be created right after the sync pipe")
+	}
+
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	parent := os.NewFile(uintptr(fds[0]), "fd-socket-parent")
+	child := os.NewFile(uintptr(fds[1]), "fd-socket-child")
+	cmd.ExtraFiles = append(cmd.ExtraFiles, child)
+
+	return &FdSocket{parent: parent, child: child}, nil
+}
+
+// CloseChildEnd closes the init process' end of the socket in the parent, once the child process has been started
+func (s *FdSocket) CloseChildEnd() error {
+	return s.child.Close()
+}
+
+// Receive blocks until the init process sends a file descriptor
+func (s *FdSocket) Receive() (*os.File, error) {
+	defer s.parent.Close()
+
+	oob := make([]byte, unix.CmsgSpace(4))
+	_, oobn, _, _, err := unix.Recvmsg(int(s.parent.Fd()), make([]byte, 1), oob, unix.MSG_CMSG_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) != 1 {
+		return nil, fmt.Errorf("the init process closed the fd socket without sending a file descriptor")
+	}
+	fds, err := unix.ParseUnixRights(&messages[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(fds) != 1 {
+		return nil, fmt.Errorf("expected 1 file descriptor, received %d", len(fds))
+	}
+	return os.NewFile(uintptr(fds[0]), "received-fd"), nil
+}
+
+// Send hands the given files over to the init process, which receives them with ReceiveFds
+func (s *FdSocket) Send(files ...*os.File) error {
+	fds := make([]int, 0, len(files))
+	for _, f := range files {
+		fds = append(fds, int(f.Fd()))
+	}
+	// at least one byte of data must accompany the control message
+	return unix.Sendmsg(int(s.parent.Fd()), []byte{0}, unix.UnixRights(fds...), nil, 0)
+}
+
+// ReceiveFds is called by the init process to receive the n file descriptors sent by the parent with Send
+func ReceiveFds(n int) ([]*os.File, error) {
+	return receiveFds(fdSocketFd, n)
+}
+
+func receiveFds(socket, n int) ([]*os.File, error) {
+	oob := make([]byte, unix.CmsgSpace(4*n))
+	_, oobn, _, _, err := unix.Recvmsg(socket, make([]byte, 1), oob, unix.MSG_CMSG_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) != 1 {
+		return nil, fmt.Errorf("the parent closed the fd socket without sending file descriptors")
+	}
+	fds, err := unix.ParseUnixRights(&messages[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(fds) != n {
+		return nil, fmt.Errorf("expected %d file descriptors, received %d", n, len(fds))
+	}
+
+	files := make([]*os.File, 0, n)
+	for _, fd := range fds {
+		files = append(files, os.NewFile(uintptr(fd), "received-fd"))
+	}
+	return files, nil
+}
+
+// CloseFdSocket is called by the init process to close its end of the socket,
+// when it doesn't need to send file descriptors to the parent
+func CloseFdSocket() error {
+	return unix.Close(fdSocketFd)
+}
+
+// SendFd is called by the init process to hand the given file descriptor over to the parent.
+// The socket is closed afterwards, so that it doesn't leak into the workload.
+func SendFd(fd int) error {
+	return sendFd(fdSocketFd, fd)
+}
+
+func sendFd(socket, fd int) error {
+	defer unix.Close(socket)
+
+	// at least one byte of data must accompany the control message
+	return unix.Sendmsg(socket, []byte{0}, unix.UnixRights(fd), nil, 0)
+}
@@ -0,0 +1,92 @@
+package command
+
+import (
+	"os"
+	"os/exec"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("FdSocket", func() {
+
+	var (
+		cmd    *exec.Cmd
+		socket *FdSocket
+		dir    string
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "coso-command")
+		Expect(err).NotTo(HaveOccurred())
+
+		cmd = &exec.Cmd{}
+		_, err = NewSyncPipe(cmd)
+		Expect(err).NotTo(HaveOccurred())
+		socket, err = NewFdSocket(cmd)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		socket.parent.Close()
+		socket.child.Close()
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	// sameFile reports whether both files refer to the same inode
+	sameFile := func(a, b *os.File) bool {
+		aInfo, err := a.Stat()
+		Expect(err).NotTo(HaveOccurred())
+		bInfo, err := b.Stat()
+		Expect(err).NotTo(HaveOccurred())
+		return os.SameFile(aInfo, bInfo)
+	}
+
+	It("must be created right after the sync pipe", func() {
+		_, err := NewFdSocket(&exec.Cmd{})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("hands files over to the init process", func() {
+		first, err := os.Open(dir)
+		Expect(err).NotTo(HaveOccurred())
+		defer first.Close()
+		second, err := os.CreateTemp(dir, "tree")
+		Expect(err).NotTo(HaveOccurred())
+		defer second.Close()
+
+		Expect(socket.Send(first, second)).To(Succeed())
+		files, err := receiveFds(int(socket.child.Fd()), 2)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files).To(HaveLen(2))
+		defer files[0].Close()
+		defer files[1].Close()
+		Expect(sameFile(files[0], first)).To(BeTrue())
+		Expect(sameFile(files[1], second)).To(BeTrue())
+	})
+
+	It("hands a file over to the parent", func() {
+		f, err := os.CreateTemp(dir, "notify")
+		Expect(err).NotTo(HaveOccurred())
+		defer f.Close()
+
+		// the init process' end of the socket is closed once the file is sent
+		child, err := socket.child.SyscallConn()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(child.Control(func(fd uintptr) {
+			Expect(sendFd(int(fd), int(f.Fd()))).To(Succeed())
+		})).To(Succeed())
+
+		received, err := socket.Receive()
+		Expect(err).NotTo(HaveOccurred())
+		defer received.Close()
+		Expect(sameFile(received, f)).To(BeTrue())
+	})
+
+	It("reports a closed socket", func() {
+		Expect(socket.child.Close()).To(Succeed())
+		_, err := socket.Receive()
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,110 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// newuidmap and newgidmap are the setuid helpers (shipped with shadow-utils) allowing unprivileged
+	// users to map the subordinate IDs listed in /etc/subuid and /etc/subgid
+	newuidmap = "newuidmap"
+	newgidmap = "newgidmap"
+	// awaitIDMappingsInit is the initializer the processes whose ID mappings are written by the helpers
+	// are started as, before executing the one they were meant to run
+	awaitIDMappingsInit = "awaitIDMappings"
+)
+
+func init() {
+	Register(awaitIDMappingsInit, awaitIDMappings)
+}
+
+// CanMapIDRanges reports whether the calling user is able to map more than its own IDs,
+// either because it is privileged or because the newuidmap/newgidmap helpers are available
+func CanMapIDRanges() bool {
+	if os.Geteuid() == 0 {
+		return true
+	}
+	_, uidErr := exec.LookPath(newuidmap)
+	_, gidErr := exec.LookPath(newgidmap)
+	return uidErr == nil && gidErr == nil
+}
+
+// SetIDMappings configures the user namespace ID mappings of the process which will be started by cmd.
+//
+// When the calling user is privileged, or the mappings only map its own IDs, the mappings are written
+// directly by the Go runtime. Otherwise they must be written with the newuidmap/newgidmap helpers by calling
+// WriteIDMappings once the process has started, and before releasing it: in this case true is returned.
+func SetIDMappings(cmd *exec.Cmd, uidMappings, gidMappings []syscall.SysProcIDMap) bool {
+	return setIDMappings(cmd, uidMappings, gidMappings, os.Geteuid() == 0)
+}
+
+func setIDMappings(cmd *exec.Cmd, uidMappings, gidMappings []syscall.SysProcIDMap, privileged bool) bool {
+	if !privileged && (!isOwnIDMapping(uidMappings, os.Getuid()) || !isOwnIDMapping(gidMappings, os.Getgid())) {
+		cmd.SysProcAttr.UidMappings = nil
+		cmd.SysProcAttr.GidMappings = nil
+		// the binary is executed before the mappings are written, so it must be executed again afterwards
+		cmd.Args = append([]string{awaitIDMappingsInit}, cmd.Args...)
+		return true
+	}
+
+	cmd.SysProcAttr.UidMappings = uidMappings
+	cmd.SysProcAttr.GidMappings = gidMappings
+	// setgroups can only be allowed in the new user namespace if the gid_map
+	// has been written by a privileged process
+	cmd.SysProcAttr.GidMappingsEnableSetgroups = privileged
+	return false
+}
+
+// WriteIDMappings runs the newuidmap/newgidmap helpers to write the ID mappings
+// of the user namespace the process with the given pid belongs to
+func WriteIDMappings(pid int, uidMappings, gidMappings []syscall.SysProcIDMap) error {
+	if err := runIDMapHelper(newuidmap, pid, uidMappings); err != nil {
+		return err
+	}
+	return runIDMapHelper(newgidmap, pid, gidMappings)
+}
+
+// awaitIDMappings waits for the parent, which writes the ID mappings before releasing the process, then
+// executes the binary again as the initializer the process was meant to run, handing it the payload the
+// parent released it with. Capabilities in a user namespace are granted by execve to its root user only,
+// so that the process was left without any when the binary was first executed, since no ID was mapped yet.
+func awaitIDMappings() {
+	payload, err := WaitForParent()
+	if err != nil {
+		fmt.Printf("Error waiting for the parent process - %s\n", err)
+		os.Exit(1)
+	}
+	if err := restoreSyncPipe(payload); err != nil {
+		fmt.Printf("Error handing the payload over - %s\n", err)
+		os.Exit(1)
+	}
+	if err := unix.Exec(self, os.Args[1:], os.Environ()); err != nil {
+		fmt.Printf("Error executing %s - %s\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+// runIDMapHelper executes the given helper binary, passing it the mappings as
+// a sequence of containerID hostID size triplets
+func runIDMapHelper(helper string, pid int, mappings []syscall.SysProcIDMap) error {
+	args := []string{strconv.Itoa(pid)}
+	for _, m := range mappings {
+		args = append(args, strconv.Itoa(m.ContainerID), strconv.Itoa(m.HostID), strconv.Itoa(m.Size))
+	}
+
+	if out, err := exec.Command(helper, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s failed: %s - %s", helper, err, out)
+	}
+	return nil
+}
+
+// isOwnIDMapping reports whether mappings consists of a single mapping of the given host ID
+func isOwnIDMapping(mappings []syscall.SysProcIDMap, id int) bool {
+	return len(mappings) == 1 && mappings[0].HostID == id && mappings[0].Size == 1
+}
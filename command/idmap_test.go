@@ -0,0 +1,99 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+// idmapTestInit reports the payload, the ID mappings and the credentials the process runs with
+const idmapTestInit = "idmapTest"
+
+func init() {
+	Register(idmapTestInit, func() {
+		payload, err := WaitForParent()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("payload: %s\n", payload)
+		for _, path := range []string{"/proc/self/uid_map", "/proc/self/status"} {
+			content, err := os.ReadFile(path)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			fmt.Print(string(content))
+		}
+	})
+	if Init() {
+		os.Exit(0)
+	}
+}
+
+// writeIDMap writes the mappings to the given map file of the process, the way newuidmap and newgidmap do
+func writeIDMap(pid int, file string, mappings []syscall.SysProcIDMap) {
+	var content strings.Builder
+	for _, m := range mappings {
+		fmt.Fprintf(&content, "%d %d %d\n", m.ContainerID, m.HostID, m.Size)
+	}
+	Expect(os.WriteFile("/proc/"+strconv.Itoa(pid)+"/"+file, []byte(content.String()), 0)).To(Succeed())
+}
+
+var _ = Describe("ID mappings", func() {
+
+	// a subordinate ID range besides the root of the container, mapped to the invoking user
+	mappings := []syscall.SysProcIDMap{
+		{ContainerID: 0, HostID: 0, Size: 1},
+		{ContainerID: 1, HostID: 100000, Size: 65536},
+	}
+
+	// run starts the test initializer in new namespaces, writing the ID mappings from the outside
+	// once it started when the helpers would be used, and returns its output
+	run := func(privileged bool) string {
+		if os.Geteuid() != 0 {
+			Skip("mapping a subordinate ID range requires root")
+		}
+
+		cmd := NewReexecCommand(idmapTestInit)
+		var out bytes.Buffer
+		cmd.Stdout = &out
+		cmd.Stderr = &out
+		useHelpers := setIDMappings(cmd, mappings, mappings, privileged)
+		Expect(useHelpers).To(Equal(!privileged))
+		pipe, err := NewSyncPipe(cmd)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(cmd.Start()).To(Succeed())
+		Expect(pipe.CloseChildEnd()).To(Succeed())
+		if useHelpers {
+			writeIDMap(cmd.Process.Pid, "uid_map", mappings)
+			Expect(os.WriteFile(fmt.Sprintf("/proc/%d/setgroups", cmd.Process.Pid), []byte("deny"), 0)).To(Succeed())
+			writeIDMap(cmd.Process.Pid, "gid_map", mappings)
+		}
+		Expect(pipe.Release([]byte("spec"))).To(Succeed())
+		Expect(cmd.Wait()).To(Succeed(), out.String())
+		return out.String()
+	}
+
+	DescribeTable("runs as root with the capabilities of the namespace under a subordinate range",
+		func(privileged bool) {
+			out := run(privileged)
+			Expect(out).To(ContainSubstring("payload: spec\n"))
+			Expect(out).To(MatchRegexp(`(?m)^\s+1\s+100000\s+65536$`))
+			Expect(out).To(MatchRegexp(`(?m)^Uid:\s+0\s+0\s+0\s+0$`))
+			// execve only grants the capabilities of the user namespace to a mapped root user
+			Expect(out).To(MatchRegexp(`(?m)^CapEff:\s+[0-9a-f]+$`))
+			Expect(out).NotTo(MatchRegexp(`(?m)^CapEff:\s+0+$`))
+		},
+		Entry("mappings written by the runtime", true),
+		Entry("mappings written by the helpers", false),
+	)
+})
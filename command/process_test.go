@@ -0,0 +1,67 @@
+package command
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Process", func() {
+
+	Describe("LookPath", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = os.MkdirTemp("", "coso-command")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(os.MkdirAll(filepath.Join(dir, "bin"), 0755)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(dir, "sbin"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, "bin", "app"), nil, 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, "sbin", "app"), nil, 0755)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(dir)).To(Succeed())
+		})
+
+		It("returns the first executable file in PATH", func() {
+			env := []string{"PATH=/nonexistent", "PATH=" + filepath.Join(dir, "bin") + ":" + filepath.Join(dir, "sbin")}
+			path, err := LookPath("app", env)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(path).To(Equal(filepath.Join(dir, "sbin", "app")))
+		})
+
+		It("returns paths as they are", func() {
+			path, err := LookPath("./app", nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(path).To(Equal("./app"))
+		})
+
+		It("fails when the file is not found", func() {
+			_, err := LookPath("app", []string{"PATH=" + filepath.Join(dir, "bin")})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	DescribeTable("ParseSignal",
+		func(s string, expected syscall.Signal) {
+			signal, err := ParseSignal(s)
+			if expected == 0 {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(signal).To(Equal(expected))
+		},
+		Entry("name", "SIGQUIT", syscall.SIGQUIT),
+		Entry("name without prefix", "term", syscall.SIGTERM),
+		Entry("number", "9", syscall.SIGKILL),
+		Entry("unknown name", "SIGFOO", syscall.Signal(0)),
+		Entry("out of range number", "100", syscall.Signal(0)),
+	)
+})
@@ -0,0 +1,110 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+
+	"golang.org/x/sys/unix"
+)
+
+// syncFd is the file descriptor the init process finds the read end of the sync pipe at.
+// Files in exec.Cmd.ExtraFiles are numbered starting from 3, right after stdin/stdout/stderr.
+const syncFd = 3
+
+// the first byte written to the sync pipe tells whether the parent released or aborted the init process
+const (
+	released byte = iota
+	aborted
+)
+
+// SyncPipe allows the parent to hold the init process until the host side configuration of
+// the container (ID mappings, cgroups, ...) has been completed, and to hand it the container spec,
+// which could exceed the size limit of the command line arguments
+type SyncPipe struct {
+	parent *os.File
+	child  *os.File
+}
+
+// NewSyncPipe creates a new pipe and passes its read end to the process which will be started by cmd.
+// It must be called before any other file is added to cmd.ExtraFiles.
+func NewSyncPipe(cmd *exec.Cmd) (*SyncPipe, error) {
+	child, parent, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.ExtraFiles = append(cmd.ExtraFiles, child)
+
+	return &SyncPipe{parent: parent, child: child}, nil
+}
+
+// CloseChildEnd closes the read end of the pipe in the parent, once the child process has been started
+func (s *SyncPipe) CloseChildEnd() error {
+	return s.child.Close()
+}
+
+// Release lets the init process proceed, handing it the payload
+func (s *SyncPipe) Release(payload []byte) error {
+	defer s.parent.Close()
+	_, err := s.parent.Write(append([]byte{released}, payload...))
+	return err
+}
+
+// Abort reports the given error to the init process, which will exit instead of proceeding
+func (s *SyncPipe) Abort(reason error) error {
+	defer s.parent.Close()
+	_, err := s.parent.Write(append([]byte{aborted}, reason.Error()...))
+	return err
+}
+
+// WaitForParent blocks the init process until the parent releases or aborts the sync pipe,
+// returning the payload the parent released it with
+func WaitForParent() ([]byte, error) {
+	return waitForParent(os.NewFile(syncFd, "sync"))
+}
+
+func waitForParent(pipe *os.File) ([]byte, error) {
+	defer pipe.Close()
+
+	msg, err := io.ReadAll(pipe)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(msg) == 0:
+		return nil, fmt.Errorf("the parent exited without releasing the container setup")
+	case msg[0] == aborted:
+		return nil, fmt.Errorf("parent aborted the container setup: %s", msg[1:])
+	default:
+		return msg[1:], nil
+	}
+}
+
+// restoreSyncPipe replaces the sync pipe, already read by WaitForParent, with a memfd holding the same
+// release message, so that the program the init process executes next can call WaitForParent again
+func restoreSyncPipe(payload []byte) error {
+	fd, err := unix.MemfdCreate("sync", 0)
+	if err != nil {
+		return err
+	}
+	for msg := append([]byte{released}, payload...); len(msg) > 0; {
+		n, err := unix.Write(fd, msg)
+		if err != nil {
+			unix.Close(fd)
+			return err
+		}
+		msg = msg[n:]
+	}
+	if _, err := unix.Seek(fd, 0, io.SeekStart); err != nil {
+		unix.Close(fd)
+		return err
+	}
+
+	// the pipe was closed once read, so that the memfd may already be at its place
+	if fd == syncFd {
+		return nil
+	}
+	defer unix.Close(fd)
+	return unix.Dup3(fd, syncFd, 0)
+}
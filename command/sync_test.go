@@ -0,0 +1,45 @@
+package command
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SyncPipe", func() {
+
+	var pipe *SyncPipe
+
+	BeforeEach(func() {
+		var err error
+		pipe, err = NewSyncPipe(&exec.Cmd{})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("hands the payload over on release", func() {
+		// larger than both the pipe buffer and the size limit of a command line argument
+		payload := bytes.Repeat([]byte("spec"), 64<<10)
+		go pipe.Release(payload)
+
+		received, err := waitForParent(pipe.child)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(received).To(Equal(payload))
+	})
+
+	It("reports aborts", func() {
+		go pipe.Abort(errors.New("no cgroup"))
+
+		_, err := waitForParent(pipe.child)
+		Expect(err).To(MatchError("parent aborted the container setup: no cgroup"))
+	})
+
+	It("fails when the parent exits without releasing", func() {
+		Expect(pipe.parent.Close()).To(Succeed())
+
+		_, err := waitForParent(pipe.child)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,223 @@
+package filesystem
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Device describes a device node created inside the container's /dev
+type Device struct {
+	// Path is the path of the node inside the container
+	Path string `json:"path"`
+	// Type is "c" for character devices and "b" for block devices
+	Type  string `json:"type"`
+	Major uint32 `json:"major"`
+	Minor uint32 `json:"minor"`
+	// FileMode holds the permission bits of the node
+	FileMode os.FileMode `json:"fileMode"`
+	// HostPath is the host node bind mounted when the node can't be created, Path when empty
+	HostPath string `json:"hostPath,omitempty"`
+	// Permissions is the access granted by the device controller, a combination of 'r', 'w' and 'm'.
+	// When empty, full access is granted.
+	Permissions string `json:"permissions,omitempty"`
+}
+
+// ParseDevice parses a host-path[:container-path][:permissions] device specification,
+// reading the type and numbers of the device from the host node
+func ParseDevice(s string) (Device, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 || parts[0] == "" {
+		return Device{}, fmt.Errorf("invalid device %q, expected host-path[:container-path][:permissions]", s)
+	}
+
+	hostPath := parts[0]
+	containerPath := hostPath
+	permissions := "rwm"
+	switch len(parts) {
+	case 2:
+		// a single option is either a path or the permissions
+		if strings.HasPrefix(parts[1], "/") {
+			containerPath = parts[1]
+		} else {
+			permissions = parts[1]
+		}
+	case 3:
+		containerPath, permissions = parts[1], parts[2]
+	}
+
+	if !filepath.IsAbs(hostPath) || !filepath.IsAbs(containerPath) {
+		return Device{}, fmt.Errorf("invalid device %q, paths must be absolute", s)
+	}
+	if permissions == "" || strings.Trim(permissions, "rwm") != "" {
+		return Device{}, fmt.Errorf("invalid device permissions %q, expected a combination of r, w and m", permissions)
+	}
+
+	var stat unix.Stat_t
+	if err := unix.Stat(hostPath, &stat); err != nil {
+		return Device{}, &os.PathError{Op: "stat", Path: hostPath, Err: err}
+	}
+
+	device := Device{
+		Path:        filepath.Clean(containerPath),
+		Major:       unix.Major(stat.Rdev),
+		Minor:       unix.Minor(stat.Rdev),
+		FileMode:    os.FileMode(stat.Mode).Perm(),
+		HostPath:    hostPath,
+		Permissions: permissions,
+	}
+	switch stat.Mode & unix.S_IFMT {
+	case unix.S_IFCHR:
+		device.Type = "c"
+	case unix.S_IFBLK:
+		device.Type = "b"
+	default:
+		return Device{}, fmt.Errorf("%s is not a device", hostPath)
+	}
+	return device, nil
+}
+
+// DefaultDevices are the device nodes available in every container
+var DefaultDevices = []Device{
+	{Path: "/dev/null", Type: "c", Major: 1, Minor: 3, FileMode: 0666},
+	{Path: "/dev/zero", Type: "c", Major: 1, Minor: 5, FileMode: 0666},
+	{Path: "/dev/full", Type: "c", Major: 1, Minor: 7, FileMode: 0666},
+	{Path: "/dev/random", Type: "c", Major: 1, Minor: 8, FileMode: 0666},
+	{Path: "/dev/urandom", Type: "c", Major: 1, Minor: 9, FileMode: 0666},
+	{Path: "/dev/tty", Type: "c", Major: 5, Minor: 0, FileMode: 0666},
+}
+
+// ContainerDevices returns a new slice with the default devices and the given ones, which replace
+// the devices with the same path listed before them
+func ContainerDevices(devices []Device) []Device {
+	all := make([]Device, 0, len(DefaultDevices)+len(devices))
+	indexes := make(map[string]int, len(DefaultDevices)+len(devices))
+	for _, list := range [][]Device{DefaultDevices, devices} {
+		for _, device := range list {
+			path := filepath.Clean(device.Path)
+			if i, exists := indexes[path]; exists {
+				all[i] = device
+				continue
+			}
+			indexes[path] = len(all)
+			all = append(all, device)
+		}
+	}
+	return all
+}
+
+// devSymlinks maps the links created in /dev to their targets
+var devSymlinks = [][2]string{
+	{"/proc/self/fd", "/dev/fd"},
+	{"/proc/self/fd/0", "/dev/stdin"},
+	{"/proc/self/fd/1", "/dev/stdout"},
+	{"/proc/self/fd/2", "/dev/stderr"},
+	{"pts/ptmx", "/dev/ptmx"},
+}
+
+// SetupDev mounts a tmpfs at /dev under newroot and populates it with a minimal set of device nodes,
+// a private devpts instance, /dev/shm, /dev/mqueue and the usual symlinks.
+//
+// Like MountProc, it must be called inside the container's mount and IPC namespaces, before pivot_root:
+// device nodes which can't be created are bind mounted from the host's /dev.
+func SetupDev(newroot string, devices []Device) error {
+	dev := filepath.Join(newroot, "/dev")
+	if err := os.MkdirAll(dev, 0755); err != nil {
+		return err
+	}
+	if err := syscall.Mount("tmpfs", dev, "tmpfs", syscall.MS_NOSUID|syscall.MS_STRICTATIME, "mode=755,size=65536k"); err != nil {
+		return err
+	}
+
+	for _, device := range devices {
+		if err := createDevice(newroot, device); err != nil {
+			return &os.PathError{Op: "create device", Path: device.Path, Err: err}
+		}
+	}
+
+	if err := mountDevpts(filepath.Join(dev, "/pts")); err != nil {
+		return err
+	}
+
+	shm := filepath.Join(dev, "/shm")
+	if err := os.MkdirAll(shm, 01777); err != nil {
+		return err
+	}
+	if err := syscall.Mount("shm", shm, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "mode=1777,size=65536k"); err != nil {
+		return err
+	}
+
+	mqueue := filepath.Join(dev, "/mqueue")
+	if err := os.MkdirAll(mqueue, 0755); err != nil {
+		return err
+	}
+	if err := syscall.Mount("mqueue", mqueue, "mqueue", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, ""); err != nil {
+		return err
+	}
+
+	for _, link := range devSymlinks {
+		if err := os.Symlink(link[0], filepath.Join(newroot, link[1])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createDevice creates the device node with mknod or, since device nodes can't be created inside
+// a user namespace, by bind mounting the host's node over an empty file
+func createDevice(newroot string, device Device) error {
+	target := filepath.Join(newroot, device.Path)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	mode := uint32(device.FileMode.Perm())
+	switch device.Type {
+	case "c":
+		mode |= unix.S_IFCHR
+	case "b":
+		mode |= unix.S_IFBLK
+	default:
+		return syscall.EINVAL
+	}
+
+	err := unix.Mknod(target, mode, int(unix.Mkdev(device.Major, device.Minor)))
+	if err == nil {
+		// mknod is subject to the umask
+		return os.Chmod(target, device.FileMode.Perm())
+	}
+	if err != unix.EPERM {
+		return err
+	}
+
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL, 0000)
+	if err != nil {
+		return err
+	}
+	f.Close()
+
+	source := device.HostPath
+	if source == "" {
+		source = device.Path
+	}
+	return syscall.Mount(source, target, "", syscall.MS_BIND, "")
+}
+
+// mountDevpts mounts a new devpts instance, so that the container's terminals are isolated from the host's ones
+func mountDevpts(target string) error {
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return err
+	}
+
+	flags := uintptr(syscall.MS_NOSUID | syscall.MS_NOEXEC)
+	// the tty group may not be mapped in the user namespace
+	err := syscall.Mount("devpts", target, "devpts", flags, "newinstance,ptmxmode=0666,mode=0620,gid=5")
+	if err == syscall.EINVAL {
+		err = syscall.Mount("devpts", target, "devpts", flags, "newinstance,ptmxmode=0666,mode=0620")
+	}
+	return err
+}
@@ -0,0 +1,83 @@
+package filesystem
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"golang.org/x/sys/unix"
+)
+
+var _ = Describe("Dev", func() {
+
+	DescribeTable("ParseDevice parses device specifications",
+		func(spec, path, permissions string) {
+			device, err := ParseDevice(spec)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(device.Path).To(Equal(path))
+			Expect(device.HostPath).To(Equal("/dev/null"))
+			Expect(device.Permissions).To(Equal(permissions))
+			Expect(device.Type).To(Equal("c"))
+			Expect(device.Major).To(Equal(uint32(1)))
+			Expect(device.Minor).To(Equal(uint32(3)))
+		},
+		Entry("a host path", "/dev/null", "/dev/null", "rwm"),
+		Entry("a container path", "/dev/null:/dev/void", "/dev/void", "rwm"),
+		Entry("permissions", "/dev/null:r", "/dev/null", "r"),
+		Entry("a container path and permissions", "/dev/null:/dev/void/:rw", "/dev/void", "rw"),
+	)
+
+	DescribeTable("ParseDevice rejects invalid device specifications",
+		func(spec string) {
+			_, err := ParseDevice(spec)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("an empty host path", ":/dev/void"),
+		Entry("a relative path", "dev/null"),
+		Entry("a relative container path", "/dev/null:dev/void:rw"),
+		Entry("invalid permissions", "/dev/null:rwx"),
+		Entry("too many fields", "/dev/null:/dev/void:rw:m"),
+		Entry("a missing node", "/dev/missing"),
+		Entry("a regular file", "/etc/hostname"),
+	)
+
+	It("lets devices replace the default ones with the same path", func() {
+		null := Device{Path: "/dev/null/", Type: "c", Major: 1, Minor: 3, FileMode: 0600, Permissions: "r"}
+		sda := Device{Path: "/dev/sda", Type: "b", Major: 8, Minor: 0, FileMode: 0660}
+
+		devices := ContainerDevices([]Device{null, sda})
+		Expect(devices).To(HaveLen(len(DefaultDevices) + 1))
+		Expect(devices[0]).To(Equal(null))
+		Expect(devices[len(devices)-1]).To(Equal(sda))
+		Expect(DefaultDevices[0].Permissions).To(BeEmpty())
+	})
+
+	It("populates /dev", func() {
+		dir, err := os.MkdirTemp("", "coso-filesystem")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+
+		inMountNamespace(func() {
+			Expect(SetupDev(dir, DefaultDevices)).To(Succeed())
+
+			for _, device := range DefaultDevices {
+				var stat unix.Stat_t
+				Expect(unix.Stat(filepath.Join(dir, device.Path), &stat)).To(Succeed())
+				Expect(stat.Mode & unix.S_IFMT).To(Equal(uint32(unix.S_IFCHR)))
+				Expect(unix.Major(stat.Rdev)).To(Equal(device.Major))
+				Expect(unix.Minor(stat.Rdev)).To(Equal(device.Minor))
+			}
+			for _, link := range devSymlinks {
+				target, err := os.Readlink(filepath.Join(dir, link[1]))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(target).To(Equal(link[0]))
+			}
+			Expect(filepath.Join(dir, "dev", "pts", "ptmx")).To(BeAnExistingFile())
+			Expect(filepath.Join(dir, "dev", "shm")).To(BeADirectory())
+			Expect(filepath.Join(dir, "dev", "mqueue")).To(BeADirectory())
+		})
+	})
+})
@@ -0,0 +1,58 @@
+package filesystem
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/NamelessOne91/coso/internal/stringslice"
+)
+
+// idmapOption makes a bind mount idmapped with the container's user namespace
+const idmapOption = "idmap"
+
+// IDMapped reports whether the mount must be idmapped with the container's user namespace
+func IDMapped(m Mount) bool {
+	return m.Type == "bind" && stringslice.Contains(m.Options, idmapOption)
+}
+
+// OpenIDMappedTree clones the tree at the source of the bind mount into a detached mount, idmapped with the
+// user namespace found at usernsPath: files owned by a host ID show up inside that namespace as owned by
+// the container ID with the same value, e.g. host UID 1000 as container UID 1000.
+//
+// Idmapping a mount requires CAP_SYS_ADMIN in the user namespace owning the source filesystem,
+// so it is done on the host, and the returned tree attached inside the container by MountAll.
+func OpenIDMappedTree(m Mount, usernsPath string) (*os.File, error) {
+	userns, err := os.Open(usernsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer userns.Close()
+
+	recursive := stringslice.Contains(m.Options, "rbind")
+	flags := unix.OPEN_TREE_CLONE | unix.OPEN_TREE_CLOEXEC
+	if recursive {
+		flags |= unix.AT_RECURSIVE
+	}
+	fd, err := unix.OpenTree(unix.AT_FDCWD, m.Source, uint(flags))
+	if err != nil {
+		return nil, &os.PathError{Op: "open_tree", Path: m.Source, Err: err}
+	}
+	tree := os.NewFile(uintptr(fd), m.Source)
+
+	setattrFlags := unix.AT_EMPTY_PATH
+	if recursive {
+		setattrFlags |= unix.AT_RECURSIVE
+	}
+	attr := unix.MountAttr{Attr_set: unix.MOUNT_ATTR_IDMAP, Userns_fd: uint64(userns.Fd())}
+	if err := unix.MountSetattr(fd, "", uint(setattrFlags), &attr); err != nil {
+		tree.Close()
+		return nil, &os.PathError{Op: "mount_setattr", Path: m.Source, Err: err}
+	}
+	return tree, nil
+}
+
+// attachTree mounts the detached tree at target
+func attachTree(tree *os.File, target string) error {
+	return unix.MoveMount(int(tree.Fd()), "", unix.AT_FDCWD, target, unix.MOVE_MOUNT_F_EMPTY_PATH)
+}
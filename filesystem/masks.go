@@ -0,0 +1,138 @@
+package filesystem
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// DefaultMaskedPaths are hidden from the container, as they expose host information or kernel interfaces
+var DefaultMaskedPaths = []string{
+	"/proc/acpi",
+	"/proc/asound",
+	"/proc/interrupts",
+	"/proc/kcore",
+	"/proc/keys",
+	"/proc/latency_stats",
+	"/proc/sched_debug",
+	"/proc/scsi",
+	"/proc/timer_list",
+	"/proc/timer_stats",
+	"/sys/devices/virtual/powercap",
+	"/sys/firmware",
+}
+
+// DefaultReadonlyPaths can be read but not written from inside the container
+var DefaultReadonlyPaths = []string{
+	"/proc/bus",
+	"/proc/fs",
+	"/proc/irq",
+	"/proc/sys",
+	"/proc/sysrq-trigger",
+}
+
+// MountSysfs mounts a sysfs filesystem at /sys under newroot, optionally read only.
+//
+// A new sysfs can only be mounted by the owner of the current network namespace:
+// when that's not allowed, the host's /sys is bind mounted instead.
+func MountSysfs(newroot string, readonly bool) error {
+	target := filepath.Join(newroot, "/sys")
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return err
+	}
+
+	flags := uintptr(syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC)
+	if readonly {
+		flags |= syscall.MS_RDONLY
+	}
+
+	err := syscall.Mount("sysfs", target, "sysfs", flags, "")
+	if err != syscall.EPERM {
+		return err
+	}
+
+	if err := syscall.Mount("/sys", target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return err
+	}
+	if readonly {
+		// the host's /sys has mounts below it, e.g. /sys/fs/cgroup, which must be read only too
+		return makeReadonly(target, true)
+	}
+	return nil
+}
+
+// MaskPaths makes the given paths, relative to newroot, inaccessible: directories are covered
+// by an empty read only tmpfs, files by /dev/null. Paths which don't exist are skipped.
+func MaskPaths(newroot string, paths []string) error {
+	for _, path := range paths {
+		target := filepath.Join(newroot, path)
+
+		info, err := os.Stat(target)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			err = syscall.Mount("tmpfs", target, "tmpfs", syscall.MS_RDONLY, "size=0")
+		} else {
+			err = syscall.Mount("/dev/null", target, "", syscall.MS_BIND, "")
+		}
+		if err != nil {
+			return &os.PathError{Op: "mask", Path: path, Err: err}
+		}
+	}
+	return nil
+}
+
+// MakeReadonly bind mounts the given paths, relative to newroot, over themselves and
+// remounts them read only, together with the mounts below them. Paths which don't exist are skipped.
+func MakeReadonly(newroot string, paths []string) error {
+	for _, path := range paths {
+		target := filepath.Join(newroot, path)
+
+		if _, err := os.Stat(target); os.IsNotExist(err) {
+			continue
+		}
+
+		if err := syscall.Mount(target, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return &os.PathError{Op: "bind", Path: path, Err: err}
+		}
+		if err := makeReadonly(target, true); err != nil {
+			return &os.PathError{Op: "remount", Path: path, Err: err}
+		}
+	}
+	return nil
+}
+
+// remountReadonly remounts the bind mount at target read only.
+//
+// Inside a user namespace the nosuid, nodev and noexec flags of a mount created in a more privileged
+// namespace are locked, and the remount fails unless they are preserved: they are read back with statfs.
+func remountReadonly(target string) error {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(target, &stat); err != nil {
+		return err
+	}
+
+	flags := uintptr(syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY)
+	for statFlag, mountFlag := range map[int64]uintptr{
+		unix.ST_NOSUID:      syscall.MS_NOSUID,
+		unix.ST_NODEV:       syscall.MS_NODEV,
+		unix.ST_NOEXEC:      syscall.MS_NOEXEC,
+		unix.ST_NOATIME:     syscall.MS_NOATIME,
+		unix.ST_NODIRATIME:  syscall.MS_NODIRATIME,
+		unix.ST_RELATIME:    syscall.MS_RELATIME,
+		unix.ST_SYNCHRONOUS: syscall.MS_SYNCHRONOUS,
+	} {
+		if int64(stat.Flags)&statFlag != 0 {
+			flags |= mountFlag
+		}
+	}
+
+	return syscall.Mount("", target, "", flags, "")
+}
@@ -0,0 +1,124 @@
+package filesystem
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"golang.org/x/sys/unix"
+)
+
+// inMountNamespace runs fn on a thread with its own mount namespace, so that the mounts it creates
+// don't leak to the host
+func inMountNamespace(fn func()) {
+	if os.Geteuid() != 0 {
+		Skip("creating a mount namespace requires root")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer GinkgoRecover()
+		// the thread is never unlocked, so that it exits with the goroutine together with its namespace
+		runtime.LockOSThread()
+		Expect(unix.Unshare(unix.CLONE_NEWNS)).To(Succeed())
+		Expect(unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, "")).To(Succeed())
+		fn()
+	}()
+	<-done
+}
+
+// mountOptions returns the per mount options of the mounts at or below target, as seen by the calling thread
+func mountOptions(target string) map[string]string {
+	f, err := os.Open("/proc/thread-self/mountinfo")
+	Expect(err).NotTo(HaveOccurred())
+	defer f.Close()
+
+	options := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if fields[4] == target || strings.HasPrefix(fields[4], target+"/") {
+			options[fields[4]] = fields[5]
+		}
+	}
+	Expect(scanner.Err()).NotTo(HaveOccurred())
+	return options
+}
+
+// readonly reports whether the per mount options include ro
+func readonly(options string) bool {
+	for _, option := range strings.Split(options, ",") {
+		if option == "ro" {
+			return true
+		}
+	}
+	return false
+}
+
+var _ = Describe("Masks", func() {
+
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "coso-filesystem")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("masks directories and files, skipping missing paths", func() {
+		Expect(os.MkdirAll(filepath.Join(dir, "proc", "acpi"), 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "proc", "acpi", "wakeup"), []byte("host"), 0644)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "proc", "kcore"), []byte("host"), 0644)).To(Succeed())
+
+		inMountNamespace(func() {
+			Expect(MaskPaths(dir, []string{"/proc/acpi", "/proc/kcore", "/proc/missing"})).To(Succeed())
+
+			entries, err := os.ReadDir(filepath.Join(dir, "proc", "acpi"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(BeEmpty())
+			err = os.WriteFile(filepath.Join(dir, "proc", "acpi", "new"), nil, 0644)
+			Expect(err).To(MatchError(syscall.EROFS))
+
+			content, err := os.ReadFile(filepath.Join(dir, "proc", "kcore"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(content).To(BeEmpty())
+		})
+	})
+
+	It("makes paths read only together with the mounts below them", func() {
+		sys := filepath.Join(dir, "proc", "sys")
+		Expect(os.MkdirAll(filepath.Join(sys, "kernel"), 0755)).To(Succeed())
+
+		inMountNamespace(func() {
+			Expect(syscall.Mount("tmpfs", filepath.Join(sys, "kernel"), "tmpfs", 0, "size=64k")).To(Succeed())
+			Expect(MakeReadonly(dir, []string{"/proc/sys", "/proc/missing"})).To(Succeed())
+
+			Expect(os.WriteFile(filepath.Join(sys, "file"), nil, 0644)).To(MatchError(syscall.EROFS))
+			Expect(os.WriteFile(filepath.Join(sys, "kernel", "file"), nil, 0644)).To(MatchError(syscall.EROFS))
+		})
+	})
+
+	It("mounts sysfs read only", func() {
+		inMountNamespace(func() {
+			Expect(MountSysfs(dir, true)).To(Succeed())
+
+			target := filepath.Join(dir, "sys")
+			options := mountOptions(target)
+			Expect(options).To(HaveKey(target))
+			for path, opts := range options {
+				Expect(readonly(opts)).To(BeTrue(), "%s is mounted %s", path, opts)
+			}
+		})
+	})
+})
@@ -0,0 +1,269 @@
+package filesystem
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Mount describes a filesystem mounted inside the container
+type Mount struct {
+	// Type is the filesystem type, e.g. tmpfs
+	Type string `json:"type"`
+	// Source is the device or path being mounted
+	Source string `json:"source"`
+	// Destination is the absolute mountpoint inside the container, created if missing
+	Destination string `json:"destination"`
+	// Options are the mount flags, e.g. ro or nosuid, and the filesystem specific options, e.g. size=64m
+	Options []string `json:"options,omitempty"`
+}
+
+// mountFlags maps the mount options which are mount flags to their value and whether they set or clear it
+var mountFlags = map[string]struct {
+	clear bool
+	flag  uintptr
+}{
+	"ro":          {false, syscall.MS_RDONLY},
+	"rw":          {true, syscall.MS_RDONLY},
+	"nosuid":      {false, syscall.MS_NOSUID},
+	"suid":        {true, syscall.MS_NOSUID},
+	"nodev":       {false, syscall.MS_NODEV},
+	"dev":         {true, syscall.MS_NODEV},
+	"noexec":      {false, syscall.MS_NOEXEC},
+	"exec":        {true, syscall.MS_NOEXEC},
+	"noatime":     {false, syscall.MS_NOATIME},
+	"atime":       {true, syscall.MS_NOATIME},
+	"nodiratime":  {false, syscall.MS_NODIRATIME},
+	"diratime":    {true, syscall.MS_NODIRATIME},
+	"relatime":    {false, syscall.MS_RELATIME},
+	"norelatime":  {true, syscall.MS_RELATIME},
+	"strictatime": {false, syscall.MS_STRICTATIME},
+	"sync":        {false, syscall.MS_SYNCHRONOUS},
+	"async":       {true, syscall.MS_SYNCHRONOUS},
+}
+
+// propagationFlags maps the propagation options of bind mounts to their mount flags
+var propagationFlags = map[string]uintptr{
+	"shared":   syscall.MS_SHARED,
+	"rshared":  syscall.MS_SHARED | syscall.MS_REC,
+	"slave":    syscall.MS_SLAVE,
+	"rslave":   syscall.MS_SLAVE | syscall.MS_REC,
+	"private":  syscall.MS_PRIVATE,
+	"rprivate": syscall.MS_PRIVATE | syscall.MS_REC,
+}
+
+// defaultTmpfsOptions are applied to tmpfs mounts before the ones explicitly set
+var defaultTmpfsOptions = []string{"nosuid", "nodev", "noexec"}
+
+// ParseTmpfs parses a path[:options] tmpfs specification, where options is a comma separated list
+// of mount flags and tmpfs options, e.g. /run:size=64m,mode=755
+func ParseTmpfs(s string) (Mount, error) {
+	path, options, _ := strings.Cut(s, ":")
+	if !filepath.IsAbs(path) {
+		return Mount{}, fmt.Errorf("invalid tmpfs mount %q, the path must be absolute", s)
+	}
+
+	m := Mount{
+		Type:        "tmpfs",
+		Source:      "tmpfs",
+		Destination: filepath.Clean(path),
+		Options:     append([]string(nil), defaultTmpfsOptions...),
+	}
+	if options != "" {
+		m.Options = append(m.Options, strings.Split(options, ",")...)
+	}
+	return m, nil
+}
+
+// ParseVolume parses a host-path|volume-name:container-path[:options] bind mount specification, where options
+// is a comma separated list of ro or rw, of a propagation mode and of idmap, e.g. /srv/data:/data:ro,rslave.
+// Bind mounts are recursive and private unless stated otherwise.
+//
+// A source which is not an absolute path is the name of a volume: the returned mount has type "volume",
+// to be replaced by a bind mount of the volume's directory before the container starts.
+func ParseVolume(s string) (Mount, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" {
+		return Mount{}, fmt.Errorf("invalid volume %q, expected host-path|volume-name:container-path[:options]", s)
+	}
+	if !filepath.IsAbs(parts[1]) {
+		return Mount{}, fmt.Errorf("invalid volume %q, the container path must be absolute", s)
+	}
+
+	m := Mount{
+		Type:        "bind",
+		Source:      filepath.Clean(parts[0]),
+		Destination: filepath.Clean(parts[1]),
+		Options:     []string{"rbind"},
+	}
+	if !filepath.IsAbs(parts[0]) {
+		m.Type = "volume"
+		m.Source = parts[0]
+	}
+	if len(parts) == 3 {
+		var access, propagation, idmap int
+		for _, option := range strings.Split(parts[2], ",") {
+			_, isPropagation := propagationFlags[option]
+			switch {
+			case option == "ro" || option == "rw":
+				access++
+			case isPropagation:
+				propagation++
+			case option == idmapOption:
+				idmap++
+			default:
+				return Mount{}, fmt.Errorf("invalid volume option %q", option)
+			}
+			m.Options = append(m.Options, option)
+		}
+		if access > 1 || propagation > 1 || idmap > 1 {
+			return Mount{}, fmt.Errorf("invalid volume %q, conflicting options", s)
+		}
+	}
+	return m, nil
+}
+
+// MountAll mounts the given filesystems under newroot, in order.
+// idmappedTrees are the trees returned by OpenIDMappedTree for the idmapped mounts, in the same order.
+func MountAll(newroot string, mounts []Mount, idmappedTrees []*os.File) error {
+	for _, m := range mounts {
+		var tree *os.File
+		if IDMapped(m) {
+			if len(idmappedTrees) == 0 {
+				return fmt.Errorf("missing the idmapped tree of %s", m.Destination)
+			}
+			tree, idmappedTrees = idmappedTrees[0], idmappedTrees[1:]
+		}
+
+		if err := mountFilesystem(newroot, m, tree); err != nil {
+			return &os.PathError{Op: "mount", Path: m.Destination, Err: err}
+		}
+	}
+	return nil
+}
+
+func mountFilesystem(newroot string, m Mount, tree *os.File) error {
+	// the destination is resolved inside the container, so that symlinks in the rootfs can't point to the host
+	target, err := SecureJoin(newroot, m.Destination)
+	if err != nil {
+		return err
+	}
+
+	if m.Type == "bind" {
+		return bindMount(m.Source, target, m.Options, tree)
+	}
+
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return err
+	}
+	flags, data := parseMountOptions(m.Options)
+	return syscall.Mount(m.Source, target, m.Type, flags, data)
+}
+
+// bindMount bind mounts source at target, creating the mountpoint as a file or a directory according
+// to the source type, then applies the access and propagation options.
+// When tree is not nil, it is attached at target in place of source.
+func bindMount(source, target string, options []string, tree *os.File) error {
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	if err := createMountpoint(target, info.IsDir()); err != nil {
+		return err
+	}
+
+	flags := uintptr(syscall.MS_BIND)
+	var readonly bool
+	var propagation uintptr
+	for _, option := range options {
+		switch option {
+		case "rbind":
+			flags |= syscall.MS_REC
+		case "ro":
+			readonly = true
+		case "rw":
+			readonly = false
+		default:
+			if p, ok := propagationFlags[option]; ok {
+				propagation = p
+			}
+		}
+	}
+	if propagation == 0 {
+		propagation = syscall.MS_PRIVATE | flags&syscall.MS_REC
+	}
+
+	if tree != nil {
+		err = attachTree(tree, target)
+	} else {
+		err = syscall.Mount(source, target, "", flags, "")
+	}
+	if err != nil {
+		return err
+	}
+	if readonly {
+		if err := makeReadonly(target, flags&syscall.MS_REC != 0); err != nil {
+			return err
+		}
+	}
+	return syscall.Mount("", target, "", propagation, "")
+}
+
+// createMountpoint creates an empty directory or file at target, unless it already exists
+func createMountpoint(target string, dir bool) error {
+	if dir {
+		return os.MkdirAll(target, 0755)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// makeReadonly makes the bind mount at target read only, together with every mount below it when recursive.
+// Kernels older than 5.12, lacking mount_setattr, only support making the top mount read only.
+func makeReadonly(target string, recursive bool) error {
+	if recursive {
+		attr := unix.MountAttr{Attr_set: unix.MOUNT_ATTR_RDONLY}
+		err := unix.MountSetattr(unix.AT_FDCWD, target, unix.AT_RECURSIVE, &attr)
+		if err != unix.ENOSYS {
+			return err
+		}
+	}
+	return remountReadonly(target)
+}
+
+// parseMountOptions splits mount options into mount flags, applied in order, and filesystem specific data
+func parseMountOptions(options []string) (uintptr, string) {
+	var flags uintptr
+	var data []string
+	for _, option := range options {
+		f, ok := mountFlags[option]
+		switch {
+		case !ok:
+			data = append(data, option)
+		case f.clear:
+			flags &^= f.flag
+		default:
+			flags |= f.flag
+		}
+	}
+	return flags, strings.Join(data, ",")
+}
+
+// RemountRootReadonly makes the root of the calling process' mount namespace read only.
+// Filesystems mounted under it are not affected.
+//
+// It must be called after PivotRoot, since the new root is a bind mount.
+func RemountRootReadonly() error {
+	return remountReadonly("/")
+}
@@ -0,0 +1,72 @@
+package filesystem
+
+import (
+	"syscall"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Mounts", func() {
+
+	DescribeTable("ParseVolume parses valid volumes",
+		func(volume string, expected Mount) {
+			m, err := ParseVolume(volume)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(m).To(Equal(expected))
+		},
+		Entry("without options", "/srv/data/:/data", Mount{Type: "bind", Source: "/srv/data", Destination: "/data", Options: []string{"rbind"}}),
+		Entry("read only", "/srv/data:/data:ro", Mount{Type: "bind", Source: "/srv/data", Destination: "/data", Options: []string{"rbind", "ro"}}),
+		Entry("with propagation", "/srv/data:/data:rw,rslave", Mount{Type: "bind", Source: "/srv/data", Destination: "/data", Options: []string{"rbind", "rw", "rslave"}}),
+		Entry("idmapped", "/srv/data:/data:idmap", Mount{Type: "bind", Source: "/srv/data", Destination: "/data", Options: []string{"rbind", "idmap"}}),
+		Entry("a named volume", "db:/data:ro", Mount{Type: "volume", Source: "db", Destination: "/data", Options: []string{"rbind", "ro"}}),
+	)
+
+	DescribeTable("ParseVolume rejects invalid volumes",
+		func(volume string) {
+			_, err := ParseVolume(volume)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("a single path", "/srv/data"),
+		Entry("an empty source", ":/data"),
+		Entry("a relative container path", "/srv/data:data"),
+		Entry("an unknown option", "/srv/data:/data:exec"),
+		Entry("conflicting options", "/srv/data:/data:ro,rw"),
+		Entry("too many fields", "/srv/data:/data:ro:rshared"),
+	)
+
+	Describe("ParseTmpfs", func() {
+		It("adds the default options", func() {
+			m, err := ParseTmpfs("/run:size=64m,mode=755,exec")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(m).To(Equal(Mount{
+				Type:        "tmpfs",
+				Source:      "tmpfs",
+				Destination: "/run",
+				Options:     []string{"nosuid", "nodev", "noexec", "size=64m", "mode=755", "exec"},
+			}))
+		})
+
+		It("rejects relative paths", func() {
+			_, err := ParseTmpfs("run")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("MountAll", func() {
+		It("fails when an idmapped mount is missing its tree", func() {
+			mounts := []Mount{{Type: "bind", Source: "/srv", Destination: "/srv", Options: []string{"rbind", idmapOption}}}
+			err := MountAll("/nonexistent", mounts, nil)
+			Expect(err).To(MatchError("missing the idmapped tree of /srv"))
+		})
+	})
+
+	Describe("parseMountOptions", func() {
+		It("splits flags, applied in order, from data", func() {
+			flags, data := parseMountOptions([]string{"nosuid", "noexec", "size=64m", "ro", "exec", "mode=755"})
+			Expect(flags).To(Equal(uintptr(syscall.MS_NOSUID | syscall.MS_RDONLY)))
+			Expect(data).To(Equal("size=64m,mode=755"))
+		})
+	})
+})
@@ -0,0 +1,160 @@
+package filesystem
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+const (
+	// DefaultContainersPath holds a directory for each container, storing its writable layer
+	DefaultContainersPath = "/tmp/coso/containers"
+
+	upperDir  = "upper"
+	workDir   = "work"
+	mergedDir = "merged"
+	// runningLock is locked by the coso process running the container, for as long as it runs
+	runningLock = ".running"
+	// SpecFile holds the spec of the container, so that it can be found once it exited
+	SpecFile = "config.json"
+)
+
+// ContainerPath returns the directory holding the state of the container with the given ID
+func ContainerPath(id string) string {
+	return filepath.Join(DefaultContainersPath, id)
+}
+
+// ContainerIDs returns the IDs of the containers whose directory exists: the running ones
+// and the ones whose writable layer was kept after they exited
+func ContainerIDs() ([]string, error) {
+	entries, err := os.ReadDir(DefaultContainersPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			ids = append(ids, entry.Name())
+		}
+	}
+	return ids, nil
+}
+
+// PrepareOverlay creates the upper, work and merged directories of the container's overlay filesystem.
+// With a quota, the upper and work directories are stored in a size limited filesystem.
+//
+// It is executed on the host before the container is started, so that the directories are owned by the
+// invoking user, which is mapped to root inside the container.
+func PrepareOverlay(containerPath string, quota *StorageQuota) error {
+	if err := os.MkdirAll(filepath.Join(containerPath, mergedDir), 0755); err != nil {
+		return err
+	}
+
+	if quota != nil {
+		if err := prepareStorage(containerPath, quota); err != nil {
+			return err
+		}
+		// the directories are created inside the container, once the tmpfs is mounted
+		if quota.Type == StorageTmpfs {
+			return nil
+		}
+	}
+	if err := createLayerDirs(layerPath(containerPath, quota)); err != nil {
+		ReleaseStorage(containerPath, quota)
+		return err
+	}
+	return nil
+}
+
+// MountOverlay mounts an overlay filesystem using lowerdir as read only lower layer and the upper
+// directory of the container as writable layer, so that lowerdir is never modified.
+// It returns the path of the merged tree, to be used as the container's root.
+func MountOverlay(lowerdir, containerPath string, quota *StorageQuota) (string, error) {
+	if quota != nil && quota.Type == StorageTmpfs {
+		if err := mountStorageTmpfs(containerPath, quota); err != nil {
+			return "", err
+		}
+		if err := createLayerDirs(layerPath(containerPath, quota)); err != nil {
+			return "", err
+		}
+	}
+
+	layer := layerPath(containerPath, quota)
+	upper := filepath.Join(layer, upperDir)
+	work := filepath.Join(layer, workDir)
+	merged := filepath.Join(containerPath, mergedDir)
+
+	for _, path := range []string{lowerdir, upper, work} {
+		// the separators of the mount options can't be part of the paths
+		if strings.ContainsAny(path, ",:") {
+			return "", fmt.Errorf("the overlay layer path '%s' can't contain ',' or ':'", path)
+		}
+	}
+
+	data := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", lowerdir, upper, work)
+	if err := syscall.Mount("overlay", merged, "overlay", 0, data); err != nil {
+		return "", err
+	}
+	return merged, nil
+}
+
+// MountUpper returns the upper directory of the writable layer of an exited container, holding the
+// changes it made to its root filesystem. With an image quota, the image is mounted again, and must
+// be released with ReleaseStorage.
+func MountUpper(containerPath string, quota *StorageQuota) (string, error) {
+	if quota != nil {
+		switch quota.Type {
+		case StorageTmpfs:
+			return "", fmt.Errorf("the writable layer was discarded together with its %s storage", quota.Type)
+		case StorageImage:
+			if err := mountImage(filepath.Join(containerPath, storageImageFile), filepath.Join(containerPath, storageDir)); err != nil {
+				return "", err
+			}
+		}
+	}
+	return filepath.Join(layerPath(containerPath, quota), upperDir), nil
+}
+
+func createLayerDirs(layer string) error {
+	for _, dir := range []string{upperDir, workDir} {
+		if err := os.MkdirAll(filepath.Join(layer, dir), 0755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveContainer deletes the container's directory, discarding its writable layer
+func RemoveContainer(containerPath string) error {
+	return os.RemoveAll(containerPath)
+}
+
+// LockRunning marks the container as running until the returned file is closed, or the calling
+// process exits
+func LockRunning(containerPath string) (*os.File, error) {
+	lock, err := os.OpenFile(filepath.Join(containerPath, runningLock), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("the container is already running: %w", err)
+	}
+	return lock, nil
+}
+
+// IsRunning reports whether a coso process is running the container
+func IsRunning(containerPath string) bool {
+	lock, err := os.Open(filepath.Join(containerPath, runningLock))
+	if err != nil {
+		return false
+	}
+	defer lock.Close()
+	return syscall.Flock(int(lock.Fd()), syscall.LOCK_SH|syscall.LOCK_NB) == syscall.EWOULDBLOCK
+}
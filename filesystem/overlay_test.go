@@ -0,0 +1,51 @@
+package filesystem
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Overlay", func() {
+
+	var containerPath string
+
+	BeforeEach(func() {
+		var err error
+		containerPath, err = os.MkdirTemp("", "coso-filesystem")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(containerPath)).To(Succeed())
+	})
+
+	It("marks containers as running until unlocked", func() {
+		Expect(IsRunning(containerPath)).To(BeFalse())
+
+		lock, err := LockRunning(containerPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(IsRunning(containerPath)).To(BeTrue())
+
+		_, err = LockRunning(containerPath)
+		Expect(err).To(MatchError(ContainSubstring("already running")))
+
+		Expect(lock.Close()).To(Succeed())
+		Expect(IsRunning(containerPath)).To(BeFalse())
+	})
+
+	It("reports containers without a directory as not running", func() {
+		Expect(IsRunning(containerPath + "/missing")).To(BeFalse())
+	})
+
+	It("returns the upper directory of the writable layer", func() {
+		Expect(MountUpper(containerPath, nil)).To(Equal(filepath.Join(containerPath, "upper")))
+	})
+
+	It("refuses writable layers discarded with their tmpfs", func() {
+		_, err := MountUpper(containerPath, &StorageQuota{Size: 1 << 20, Type: StorageTmpfs})
+		Expect(err).To(MatchError(ContainSubstring("discarded")))
+	})
+})
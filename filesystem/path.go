@@ -0,0 +1,63 @@
+package filesystem
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// maxSymlinks is the maximum number of symlinks followed while resolving a path, as in the kernel
+const maxSymlinks = 40
+
+// SecureJoin joins unsafePath to root resolving any symlink as if root was the root of the filesystem,
+// so that neither symlinks nor ".." components can point outside of root.
+// Components which don't exist are joined lexically.
+func SecureJoin(root, unsafePath string) (string, error) {
+	resolved := "/"
+	followed := 0
+
+	for unsafePath != "" {
+		var part string
+		part, unsafePath, _ = strings.Cut(unsafePath, "/")
+
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			// the root is its own parent
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, part)
+		info, err := os.Lstat(filepath.Join(root, next))
+		if os.IsNotExist(err) {
+			resolved = next
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		followed++
+		if followed > maxSymlinks {
+			return "", &os.PathError{Op: "resolve", Path: next, Err: syscall.ELOOP}
+		}
+		target, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		// absolute targets are relative to root, relative ones to the symlink's directory
+		if filepath.IsAbs(target) {
+			resolved = "/"
+		}
+		unsafePath = target + "/" + unsafePath
+	}
+
+	return filepath.Join(root, resolved), nil
+}
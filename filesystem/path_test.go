@@ -0,0 +1,50 @@
+package filesystem
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SecureJoin", func() {
+
+	var root string
+
+	BeforeEach(func() {
+		var err error
+		root, err = os.MkdirTemp("", "coso-filesystem")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(os.MkdirAll(filepath.Join(root, "etc", "conf.d"), 0755)).To(Succeed())
+		Expect(os.Symlink("/etc", filepath.Join(root, "absolute"))).To(Succeed())
+		Expect(os.Symlink("../../../..", filepath.Join(root, "etc", "escape"))).To(Succeed())
+		Expect(os.Symlink("conf.d", filepath.Join(root, "etc", "relative"))).To(Succeed())
+		Expect(os.Symlink("loop", filepath.Join(root, "loop"))).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(root)).To(Succeed())
+	})
+
+	DescribeTable("resolves paths inside the root",
+		func(unsafePath, expected string) {
+			path, err := SecureJoin(root, unsafePath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(path).To(Equal(filepath.Join(root, expected)))
+		},
+		Entry("a plain path", "/etc/conf.d", "/etc/conf.d"),
+		Entry("dot dot components", "/../../etc/../../etc", "/etc"),
+		Entry("an absolute symlink", "/absolute/conf.d", "/etc/conf.d"),
+		Entry("a relative symlink", "/etc/relative/file", "/etc/conf.d/file"),
+		Entry("a symlink pointing outside", "/etc/escape/tmp", "/tmp"),
+		Entry("a missing path", "/missing/../dir", "/dir"),
+	)
+
+	It("fails on symlink loops", func() {
+		_, err := SecureJoin(root, "/loop/dir")
+		Expect(err).To(HaveOccurred())
+	})
+})
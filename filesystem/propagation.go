@@ -0,0 +1,50 @@
+package filesystem
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// DefaultRootfsPropagation isolates the container's mounts from the host's ones, in both directions
+const DefaultRootfsPropagation = "rprivate"
+
+// ValidatePropagation checks mode is one of the supported propagation modes:
+// private, slave and shared, applied recursively when prefixed by r
+func ValidatePropagation(mode string) error {
+	if _, ok := propagationFlags[mode]; !ok {
+		return fmt.Errorf("invalid propagation mode '%s', expected one of [r]private, [r]slave or [r]shared", mode)
+	}
+	return nil
+}
+
+// SetRootPropagation changes the propagation of the calling process' root mount, and of the mounts below it
+// for recursive modes. It must be the first step of the mount namespace setup, since the new namespace
+// inherits the host's propagation: with shared mounts, the container's mounts would show up on the host
+// and pivot_root would fail.
+//
+// Shared modes are applied as slave ones, so that the mounts done while setting up the container don't
+// propagate to the host: ShareRoot makes the new root shared once it is in place.
+func SetRootPropagation(mode string) error {
+	if mode == "" {
+		mode = DefaultRootfsPropagation
+	}
+	if err := ValidatePropagation(mode); err != nil {
+		return err
+	}
+
+	flags := propagationFlags[mode]
+	if flags&syscall.MS_SHARED != 0 {
+		flags = flags&^syscall.MS_SHARED | syscall.MS_SLAVE
+	}
+	return syscall.Mount("", "/", "", flags, "")
+}
+
+// ShareRoot applies a shared propagation mode to the container's root, after PivotRoot,
+// so that mounts done in nested mount namespaces are propagated to it and vice versa
+func ShareRoot(mode string) error {
+	flags := propagationFlags[mode]
+	if flags&syscall.MS_SHARED == 0 {
+		return nil
+	}
+	return syscall.Mount("", "/", "", flags, "")
+}
@@ -98,6 +98,8 @@ func VerifyRootfsExists(rootfsPath string) {
 		sb.WriteString("Please create this directory and unpack a suitable root filesystem inside it.\n")
 		sb.WriteString("You can run the following command to set it up with the provided Alpine filesystem for x86_64 architecture:")
 		sb.WriteString("\n\nmake fs-setup\n")
+		sb.WriteString("\nor import a root filesystem archive and pass its name to --rootfs:")
+		sb.WriteString("\n\ncoso rootfs import --name alpine assets/alpine-minirootfs-3.18.2-x86_64.tar.gz\n")
 
 		fmt.Println(sb.String())
 		os.Exit(1)
@@ -0,0 +1,190 @@
+package filesystem
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// StorageImage backs the writable layer with a sparse ext4 image attached to a loop device
+	StorageImage = "image"
+	// StorageTmpfs backs the writable layer with a tmpfs, discarded when the container exits
+	StorageTmpfs = "tmpfs"
+
+	// storageDir is the mountpoint of the filesystem holding the upper and work directories, when limited
+	storageDir = "storage"
+	// storageImageFile is the ext4 image backing the writable layer
+	storageImageFile = "storage.img"
+)
+
+// StorageQuota limits the size of the writable layer of a container
+type StorageQuota struct {
+	// Size is the maximum size in bytes
+	Size int64 `json:"size"`
+	// Type is StorageImage or StorageTmpfs
+	Type string `json:"type"`
+}
+
+// ParseSize parses a size in bytes, optionally followed by one of the k, m, g or t binary units, e.g. 2g
+func ParseSize(s string) (int64, error) {
+	units := map[byte]int64{'k': 1 << 10, 'm': 1 << 20, 'g': 1 << 30, 't': 1 << 40}
+
+	number := strings.TrimSuffix(strings.ToLower(s), "b")
+	multiplier := int64(1)
+	if number != "" {
+		if m, ok := units[number[len(number)-1]]; ok {
+			multiplier = m
+			number = number[:len(number)-1]
+		}
+	}
+
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n <= 0 || n > (1<<62)/multiplier {
+		return 0, fmt.Errorf("invalid size '%s'", s)
+	}
+	return n * multiplier, nil
+}
+
+// layerPath returns the directory holding the upper and work directories of the container
+func layerPath(containerPath string, quota *StorageQuota) string {
+	if quota == nil {
+		return containerPath
+	}
+	return filepath.Join(containerPath, storageDir)
+}
+
+// prepareStorage creates the size limited filesystem holding the writable layer.
+//
+// Images are formatted and mounted on the host, since ext4 can't be mounted inside a user namespace,
+// while tmpfs is mounted by MountOverlay inside the container's mount namespace.
+func prepareStorage(containerPath string, quota *StorageQuota) error {
+	mountpoint := filepath.Join(containerPath, storageDir)
+	if err := os.MkdirAll(mountpoint, 0755); err != nil {
+		return err
+	}
+
+	switch quota.Type {
+	case StorageTmpfs:
+		return nil
+	case StorageImage:
+	default:
+		return fmt.Errorf("unknown storage type '%s'", quota.Type)
+	}
+
+	image := filepath.Join(containerPath, storageImageFile)
+	if err := createImage(image, quota.Size); err != nil {
+		return err
+	}
+	return mountImage(image, mountpoint)
+}
+
+// createImage creates a sparse file of the given size, formatted as ext4
+func createImage(path string, size int64) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	err = f.Truncate(size)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	// no space is reserved for root, who owns every file written by the container
+	out, err := exec.Command("mkfs.ext4", "-q", "-F", "-m", "0", path).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("mkfs.ext4: %s: %w", strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
+
+// mountImage attaches the image to a free loop device and mounts it at mountpoint.
+// The loop device is detached as soon as the image is unmounted.
+func mountImage(image, mountpoint string) error {
+	loop, err := attachLoopDevice(image)
+	if err != nil {
+		return err
+	}
+	defer loop.Close()
+
+	if err := syscall.Mount(loop.Name(), mountpoint, "ext4", syscall.MS_NODEV|syscall.MS_NOSUID, ""); err != nil {
+		return err
+	}
+	return nil
+}
+
+// attachLoopDevice binds the backing file to a free loop device, which is cleared once its last user is gone
+func attachLoopDevice(backingFile string) (*os.File, error) {
+	control, err := os.OpenFile("/dev/loop-control", os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer control.Close()
+
+	backing, err := os.OpenFile(backingFile, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer backing.Close()
+
+	// another process may take the free device before it is configured
+	for attempt := 0; attempt < 10; attempt++ {
+		index, err := unix.IoctlRetInt(int(control.Fd()), unix.LOOP_CTL_GET_FREE)
+		if err != nil {
+			return nil, err
+		}
+
+		loop, err := os.OpenFile(fmt.Sprintf("/dev/loop%d", index), os.O_RDWR, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		err = unix.IoctlSetInt(int(loop.Fd()), unix.LOOP_SET_FD, int(backing.Fd()))
+		if err == unix.EBUSY {
+			loop.Close()
+			continue
+		}
+		if err != nil {
+			loop.Close()
+			return nil, err
+		}
+
+		info := unix.LoopInfo64{Flags: unix.LO_FLAGS_AUTOCLEAR}
+		copy(info.File_name[:], backingFile)
+		if _, _, errno := unix.Syscall(unix.SYS_IOCTL, loop.Fd(), unix.LOOP_SET_STATUS64, uintptr(unsafe.Pointer(&info))); errno != 0 {
+			unix.IoctlSetInt(int(loop.Fd()), unix.LOOP_CLR_FD, 0)
+			loop.Close()
+			return nil, errno
+		}
+		return loop, nil
+	}
+	return nil, fmt.Errorf("unable to find a free loop device")
+}
+
+// mountStorageTmpfs mounts the size limited tmpfs holding the writable layer
+func mountStorageTmpfs(containerPath string, quota *StorageQuota) error {
+	mountpoint := filepath.Join(containerPath, storageDir)
+	data := fmt.Sprintf("size=%d,mode=755", quota.Size)
+	return syscall.Mount("tmpfs", mountpoint, "tmpfs", syscall.MS_NODEV|syscall.MS_NOSUID, data)
+}
+
+// ReleaseStorage unmounts the image backing the writable layer, detaching its loop device.
+// The image itself is kept until the container's directory is removed.
+func ReleaseStorage(containerPath string, quota *StorageQuota) error {
+	if quota == nil || quota.Type != StorageImage {
+		return nil
+	}
+	err := syscall.Unmount(filepath.Join(containerPath, storageDir), syscall.MNT_DETACH)
+	if err != nil && err != syscall.EINVAL {
+		return err
+	}
+	return nil
+}
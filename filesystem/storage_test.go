@@ -0,0 +1,36 @@
+package filesystem
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Storage", func() {
+
+	DescribeTable("ParseSize parses sizes with binary units",
+		func(size string, expected int64) {
+			n, err := ParseSize(size)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n).To(Equal(expected))
+		},
+		Entry("bytes", "4096", int64(4096)),
+		Entry("kilobytes", "512k", int64(512<<10)),
+		Entry("megabytes", "64M", int64(64<<20)),
+		Entry("gigabytes with a b suffix", "2gb", int64(2<<30)),
+		Entry("terabytes", "1t", int64(1<<40)),
+	)
+
+	DescribeTable("ParseSize rejects invalid sizes",
+		func(size string) {
+			_, err := ParseSize(size)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("an empty size", ""),
+		Entry("a unit only", "g"),
+		Entry("zero", "0m"),
+		Entry("a negative size", "-1g"),
+		Entry("an unknown unit", "2x"),
+		Entry("an overflowing size", "9999999999t"),
+	)
+})
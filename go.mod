@@ -7,7 +7,11 @@ require (
 	golang.org/x/sys v0.18.0
 )
 
-require github.com/vishvananda/netns v0.0.0-20191106174202-0a2b9b5464df
+require (
+	github.com/klauspost/compress v1.17.9
+	github.com/ulikunitz/xz v0.5.12
+	github.com/vishvananda/netns v0.0.0-20191106174202-0a2b9b5464df
+)
 
 require (
 	github.com/fsnotify/fsnotify v1.4.9 // indirect
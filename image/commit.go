@@ -0,0 +1,155 @@
+package image
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/NamelessOne91/coso/archive"
+)
+
+// CommitOptions controls how the writable layer of a container is turned into an image
+type CommitOptions struct {
+	// Parent is the ID of the image the container was created from: the new image adds a layer to
+	// its layers, and keeps its configuration
+	Parent string
+	// Rootfs is the root filesystem the container ran on when created from no image, archived
+	// as the lowest layer of the new image
+	Rootfs string
+	// Config holds the defaults of the containers created from the image, when there is no parent
+	Config ContainerConfig
+	// Tags are the references the image is tagged with
+	Tags []string
+	// Archive controls how the directories are archived into layers
+	Archive archive.CreateOptions
+	// Extract controls how the layers are extracted into the root filesystem of the image
+	Extract archive.Options
+	// ExtractID identifies the Extract options, as for LoadOptions
+	ExtractID string
+}
+
+// Commit adds the image made of the layers of the parent image, or of the root filesystem, and of
+// a layer holding the content of upper: the upper directory of the overlay filesystem of a container,
+// whose whiteouts are turned into OCI whiteouts.
+func (s *Store) Commit(upper string, opts CommitOptions) (*Image, error) {
+	var tags []string
+	for _, tag := range opts.Tags {
+		ref, err := ParseReference(tag)
+		if err != nil {
+			return nil, err
+		}
+		if ref.Digest != "" {
+			return nil, fmt.Errorf("images can't be tagged with a digest: '%s'", tag)
+		}
+		tags = append(tags, ref.String())
+	}
+
+	staged, err := s.stage()
+	if err != nil {
+		return nil, err
+	}
+	defer staged.discard()
+	return staged.commitLayers(upper, tags, opts)
+}
+
+// commitLayers stores the layers, the configuration and the manifest of the committed image into the
+// staging directory, and registers it
+func (s *Store) commitLayers(upper string, tags []string, opts CommitOptions) (*Image, error) {
+	platform := DefaultPlatform()
+	manifest := Manifest{SchemaVersion: 2, MediaType: MediaTypeImageManifest}
+	config := Config{Architecture: platform.Architecture, OS: platform.OS, Config: opts.Config, RootFS: RootFS{Type: "layers"}}
+	if opts.Parent != "" {
+		parent, err := s.Get(opts.Parent)
+		if err != nil {
+			return nil, err
+		}
+		parentManifest, err := s.Manifest(parent)
+		if err != nil {
+			return nil, err
+		}
+		parentConfig, err := s.Config(parent)
+		if err != nil {
+			return nil, err
+		}
+		manifest, config = *parentManifest, *parentConfig
+		manifest.Annotations = nil
+	}
+
+	layerType := MediaTypeImageLayerGzip
+	if manifest.MediaType == MediaTypeDockerManifest {
+		layerType = MediaTypeDockerLayerGzip
+	}
+	layers := []string{upper}
+	if opts.Parent == "" {
+		layers = []string{opts.Rootfs, upper}
+	}
+	for _, dir := range layers {
+		archiveOpts := opts.Archive
+		archiveOpts.Whiteouts = dir == upper
+		layer, diffID, err := s.putLayer(dir, archiveOpts)
+		if err != nil {
+			return nil, fmt.Errorf("archiving %s: %w", dir, err)
+		}
+		layer.MediaType = layerType
+		manifest.Layers = append(manifest.Layers, layer)
+		config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, diffID)
+	}
+
+	created := time.Now().UTC()
+	config.Created = &created
+	content, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	digest, size, err := s.PutBlob(strings.NewReader(string(content)), "", 0)
+	if err != nil {
+		return nil, err
+	}
+	configType := MediaTypeImageConfig
+	if manifest.MediaType == MediaTypeDockerManifest {
+		configType = MediaTypeDockerConfig
+	}
+	manifest.Config = Descriptor{MediaType: configType, Digest: digest, Size: size}
+
+	if content, err = json.Marshal(manifest); err != nil {
+		return nil, err
+	}
+	if digest, _, err = s.PutBlob(strings.NewReader(string(content)), "", 0); err != nil {
+		return nil, err
+	}
+
+	// the blobs of the parent image are already in the store
+	fetch := func(d Descriptor) error {
+		if !s.HasBlob(d.Digest) {
+			return fmt.Errorf("%w: %s", ErrBlobNotFound, d.Digest)
+		}
+		return nil
+	}
+	return s.createImage(digest, fetch, tags, opts.Extract, opts.ExtractID)
+}
+
+// putLayer stores the gzip compressed archive of dir as a layer blob, and returns its descriptor
+// together with the digest of the uncompressed archive
+func (s *Store) putLayer(dir string, opts archive.CreateOptions) (Descriptor, string, error) {
+	r, w := io.Pipe()
+	d := newDigester()
+	go func() {
+		gz := gzip.NewWriter(w)
+		err := archive.Create(io.MultiWriter(gz, d), dir, opts)
+		if closeErr := gz.Close(); err == nil {
+			err = closeErr
+		}
+		w.CloseWithError(err)
+	}()
+
+	digest, size, err := s.PutBlob(r, "", 0)
+	// unblock the archiving goroutine when the blob can't be written
+	r.Close()
+	if err != nil {
+		return Descriptor{}, "", err
+	}
+	return Descriptor{Digest: digest, Size: size}, d.Digest(), nil
+}
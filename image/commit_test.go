@@ -0,0 +1,94 @@
+package image
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/NamelessOne91/coso/archive"
+)
+
+var _ = Describe("Commit", func() {
+
+	var (
+		dir     string
+		store   *Store
+		upper   string
+		extract archive.Options
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "coso-image")
+		Expect(err).NotTo(HaveOccurred())
+		store = NewStore(filepath.Join(dir, "store"))
+		extract = archive.Options{Rootless: os.Geteuid() != 0}
+
+		upper = filepath.Join(dir, "upper")
+		Expect(os.MkdirAll(filepath.Join(upper, "etc"), 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(upper, "etc", "motd"), []byte("committed"), 0644)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("adds the writable layer to the layers of the parent image", func() {
+		layout := filepath.Join(dir, "layout")
+		writeIndex(layout, writeImage(layout, DefaultPlatform(), "/bin/app", []entry{{"app", "app"}, {"old", "old"}}))
+		images, err := store.Load(layout, LoadOptions{Tags: []string{"app:1.0"}, Extract: extract})
+		Expect(err).NotTo(HaveOccurred())
+		parent := images[0]
+		if os.Geteuid() == 0 {
+			Expect(unix.Mknod(filepath.Join(upper, "old"), unix.S_IFCHR|0, 0)).To(Succeed())
+		}
+
+		img, err := store.Commit(upper, CommitOptions{Parent: parent.ID, Tags: []string{"app:2.0"}, Extract: extract})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(img.Tags).To(Equal([]string{"docker.io/library/app:2.0"}))
+		Expect(img.Layers).To(HaveLen(2))
+		Expect(img.Layers[0]).To(Equal(parent.Layers[0]))
+		Expect(img.Layers[1].MediaType).To(Equal(MediaTypeImageLayerGzip))
+
+		config, err := store.Config(img)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config.Config.Cmd).To(Equal([]string{"/bin/app"}))
+		Expect(config.RootFS.DiffIDs).To(HaveLen(2))
+
+		rootfs := store.RootfsPath(img)
+		content, err := os.ReadFile(filepath.Join(rootfs, "etc", "motd"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).To(Equal("committed"))
+		Expect(filepath.Join(rootfs, "app")).To(BeARegularFile())
+		if os.Geteuid() == 0 {
+			Expect(filepath.Join(rootfs, "old")).NotTo(BeAnExistingFile())
+		}
+	})
+
+	It("archives the root filesystem as the lowest layer without a parent image", func() {
+		rootfs := filepath.Join(dir, "rootfs")
+		Expect(os.MkdirAll(filepath.Join(rootfs, "bin"), 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(rootfs, "bin", "sh"), []byte("sh"), 0755)).To(Succeed())
+
+		img, err := store.Commit(upper, CommitOptions{Rootfs: rootfs, Config: ContainerConfig{Cmd: []string{"/bin/sh"}}, Extract: extract})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(img.Tags).To(BeEmpty())
+		Expect(img.Layers).To(HaveLen(2))
+		Expect(img.Platform).To(Equal(DefaultPlatform()))
+
+		config, err := store.Config(img)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config.Config.Cmd).To(Equal([]string{"/bin/sh"}))
+		Expect(filepath.Join(store.RootfsPath(img), "bin", "sh")).To(BeARegularFile())
+		Expect(filepath.Join(store.RootfsPath(img), "etc", "motd")).To(BeARegularFile())
+	})
+
+	It("rejects tags with a digest", func() {
+		_, err := store.Commit(upper, CommitOptions{Rootfs: upper, Tags: []string{"app@sha256:" + digestHex(Digest(nil))}})
+		Expect(err).To(MatchError(ContainSubstring("can't be tagged with a digest")))
+	})
+})
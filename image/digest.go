@@ -0,0 +1,65 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"regexp"
+	"strings"
+)
+
+// sha256Prefix prefixes the only digests supported: content is addressed by its SHA-256
+const sha256Prefix = "sha256:"
+
+var sha256Hex = regexp.MustCompile(`^[a-f0-9]{64}$`)
+
+// ValidateDigest checks digest is a sha256:<hex> digest
+func ValidateDigest(digest string) error {
+	hex, found := strings.CutPrefix(digest, sha256Prefix)
+	if !found || !sha256Hex.MatchString(hex) {
+		return fmt.Errorf("invalid or unsupported digest '%s', expected sha256:<64 hex characters>", digest)
+	}
+	return nil
+}
+
+// Digest returns the digest of content
+func Digest(content []byte) string {
+	sum := sha256.Sum256(content)
+	return sha256Prefix + hex.EncodeToString(sum[:])
+}
+
+// digestHex returns the hex encoded hash of the digest, without the algorithm
+func digestHex(digest string) string {
+	return strings.TrimPrefix(digest, sha256Prefix)
+}
+
+// digester computes the digest of the content written to it
+type digester struct {
+	hash hash.Hash
+	size int64
+}
+
+func newDigester() *digester {
+	return &digester{hash: sha256.New()}
+}
+
+func (d *digester) Write(p []byte) (int, error) {
+	d.size += int64(len(p))
+	return d.hash.Write(p)
+}
+
+func (d *digester) Digest() string {
+	return sha256Prefix + hex.EncodeToString(d.hash.Sum(nil))
+}
+
+// verify checks the content written matches the expected digest and, when positive, the expected size
+func (d *digester) verify(digest string, size int64) error {
+	if actual := d.Digest(); actual != digest {
+		return fmt.Errorf("digest mismatch: got %s, expected %s", actual, digest)
+	}
+	if size > 0 && d.size != size {
+		return fmt.Errorf("size mismatch for %s: got %d bytes, expected %d", digest, d.size, size)
+	}
+	return nil
+}
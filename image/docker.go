@@ -0,0 +1,94 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// dockerManifestFile lists the images of the archives written by docker save
+const dockerManifestFile = "manifest.json"
+
+// dockerArchiveImage is an entry of the manifest.json file of a docker save archive
+type dockerArchiveImage struct {
+	// Config is the name of the image configuration file
+	Config string `json:"Config"`
+	// RepoTags are the references the image was saved with
+	RepoTags []string `json:"RepoTags"`
+	// Layers are the names of the uncompressed layer archives, lowest first
+	Layers []string `json:"Layers"`
+}
+
+// loadDocker loads the images listed in the manifest.json file of a docker save archive. The archive
+// doesn't hold image manifests, so a Docker image manifest v2 is generated for each image.
+func (s *Store) loadDocker(src *source, opts LoadOptions) ([]*Image, error) {
+	content, err := src.readFile(dockerManifestFile)
+	if err != nil {
+		return nil, err
+	}
+	var entries []dockerArchiveImage
+	if err := json.Unmarshal(content, &entries); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", dockerManifestFile, err)
+	}
+
+	var images []*Image
+	for _, entry := range entries {
+		manifest := Manifest{SchemaVersion: 2, MediaType: MediaTypeDockerManifest}
+		if manifest.Config, err = src.ingestFile(entry.Config, MediaTypeDockerConfig); err != nil {
+			return images, fmt.Errorf("reading the configuration %s: %w", entry.Config, err)
+		}
+		for _, name := range entry.Layers {
+			layer, err := src.ingestFile(name, MediaTypeDockerLayer)
+			if err != nil {
+				return images, fmt.Errorf("reading layer %s: %w", name, err)
+			}
+			manifest.Layers = append(manifest.Layers, layer)
+		}
+
+		content, err := json.Marshal(manifest)
+		if err != nil {
+			return images, err
+		}
+		manifestDigest, _, err := s.PutBlob(strings.NewReader(string(content)), "", 0)
+		if err != nil {
+			return images, err
+		}
+
+		tags := opts.Tags
+		if len(tags) == 0 {
+			for _, repoTag := range entry.RepoTags {
+				ref, err := ParseReference(repoTag)
+				if err != nil {
+					return images, err
+				}
+				tags = append(tags, ref.String())
+			}
+		}
+		img, err := s.createImage(manifestDigest, s.verifyBlob, tags, opts.Extract, opts.ExtractID)
+		if err != nil {
+			return images, err
+		}
+		images = append(images, img)
+	}
+	return images, nil
+}
+
+// ingestFile copies the file with the given name to the store and returns its descriptor. The files
+// in the blobs directory of the archives written by recent Docker versions are verified against their name.
+func (src *source) ingestFile(name, mediaType string) (Descriptor, error) {
+	name = cleanName(name)
+	expected := ""
+	if hex, found := strings.CutPrefix(name, "blobs/sha256/"); found {
+		expected = sha256Prefix + hex
+	}
+	digest, size, err := src.ingest(name, expected, 0)
+	return Descriptor{MediaType: mediaType, Digest: digest, Size: size}, err
+}
+
+// verifyBlob checks the blob the descriptor points to has already been stored
+func (s *Store) verifyBlob(desc Descriptor) error {
+	if !s.HasBlob(desc.Digest) {
+		return fmt.Errorf("%w: %s", ErrBlobNotFound, desc.Digest)
+	}
+	return nil
+}
@@ -0,0 +1,629 @@
+// Package image stores container images: their blobs (manifests, configurations and compressed layers),
+// addressed by digest, and the root filesystems unpacked from their layers
+package image
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// DefaultStorePath holds the images loaded by root
+	DefaultStorePath = "/var/lib/coso/images"
+
+	blobsDir  = "blobs/sha256"
+	rootfsDir = "rootfs"
+	indexFile = "images.json"
+	lockFile  = ".lock"
+	// stagingPrefix prefixes the directories holding the content of the loads and pulls in progress
+	stagingPrefix = ".staging-"
+	shortIDLen    = 12
+)
+
+var (
+	// ErrNotFound is returned when no image matches a reference
+	ErrNotFound = errors.New("no such image")
+	// ErrBlobNotFound is returned when a blob isn't in the store
+	ErrBlobNotFound = errors.New("no such blob")
+
+	idPrefix = regexp.MustCompile(`^[a-f0-9]{4,64}$`)
+)
+
+// Image holds the metadata of an image stored locally
+type Image struct {
+	// ID is the digest of the image configuration
+	ID string `json:"id"`
+	// ManifestDigest is the digest of the image manifest
+	ManifestDigest string `json:"manifestDigest"`
+	// Tags are the normalized references pointing to the image
+	Tags []string `json:"tags,omitempty"`
+	// Layers are the descriptors of the layer blobs, lowest first
+	Layers []Descriptor `json:"layers"`
+	// ChainID identifies the root filesystem unpacked from the layers
+	ChainID string `json:"chainId"`
+	// Rootfs names the directory of the root filesystem, unpacked from the layers with the extract
+	// options identified by the load or pull: the chain ID when empty
+	Rootfs   string    `json:"rootfs,omitempty"`
+	Platform Platform  `json:"platform"`
+	Created  time.Time `json:"created,omitempty"`
+	// Size is the size of the layer blobs
+	Size     int64     `json:"size"`
+	LoadedAt time.Time `json:"loadedAt"`
+}
+
+// ShortID returns the abbreviated form of the image ID, as shown by Docker
+func (img *Image) ShortID() string {
+	id := digestHex(img.ID)
+	if len(id) > shortIDLen {
+		return id[:shortIDLen]
+	}
+	return id
+}
+
+// index is the list of the images in the store
+type index struct {
+	Images []*Image `json:"images"`
+}
+
+// Store keeps the blobs under blobs/sha256/<hex>, the unpacked root filesystems under rootfs/<name>
+// and the metadata of the images in images.json.
+//
+// Loads and pulls write the content they add to a staging directory, which is moved into the store
+// when the image is registered, so that removing other images in the meantime doesn't delete it.
+type Store struct {
+	path string
+	// staging is the staging directory of the load or pull in progress, if any
+	staging *os.File
+}
+
+// NewStore returns a store keeping the images under path
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+// UserStorePath returns the store path of the calling user: DefaultStorePath for root,
+// a directory under $XDG_DATA_HOME, or ~/.local/share, otherwise
+func UserStorePath() (string, error) {
+	if os.Geteuid() == 0 {
+		return DefaultStorePath, nil
+	}
+	if dataHome := os.Getenv("XDG_DATA_HOME"); dataHome != "" {
+		return filepath.Join(dataHome, "coso", "images"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share", "coso", "images"), nil
+}
+
+// Get returns the image matching ref: an image reference, or a full or abbreviated image ID
+func (s *Store) Get(ref string) (*Image, error) {
+	var img *Image
+	err := s.locked(func() error {
+		idx, err := s.loadIndex()
+		if err != nil {
+			return err
+		}
+		img, err = idx.find(ref)
+		return err
+	})
+	return img, err
+}
+
+// List returns all the images, most recently loaded first
+func (s *Store) List() ([]*Image, error) {
+	var images []*Image
+	err := s.locked(func() error {
+		idx, err := s.loadIndex()
+		images = idx.Images
+		return err
+	})
+
+	sort.SliceStable(images, func(i, j int) bool { return images[i].LoadedAt.After(images[j].LoadedAt) })
+	return images, err
+}
+
+// Remove untags the image when ref is one of its tags and the image has others, otherwise it deletes
+// the image, together with the blobs and the root filesystem no other image uses. Images whose root
+// filesystem is used by a running container can't be deleted. It reports whether the image was deleted.
+func (s *Store) Remove(ref string) (bool, error) {
+	deleted := false
+	err := s.locked(func() error {
+		idx, err := s.loadIndex()
+		if err != nil {
+			return err
+		}
+		img, err := idx.find(ref)
+		if err != nil {
+			return err
+		}
+
+		if normalized, err := ParseReference(ref); err == nil && len(img.Tags) > 1 && removeString(&img.Tags, normalized.String()) {
+			return s.saveIndex(idx)
+		}
+
+		images := idx.Images[:0]
+		for _, other := range idx.Images {
+			if other != img {
+				images = append(images, other)
+			}
+		}
+		idx.Images = images
+		if !idx.rootfs()[img.rootfsName()] && rootfsInUse(s.RootfsPath(img)) {
+			return fmt.Errorf("image %s is used by a running container", ref)
+		}
+		if err := s.saveIndex(idx); err != nil {
+			return err
+		}
+		deleted = true
+		return s.collectGarbage(idx)
+	})
+	return deleted, err
+}
+
+// Config returns the configuration of the image
+func (s *Store) Config(img *Image) (*Config, error) {
+	var config Config
+	if err := s.readJSON(img.ID, &config); err != nil {
+		return nil, fmt.Errorf("reading the configuration of image %s: %w", img.ShortID(), err)
+	}
+	return &config, nil
+}
+
+// Manifest returns the manifest of the image
+func (s *Store) Manifest(img *Image) (*Manifest, error) {
+	var manifest Manifest
+	if err := s.readJSON(img.ManifestDigest, &manifest); err != nil {
+		return nil, fmt.Errorf("reading the manifest of image %s: %w", img.ShortID(), err)
+	}
+	return &manifest, nil
+}
+
+// RootfsPath returns the path of the root filesystem unpacked from the image layers
+func (s *Store) RootfsPath(img *Image) string {
+	return filepath.Join(s.path, rootfsDir, img.rootfsName())
+}
+
+// rootfsName returns the name of the directory of the image root filesystem
+func (img *Image) rootfsName() string {
+	if img.Rootfs != "" {
+		return img.Rootfs
+	}
+	return digestHex(img.ChainID)
+}
+
+// rootfsName returns the name of the directory of the root filesystem with the given chain ID, unpacked
+// with the extract options identified by extractID
+func rootfsName(chainID, extractID string) string {
+	if extractID == "" {
+		return digestHex(chainID)
+	}
+	return digestHex(Digest([]byte(chainID + " " + extractID)))
+}
+
+// LockRootfs marks the image root filesystem at path as used by a container until the returned file
+// is closed, or the calling process exits, so that the image can't be removed in the meantime
+func LockRootfs(path string) (*os.File, error) {
+	lock, err := lockDir(path, syscall.LOCK_SH)
+	if err == syscall.EWOULDBLOCK {
+		return nil, errors.New("the image is being removed")
+	}
+	return lock, err
+}
+
+// lockDir locks the directory at path without blocking. Root filesystems are locked shared by the
+// containers using them, staging directories exclusively by their load or pull, and both exclusively
+// while deleted.
+func lockDir(path string, how int) (*os.File, error) {
+	lock, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(lock.Fd()), how|syscall.LOCK_NB); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	return lock, nil
+}
+
+// rootfsInUse reports whether a container holds a lock on the root filesystem at path
+func rootfsInUse(path string) bool {
+	lock, err := lockDir(path, syscall.LOCK_EX)
+	if err != nil {
+		return err == syscall.EWOULDBLOCK
+	}
+	lock.Close()
+	return false
+}
+
+// stage returns a view of the store writing new blobs and root filesystems to a staging directory,
+// which is locked until discarded, to be moved into the store by register
+func (s *Store) stage() (*Store, error) {
+	if err := os.MkdirAll(s.path, 0755); err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp(s.path, stagingPrefix)
+	if err != nil {
+		return nil, err
+	}
+	staging, err := lockDir(dir, syscall.LOCK_EX)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return &Store{path: s.path, staging: staging}, nil
+}
+
+// discard deletes the staging directory, together with the content which wasn't registered
+func (s *Store) discard() error {
+	defer s.staging.Close()
+	return os.RemoveAll(s.staging.Name())
+}
+
+// root returns the directory new content is written to
+func (s *Store) root() string {
+	if s.staging != nil {
+		return s.staging.Name()
+	}
+	return s.path
+}
+
+// OpenBlob opens the blob with the given digest
+func (s *Store) OpenBlob(digest string) (*os.File, error) {
+	path, err := s.findBlob(digest)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrBlobNotFound, digest)
+	}
+	return f, err
+}
+
+// HasBlob reports whether the blob with the given digest is in the store
+func (s *Store) HasBlob(digest string) bool {
+	path, err := s.findBlob(digest)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path)
+	return err == nil
+}
+
+// PutBlob stores the content read from r, verifying it matches the expected digest and size, unless
+// the digest is empty, and returns its digest and size. The blob only appears in the store once complete.
+func (s *Store) PutBlob(r io.Reader, digest string, size int64) (string, int64, error) {
+	if digest != "" {
+		if err := ValidateDigest(digest); err != nil {
+			return "", 0, err
+		}
+	}
+
+	dir := filepath.Join(s.root(), blobsDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", 0, err
+	}
+	tmp, err := os.CreateTemp(dir, ".tmp-")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	d := newDigester()
+	_, err = io.Copy(io.MultiWriter(tmp, d), r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	if digest != "" {
+		if err := d.verify(digest, size); err != nil {
+			return "", 0, err
+		}
+	}
+
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return "", 0, err
+	}
+	return d.Digest(), d.size, os.Rename(tmp.Name(), filepath.Join(dir, digestHex(d.Digest())))
+}
+
+func (s *Store) readJSON(digest string, v interface{}) error {
+	f, err := s.OpenBlob(digest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewDecoder(f).Decode(v)
+}
+
+func (s *Store) blobPath(digest string) (string, error) {
+	if err := ValidateDigest(digest); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.path, blobsDir, digestHex(digest)), nil
+}
+
+// findBlob returns the path of the blob with the given digest, looking into the staging directory first
+func (s *Store) findBlob(digest string) (string, error) {
+	path, err := s.blobPath(digest)
+	if err != nil || s.staging == nil {
+		return path, err
+	}
+	staged := filepath.Join(s.staging.Name(), blobsDir, digestHex(digest))
+	if _, err := os.Stat(staged); err == nil {
+		return staged, nil
+	}
+	return path, nil
+}
+
+// register adds the image to the store, moving the given tags to it, or updates the tags and the root
+// filesystem of an existing one. The staged content of the image is moved into the store.
+func (s *Store) register(img *Image, tags []string) (*Image, error) {
+	err := s.locked(func() error {
+		idx, err := s.loadIndex()
+		if err != nil {
+			return err
+		}
+		if err := s.commit(img); err != nil {
+			return err
+		}
+
+		for _, other := range idx.Images {
+			for _, tag := range tags {
+				removeString(&other.Tags, tag)
+			}
+		}
+		existing := img
+		for _, other := range idx.Images {
+			if other.ID == img.ID {
+				existing = other
+			}
+		}
+		replaced := ""
+		if existing == img {
+			idx.Images = append(idx.Images, img)
+		} else if existing.rootfsName() != img.rootfsName() {
+			replaced = existing.rootfsName()
+			existing.Rootfs = img.rootfsName()
+		}
+		for _, tag := range tags {
+			existing.Tags = append(existing.Tags, tag)
+		}
+		sort.Strings(existing.Tags)
+		img = existing
+		if err := s.saveIndex(idx); err != nil {
+			return err
+		}
+		if replaced != "" {
+			return s.collectGarbage(idx)
+		}
+		return nil
+	})
+	return img, err
+}
+
+// commit moves the staged blobs and root filesystem of the image into the store, checking the ones
+// found in the store when staging are still there
+func (s *Store) commit(img *Image) error {
+	for digest := range imageBlobs(img) {
+		path, err := s.blobPath(digest)
+		if err != nil {
+			return err
+		}
+		if err := s.commitPath(filepath.Join(blobsDir, digestHex(digest)), path); err != nil {
+			return fmt.Errorf("blob %s: %w", digest, err)
+		}
+	}
+	if err := s.commitPath(filepath.Join(rootfsDir, img.rootfsName()), s.RootfsPath(img)); err != nil {
+		return fmt.Errorf("root filesystem of image %s: %w", img.ShortID(), err)
+	}
+	return nil
+}
+
+// commitPath moves the staged file with the given relative name to path, unless already there
+func (s *Store) commitPath(name, path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if s.staging == nil {
+		return os.ErrNotExist
+	}
+	staged := filepath.Join(s.staging.Name(), name)
+	if _, err := os.Stat(staged); err != nil {
+		// the content was found in the store, and deleted with another image in the meantime
+		return fmt.Errorf("removed while being loaded: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.Rename(staged, path)
+}
+
+// collectGarbage deletes the blobs and the root filesystems the images in the index don't use, except the
+// root filesystems used by running containers, and the staging directories of interrupted loads and pulls
+func (s *Store) collectGarbage(idx *index) error {
+	usedBlobs, usedRootfs := idx.blobs(), idx.rootfs()
+
+	for _, dir := range []string{blobsDir, rootfsDir} {
+		entries, err := os.ReadDir(filepath.Join(s.path, dir))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			// temporary files belong to downloads and unpacks in progress
+			if usedBlobs[sha256Prefix+entry.Name()] || usedRootfs[entry.Name()] || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			if err := s.removeUnlocked(filepath.Join(s.path, dir, entry.Name()), dir == rootfsDir); err != nil {
+				return err
+			}
+		}
+	}
+
+	entries, err := os.ReadDir(s.path)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), stagingPrefix) {
+			if err := s.removeUnlocked(filepath.Join(s.path, entry.Name()), true); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// removeUnlocked deletes the file at path. Directories which may be locked, root filesystems and staging
+// directories, are only deleted when no other process holds a lock on them.
+func (s *Store) removeUnlocked(path string, lockable bool) error {
+	if !lockable {
+		return os.RemoveAll(path)
+	}
+	lock, err := lockDir(path, syscall.LOCK_EX)
+	if err == syscall.EWOULDBLOCK {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	return os.RemoveAll(path)
+}
+
+// blobs returns the digests of the blobs used by the images
+func (idx *index) blobs() map[string]bool {
+	used := map[string]bool{}
+	for _, img := range idx.Images {
+		for digest := range imageBlobs(img) {
+			used[digest] = true
+		}
+	}
+	return used
+}
+
+// rootfs returns the names of the root filesystems used by the images
+func (idx *index) rootfs() map[string]bool {
+	used := map[string]bool{}
+	for _, img := range idx.Images {
+		used[img.rootfsName()] = true
+	}
+	return used
+}
+
+// imageBlobs returns the digests of the blobs of the image
+func imageBlobs(img *Image) map[string]bool {
+	blobs := map[string]bool{img.ID: true, img.ManifestDigest: true}
+	for _, layer := range img.Layers {
+		blobs[layer.Digest] = true
+	}
+	return blobs
+}
+
+// find returns the image with the given tag, manifest digest, ID or ID prefix, in this order as in Docker
+func (idx *index) find(ref string) (*Image, error) {
+	if normalized, err := ParseReference(ref); err == nil {
+		for _, img := range idx.Images {
+			if normalized.Digest != "" && img.ManifestDigest == normalized.Digest {
+				return img, nil
+			}
+			for _, tag := range img.Tags {
+				if normalized.Digest == "" && tag == normalized.String() {
+					return img, nil
+				}
+			}
+		}
+	}
+
+	if id := strings.TrimPrefix(ref, sha256Prefix); idPrefix.MatchString(id) {
+		var matches []*Image
+		for _, img := range idx.Images {
+			if strings.HasPrefix(digestHex(img.ID), id) {
+				matches = append(matches, img)
+			}
+		}
+		if len(matches) > 1 {
+			return nil, fmt.Errorf("the image ID prefix '%s' is ambiguous", id)
+		}
+		if len(matches) == 1 {
+			return matches[0], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNotFound, ref)
+}
+
+func (s *Store) loadIndex() (*index, error) {
+	var idx index
+	content, err := os.ReadFile(filepath.Join(s.path, indexFile))
+	if os.IsNotExist(err) {
+		return &idx, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &idx); err != nil {
+		return nil, fmt.Errorf("invalid image index: %w", err)
+	}
+	return &idx, nil
+}
+
+// saveIndex writes the index atomically
+func (s *Store) saveIndex(idx *index) error {
+	content, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(s.path, indexFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// locked runs fn holding an exclusive lock on the store, serializing concurrent coso processes
+func (s *Store) locked(fn func() error) error {
+	if err := os.MkdirAll(s.path, 0755); err != nil {
+		return err
+	}
+	lock, err := os.OpenFile(filepath.Join(s.path, lockFile), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	return fn()
+}
+
+// removeString removes value from the slice, reporting whether it was found
+func removeString(values *[]string, value string) bool {
+	for i, v := range *values {
+		if v == value {
+			*values = append((*values)[:i], (*values)[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,424 @@
+package image
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/NamelessOne91/coso/archive"
+	"github.com/NamelessOne91/coso/filesystem"
+)
+
+const (
+	ociLayoutFile    = "oci-layout"
+	ociIndexFile     = "index.json"
+	ociLayoutVersion = "1.0.0"
+
+	// maxInlineSize is the size above which the files of an archive are moved to the blob store
+	// as soon as they are read, instead of being kept in memory
+	maxInlineSize = 1 << 20
+	// maxLinks bounds the chains of links between the files of an archive
+	maxLinks = 16
+)
+
+// LoadOptions controls how images are loaded
+type LoadOptions struct {
+	// Tags replace the references found in the archive
+	Tags []string
+	// Platform selects the manifest of multi platform images, the host platform when empty
+	Platform Platform
+	// Extract controls how the layers are extracted
+	Extract archive.Options
+	// ExtractID identifies the Extract options, e.g. by the ID mappings the owners are mapped with:
+	// the layers extracted with different options are unpacked into different root filesystems
+	ExtractID string
+}
+
+// Load loads the images found at path: an OCI image layout directory, a tar archive of one or an archive
+// written by docker save, optionally compressed. The blobs are verified against their digests while
+// they are copied into the store.
+func (s *Store) Load(path string, opts LoadOptions) ([]*Image, error) {
+	var tags []string
+	for _, tag := range opts.Tags {
+		ref, err := ParseReference(tag)
+		if err != nil {
+			return nil, err
+		}
+		if ref.Digest != "" {
+			return nil, fmt.Errorf("images can't be tagged with a digest: '%s'", tag)
+		}
+		tags = append(tags, ref.String())
+	}
+	opts.Tags = tags
+	if opts.Platform.OS == "" {
+		opts.Platform = DefaultPlatform()
+	}
+
+	// the files of archives which no image uses are discarded together with the staging directory
+	staged, err := s.stage()
+	if err != nil {
+		return nil, err
+	}
+	defer staged.discard()
+
+	src, err := staged.openSource(path)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case src.exists(dockerManifestFile):
+		return staged.loadDocker(src, opts)
+	case src.exists(ociIndexFile):
+		return staged.loadOCI(src, opts)
+	default:
+		return nil, fmt.Errorf("%s is neither an OCI image layout nor a docker save archive: no %s or %s found", path, ociIndexFile, dockerManifestFile)
+	}
+}
+
+// source gives access to the files of an image layout, either a directory or an archive
+type source struct {
+	store *Store
+	// dir is the image layout directory, empty for archives
+	dir string
+	// files holds the small files of an archive
+	files map[string][]byte
+	// blobs holds the descriptors of the files of an archive which have been moved to the store
+	blobs map[string]Descriptor
+}
+
+func (s *Store) openSource(p string) (*source, error) {
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	src := &source{store: s}
+	if info.IsDir() {
+		src.dir = p
+		return src, nil
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := archive.Decompress(f)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	if err := src.readArchive(r); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", p, err)
+	}
+	return src, nil
+}
+
+// readArchive reads the files of the archive: the ones in the blobs directory, and the large ones,
+// are moved to the store right away
+func (src *source) readArchive(r io.Reader) error {
+	src.files = map[string][]byte{}
+	src.blobs = map[string]Descriptor{}
+	links := map[string]string{}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		name := cleanName(hdr.Name)
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeRegA:
+			if strings.HasPrefix(name, "blobs/") || hdr.Size > maxInlineSize {
+				digest, size, err := src.store.PutBlob(tr, "", 0)
+				if err != nil {
+					return err
+				}
+				src.blobs[name] = Descriptor{Digest: digest, Size: size}
+				continue
+			}
+			content, err := io.ReadAll(tr)
+			if err != nil {
+				return err
+			}
+			src.files[name] = content
+		case tar.TypeSymlink:
+			links[name] = cleanName(path.Join(path.Dir(name), hdr.Linkname))
+		case tar.TypeLink:
+			links[name] = cleanName(hdr.Linkname)
+		}
+	}
+
+	for name, target := range links {
+		for i := 0; i < maxLinks; i++ {
+			if next, found := links[target]; found {
+				target = next
+			}
+		}
+		if content, found := src.files[target]; found {
+			src.files[name] = content
+		}
+		if desc, found := src.blobs[target]; found {
+			src.blobs[name] = desc
+		}
+	}
+	return nil
+}
+
+// cleanName turns the name of an archive entry into a relative slash separated path
+func cleanName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+func (src *source) exists(name string) bool {
+	if src.dir == "" {
+		_, inline := src.files[name]
+		_, blob := src.blobs[name]
+		return inline || blob
+	}
+	p, err := filesystem.SecureJoin(src.dir, name)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(p)
+	return err == nil
+}
+
+// readFile returns the content of the file with the given name
+func (src *source) readFile(name string) ([]byte, error) {
+	if src.dir != "" {
+		p, err := filesystem.SecureJoin(src.dir, name)
+		if err != nil {
+			return nil, err
+		}
+		return os.ReadFile(p)
+	}
+
+	if content, found := src.files[name]; found {
+		return content, nil
+	}
+	desc, found := src.blobs[name]
+	if !found {
+		return nil, fmt.Errorf("%s: %w", name, os.ErrNotExist)
+	}
+	f, err := src.store.OpenBlob(desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// ingest copies the file with the given name to the store, verifying it matches the expected digest
+// and size, unless the digest is empty, and returns its digest and size
+func (src *source) ingest(name, digest string, size int64) (string, int64, error) {
+	if src.dir != "" {
+		p, err := filesystem.SecureJoin(src.dir, name)
+		if err != nil {
+			return "", 0, err
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return "", 0, err
+		}
+		defer f.Close()
+		return src.store.PutBlob(f, digest, size)
+	}
+
+	if content, found := src.files[name]; found {
+		return src.store.PutBlob(strings.NewReader(string(content)), digest, size)
+	}
+	desc, found := src.blobs[name]
+	if !found {
+		return "", 0, fmt.Errorf("%s: %w", name, os.ErrNotExist)
+	}
+	if digest != "" && desc.Digest != digest {
+		return "", 0, fmt.Errorf("digest mismatch for %s: got %s, expected %s", name, desc.Digest, digest)
+	}
+	if digest != "" && size > 0 && desc.Size != size {
+		return "", 0, fmt.Errorf("size mismatch for %s: got %d bytes, expected %d", digest, desc.Size, size)
+	}
+	return desc.Digest, desc.Size, nil
+}
+
+// ingestDescriptor copies the blob the descriptor points to from an image layout to the store
+func (src *source) ingestDescriptor(desc Descriptor) error {
+	if err := ValidateDigest(desc.Digest); err != nil {
+		return err
+	}
+	_, _, err := src.ingest("blobs/sha256/"+digestHex(desc.Digest), desc.Digest, desc.Size)
+	return err
+}
+
+// loadOCI loads the images listed in the index of an OCI image layout
+func (s *Store) loadOCI(src *source, opts LoadOptions) ([]*Image, error) {
+	if content, err := src.readFile(ociLayoutFile); err == nil {
+		var layout struct {
+			Version string `json:"imageLayoutVersion"`
+		}
+		if err := json.Unmarshal(content, &layout); err != nil || layout.Version != ociLayoutVersion {
+			return nil, fmt.Errorf("unsupported OCI image layout version in %s", ociLayoutFile)
+		}
+	}
+
+	var idx Index
+	content, err := src.readFile(ociIndexFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &idx); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", ociIndexFile, err)
+	}
+
+	var images []*Image
+	for _, desc := range idx.Manifests {
+		manifest, err := src.selectManifest(desc, opts.Platform)
+		if err != nil {
+			return images, err
+		}
+		if err := src.ingestDescriptor(manifest); err != nil {
+			return images, err
+		}
+
+		tags := opts.Tags
+		if len(tags) == 0 {
+			tags = annotatedTags(desc.Annotations)
+		}
+		img, err := s.createImage(manifest.Digest, func(d Descriptor) error { return src.ingestDescriptor(d) }, tags, opts.Extract, opts.ExtractID)
+		if err != nil {
+			return images, err
+		}
+		images = append(images, img)
+	}
+	return images, nil
+}
+
+// selectManifest returns the descriptor of the image manifest the descriptor points to: multi platform
+// images are resolved to the manifest of the given platform
+func (src *source) selectManifest(desc Descriptor, platform Platform) (Descriptor, error) {
+	for depth := 0; isIndex(desc.MediaType); depth++ {
+		if depth == maxLinks {
+			return Descriptor{}, errors.New("too many nested image indexes")
+		}
+		if err := src.ingestDescriptor(desc); err != nil {
+			return Descriptor{}, err
+		}
+		var idx Index
+		if err := src.store.readJSON(desc.Digest, &idx); err != nil {
+			return Descriptor{}, err
+		}
+		selected, err := SelectPlatform(idx.Manifests, platform)
+		if err != nil {
+			return Descriptor{}, fmt.Errorf("image index %s: %w", desc.Digest, err)
+		}
+		desc = selected
+	}
+
+	if desc.MediaType != "" && !isManifest(desc.MediaType) {
+		return Descriptor{}, fmt.Errorf("unsupported manifest media type '%s'", desc.MediaType)
+	}
+	return desc, nil
+}
+
+// SelectPlatform returns the first manifest of a multi platform image which runs on the given platform
+func SelectPlatform(manifests []Descriptor, platform Platform) (Descriptor, error) {
+	for _, m := range manifests {
+		if m.Platform != nil && platform.Matches(*m.Platform) {
+			return m, nil
+		}
+	}
+	return Descriptor{}, fmt.Errorf("no manifest for platform %s", platform)
+}
+
+// annotatedTags returns the reference an image layout index annotates a manifest with, ignoring
+// the references holding just a tag, since they don't name a repository
+func annotatedTags(annotations map[string]string) []string {
+	for _, key := range []string{annotationImageName, AnnotationRefName} {
+		name := annotations[key]
+		if !strings.ContainsAny(name, ":/") {
+			continue
+		}
+		if ref, err := ParseReference(name); err == nil && ref.Digest == "" {
+			return []string{ref.String()}
+		}
+	}
+	return nil
+}
+
+// createImage adds the image with the given manifest, which must be in the store, tagged with tags.
+// The configuration and the layers are fetched with fetch, which stores and verifies the blob
+// a descriptor points to, and the layers are unpacked into the root filesystem of the image.
+func (s *Store) createImage(manifestDigest string, fetch func(Descriptor) error, tags []string, opts archive.Options, extractID string) (*Image, error) {
+	var manifest Manifest
+	if err := s.readJSON(manifestDigest, &manifest); err != nil {
+		return nil, fmt.Errorf("reading manifest %s: %w", manifestDigest, err)
+	}
+	if err := fetch(manifest.Config); err != nil {
+		return nil, fmt.Errorf("fetching the configuration %s: %w", manifest.Config.Digest, err)
+	}
+	var config Config
+	if err := s.readJSON(manifest.Config.Digest, &config); err != nil {
+		return nil, fmt.Errorf("reading the configuration %s: %w", manifest.Config.Digest, err)
+	}
+	if len(config.RootFS.DiffIDs) != len(manifest.Layers) {
+		return nil, fmt.Errorf("manifest %s lists %d layers, while its configuration lists %d", manifestDigest, len(manifest.Layers), len(config.RootFS.DiffIDs))
+	}
+
+	img := &Image{
+		ID:             manifest.Config.Digest,
+		ManifestDigest: manifestDigest,
+		Layers:         manifest.Layers,
+		ChainID:        ChainID(config.RootFS.DiffIDs),
+		Platform:       Platform{Architecture: config.Architecture, OS: config.OS, Variant: config.Variant},
+		LoadedAt:       time.Now().UTC(),
+	}
+	if config.Created != nil {
+		img.Created = config.Created.UTC()
+	}
+	for _, layer := range manifest.Layers {
+		if err := validateLayer(layer); err != nil {
+			return nil, err
+		}
+		if err := fetch(layer); err != nil {
+			return nil, fmt.Errorf("fetching layer %s: %w", layer.Digest, err)
+		}
+		img.Size += layer.Size
+	}
+	for _, diffID := range config.RootFS.DiffIDs {
+		if err := ValidateDigest(diffID); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(img.Layers) == 0 {
+		img.ChainID = Digest(nil)
+	}
+	img.Rootfs = rootfsName(img.ChainID, extractID)
+	if err := s.unpack(img, config.RootFS.DiffIDs, opts); err != nil {
+		return nil, err
+	}
+	return s.register(img, tags)
+}
+
+// validateLayer checks the layer is a tar archive, compressed with a supported algorithm
+func validateLayer(layer Descriptor) error {
+	switch layer.MediaType {
+	case MediaTypeImageLayer, MediaTypeImageLayerGzip, MediaTypeImageLayerZstd, MediaTypeDockerLayer, MediaTypeDockerLayerGzip, "":
+		return ValidateDigest(layer.Digest)
+	case MediaTypeDockerForeignGzip:
+		return fmt.Errorf("foreign layer %s can't be fetched offline", layer.Digest)
+	default:
+		return fmt.Errorf("unsupported layer media type '%s'", layer.MediaType)
+	}
+}
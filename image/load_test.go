@@ -0,0 +1,386 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/NamelessOne91/coso/archive"
+)
+
+// entry is a file of a test layer, a whiteout when its content is empty
+type entry struct {
+	name    string
+	content string
+}
+
+// buildLayer returns a gzip compressed tar archive holding the entries, and the digest of the uncompressed one
+func buildLayer(entries ...entry) ([]byte, string) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(e.content)), Uid: os.Getuid(), Gid: os.Getgid()}
+		if e.name[len(e.name)-1] == '/' {
+			hdr.Typeflag, hdr.Mode = tar.TypeDir, 0755
+		}
+		Expect(tw.WriteHeader(hdr)).To(Succeed())
+		_, err := tw.Write([]byte(e.content))
+		Expect(err).NotTo(HaveOccurred())
+	}
+	Expect(tw.Close()).To(Succeed())
+	diffID := Digest(buf.Bytes())
+
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	_, err := zw.Write(buf.Bytes())
+	Expect(err).NotTo(HaveOccurred())
+	Expect(zw.Close()).To(Succeed())
+	return compressed.Bytes(), diffID
+}
+
+// writeBlob writes content to the blobs directory of the layout and returns its descriptor
+func writeBlob(layout, mediaType string, content []byte) Descriptor {
+	digest := Digest(content)
+	dir := filepath.Join(layout, "blobs", "sha256")
+	Expect(os.MkdirAll(dir, 0755)).To(Succeed())
+	Expect(os.WriteFile(filepath.Join(dir, digestHex(digest)), content, 0644)).To(Succeed())
+	return Descriptor{MediaType: mediaType, Digest: digest, Size: int64(len(content))}
+}
+
+func writeJSONBlob(layout, mediaType string, v interface{}) Descriptor {
+	content, err := json.Marshal(v)
+	Expect(err).NotTo(HaveOccurred())
+	return writeBlob(layout, mediaType, content)
+}
+
+// writeImage writes an image made of the given layers to the layout and returns the descriptor of its manifest
+func writeImage(layout string, platform Platform, cmd string, layers ...[]entry) Descriptor {
+	config := Config{Architecture: platform.Architecture, OS: platform.OS, Config: ContainerConfig{Cmd: []string{cmd}}}
+	manifest := Manifest{SchemaVersion: 2, MediaType: MediaTypeImageManifest}
+	for _, entries := range layers {
+		content, diffID := buildLayer(entries...)
+		manifest.Layers = append(manifest.Layers, writeBlob(layout, MediaTypeImageLayerGzip, content))
+		config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, diffID)
+	}
+	config.RootFS.Type = "layers"
+	manifest.Config = writeJSONBlob(layout, MediaTypeImageConfig, config)
+	return writeJSONBlob(layout, MediaTypeImageManifest, manifest)
+}
+
+// writeIndex writes the index.json and oci-layout files of the layout
+func writeIndex(layout string, manifests ...Descriptor) {
+	content, err := json.Marshal(Index{SchemaVersion: 2, MediaType: MediaTypeImageIndex, Manifests: manifests})
+	Expect(err).NotTo(HaveOccurred())
+	Expect(os.WriteFile(filepath.Join(layout, "index.json"), content, 0644)).To(Succeed())
+	Expect(os.WriteFile(filepath.Join(layout, "oci-layout"), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0644)).To(Succeed())
+}
+
+// tarDirectory writes an archive of the directory content to path
+func tarDirectory(dir, path string) {
+	f, err := os.Create(path)
+	Expect(err).NotTo(HaveOccurred())
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+	Expect(filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || p == dir {
+			return err
+		}
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name, _ = filepath.Rel(dir, p)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			content, err := os.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			_, err = tw.Write(content)
+			return err
+		}
+		return nil
+	})).To(Succeed())
+	Expect(tw.Close()).To(Succeed())
+}
+
+var _ = Describe("Store", func() {
+
+	var (
+		dir    string
+		layout string
+		store  *Store
+		opts   LoadOptions
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "coso-image")
+		Expect(err).NotTo(HaveOccurred())
+		layout = filepath.Join(dir, "layout")
+		Expect(os.MkdirAll(layout, 0755)).To(Succeed())
+		store = NewStore(filepath.Join(dir, "store"))
+		opts = LoadOptions{Extract: archive.Options{Rootless: os.Geteuid() != 0}}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	tagged := func(desc Descriptor, ref string) Descriptor {
+		desc.Annotations = map[string]string{AnnotationRefName: ref}
+		return desc
+	}
+
+	It("loads an image layout, applying the whiteouts of each layer", func() {
+		manifest := writeImage(layout, DefaultPlatform(), "/bin/sh",
+			[]entry{{"etc/", ""}, {"etc/hostname", "base"}, {"etc/motd", "welcome"}, {"var/", ""}, {"var/cache/", ""}, {"var/cache/apk", "index"}},
+			[]entry{{"etc/.wh.motd", ""}, {"var/cache/", ""}, {"var/cache/.wh..wh..opq", ""}, {"var/cache/new", "fresh"}},
+		)
+		writeIndex(layout, tagged(manifest, "alpine:3.18"))
+
+		images, err := store.Load(layout, opts)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(images).To(HaveLen(1))
+		Expect(images[0].Tags).To(Equal([]string{"docker.io/library/alpine:3.18"}))
+
+		img, err := store.Get("alpine:3.18")
+		Expect(err).NotTo(HaveOccurred())
+		rootfs := store.RootfsPath(img)
+		Expect(filepath.Join(rootfs, "etc", "hostname")).To(BeARegularFile())
+		Expect(filepath.Join(rootfs, "etc", "motd")).NotTo(BeAnExistingFile())
+		Expect(filepath.Join(rootfs, "etc", ".wh.motd")).NotTo(BeAnExistingFile())
+		Expect(filepath.Join(rootfs, "var", "cache", "apk")).NotTo(BeAnExistingFile())
+		Expect(filepath.Join(rootfs, "var", "cache", "new")).To(BeARegularFile())
+
+		config, err := store.Config(img)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config.Config.Cmd).To(Equal([]string{"/bin/sh"}))
+
+		byID, err := store.Get(img.ShortID())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(byID.ID).To(Equal(img.ID))
+	})
+
+	It("loads archives of image layouts", func() {
+		writeIndex(layout, tagged(writeImage(layout, DefaultPlatform(), "/bin/sh", []entry{{"etc/hostname", "base"}}), "app:1.0"))
+		archivePath := filepath.Join(dir, "app.tar")
+		tarDirectory(layout, archivePath)
+
+		images, err := store.Load(archivePath, opts)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(images).To(HaveLen(1))
+		Expect(filepath.Join(store.RootfsPath(images[0]), "etc", "hostname")).To(BeARegularFile())
+	})
+
+	It("loads docker save archives", func() {
+		var diffIDs []string
+		var layerNames []string
+		for i, entries := range [][]entry{{{"etc/", ""}, {"etc/motd", "welcome"}}, {{"etc/.wh.motd", ""}, {"etc/hostname", "app"}}} {
+			var buf bytes.Buffer
+			tw := tar.NewWriter(&buf)
+			for _, e := range entries {
+				hdr := &tar.Header{Name: e.name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(e.content))}
+				if e.name == "etc/" {
+					hdr.Typeflag, hdr.Mode = tar.TypeDir, 0755
+				}
+				Expect(tw.WriteHeader(hdr)).To(Succeed())
+				_, err := tw.Write([]byte(e.content))
+				Expect(err).NotTo(HaveOccurred())
+			}
+			Expect(tw.Close()).To(Succeed())
+
+			name := filepath.Join(fmt.Sprintf("layer%d", i), "layer.tar")
+			Expect(os.MkdirAll(filepath.Join(layout, filepath.Dir(name)), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(layout, name), buf.Bytes(), 0644)).To(Succeed())
+			layerNames = append(layerNames, name)
+			diffIDs = append(diffIDs, Digest(buf.Bytes()))
+		}
+		// docker save links the layers shared with another image
+		Expect(os.MkdirAll(filepath.Join(layout, "shared"), 0755)).To(Succeed())
+		Expect(os.Symlink("../layer0/layer.tar", filepath.Join(layout, "shared", "layer.tar"))).To(Succeed())
+
+		config, err := json.Marshal(Config{Architecture: "amd64", OS: "linux", Config: ContainerConfig{Cmd: []string{"/app"}}, RootFS: RootFS{Type: "layers", DiffIDs: diffIDs}})
+		Expect(err).NotTo(HaveOccurred())
+		configName := digestHex(Digest(config)) + ".json"
+		Expect(os.WriteFile(filepath.Join(layout, configName), config, 0644)).To(Succeed())
+		manifest, err := json.Marshal([]dockerArchiveImage{
+			{Config: configName, RepoTags: []string{"app:latest", "registry.example.com/team/app:1.0"}, Layers: []string{"shared/layer.tar", layerNames[1]}},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.WriteFile(filepath.Join(layout, "manifest.json"), manifest, 0644)).To(Succeed())
+		archivePath := filepath.Join(dir, "app.tar")
+		tarDirectory(layout, archivePath)
+
+		images, err := store.Load(archivePath, opts)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(images).To(HaveLen(1))
+		Expect(images[0].ID).To(Equal(Digest(config)))
+		Expect(images[0].Tags).To(Equal([]string{"docker.io/library/app:latest", "registry.example.com/team/app:1.0"}))
+
+		img, err := store.Get("app")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(filepath.Join(store.RootfsPath(img), "etc", "hostname")).To(BeARegularFile())
+		Expect(filepath.Join(store.RootfsPath(img), "etc", "motd")).NotTo(BeAnExistingFile())
+
+		m, err := store.Manifest(img)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(m.MediaType).To(Equal(MediaTypeDockerManifest))
+		Expect(m.Layers[0].Digest).To(Equal(diffIDs[0]))
+	})
+
+	It("rejects layers not matching their digest", func() {
+		manifest := writeImage(layout, DefaultPlatform(), "/bin/sh", []entry{{"etc/hostname", "base"}})
+		writeIndex(layout, manifest)
+
+		var m Manifest
+		content, err := os.ReadFile(filepath.Join(layout, "blobs", "sha256", digestHex(manifest.Digest)))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(json.Unmarshal(content, &m)).To(Succeed())
+		tampered, _ := buildLayer(entry{"etc/hostname", "evil"})
+		Expect(os.WriteFile(filepath.Join(layout, "blobs", "sha256", digestHex(m.Layers[0].Digest)), tampered, 0644)).To(Succeed())
+
+		_, err = store.Load(layout, opts)
+		Expect(err).To(MatchError(ContainSubstring("mismatch")))
+
+		images, err := store.List()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(images).To(BeEmpty())
+	})
+
+	It("selects the manifest of the requested platform", func() {
+		arm := writeImage(layout, Platform{OS: "linux", Architecture: "arm64"}, "arm", []entry{{"arch", "arm64"}})
+		arm.Platform = &Platform{OS: "linux", Architecture: "arm64"}
+		amd := writeImage(layout, Platform{OS: "linux", Architecture: "amd64"}, "amd", []entry{{"arch", "amd64"}})
+		amd.Platform = &Platform{OS: "linux", Architecture: "amd64"}
+		list := writeJSONBlob(layout, MediaTypeImageIndex, Index{SchemaVersion: 2, MediaType: MediaTypeImageIndex, Manifests: []Descriptor{amd, arm}})
+		writeIndex(layout, tagged(list, "app:multi"))
+
+		opts.Platform = Platform{OS: "linux", Architecture: "arm64"}
+		images, err := store.Load(layout, opts)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(images).To(HaveLen(1))
+		Expect(images[0].Platform.Architecture).To(Equal("arm64"))
+		content, err := os.ReadFile(filepath.Join(store.RootfsPath(images[0]), "arch"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).To(Equal("arm64"))
+
+		opts.Platform = Platform{OS: "linux", Architecture: "s390x"}
+		_, err = store.Load(layout, opts)
+		Expect(err).To(MatchError(ContainSubstring("no manifest for platform linux/s390x")))
+	})
+
+	It("moves tags and deletes the blobs no image uses anymore", func() {
+		first := writeImage(layout, DefaultPlatform(), "first", []entry{{"first", "1"}})
+		second := writeImage(layout, DefaultPlatform(), "second", []entry{{"second", "2"}})
+		writeIndex(layout, tagged(first, "app:latest"), tagged(second, "app:next"))
+		_, err := store.Load(layout, opts)
+		Expect(err).NotTo(HaveOccurred())
+
+		opts.Tags = []string{"app:next"}
+		writeIndex(layout, first)
+		_, err = store.Load(layout, opts)
+		Expect(err).NotTo(HaveOccurred())
+
+		img, err := store.Get("app:next")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(img.Tags).To(Equal([]string{"docker.io/library/app:latest", "docker.io/library/app:next"}))
+
+		deleted, err := store.Remove("app:next")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(deleted).To(BeFalse())
+
+		deleted, err = store.Remove("app:latest")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(deleted).To(BeTrue())
+		Expect(store.RootfsPath(img)).NotTo(BeADirectory())
+		Expect(store.HasBlob(img.ManifestDigest)).To(BeFalse())
+
+		images, err := store.List()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(images).To(HaveLen(1))
+		Expect(images[0].Tags).To(BeEmpty())
+		Expect(store.HasBlob(images[0].ID)).To(BeTrue())
+	})
+
+	It("refuses to delete images whose root filesystem a container uses", func() {
+		writeIndex(layout, tagged(writeImage(layout, DefaultPlatform(), "/bin/sh", []entry{{"etc/hostname", "base"}}), "app:1.0"))
+		images, err := store.Load(layout, opts)
+		Expect(err).NotTo(HaveOccurred())
+
+		lock, err := LockRootfs(store.RootfsPath(images[0]))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = store.Remove("app:1.0")
+		Expect(err).To(MatchError(ContainSubstring("used by a running container")))
+		Expect(store.RootfsPath(images[0])).To(BeADirectory())
+
+		Expect(lock.Close()).To(Succeed())
+		deleted, err := store.Remove("app:1.0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(deleted).To(BeTrue())
+		Expect(store.RootfsPath(images[0])).NotTo(BeADirectory())
+	})
+
+	It("keeps the content of loads in progress when deleting other images", func() {
+		writeIndex(layout, tagged(writeImage(layout, DefaultPlatform(), "old", []entry{{"old", "1"}}), "old:1.0"))
+		_, err := store.Load(layout, opts)
+		Expect(err).NotTo(HaveOccurred())
+
+		staged, err := store.stage()
+		Expect(err).NotTo(HaveOccurred())
+		defer staged.discard()
+		digest, _, err := staged.PutBlob(bytes.NewReader([]byte("in flight")), "", 0)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = store.Remove("old:1.0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(staged.HasBlob(digest)).To(BeTrue())
+		Expect(store.HasBlob(digest)).To(BeFalse())
+	})
+
+	It("unpacks the layers into a root filesystem for each extract ID", func() {
+		writeIndex(layout, tagged(writeImage(layout, DefaultPlatform(), "/bin/sh", []entry{{"etc/hostname", "base"}}), "app:1.0"))
+		opts.ExtractID = "uid=0:100000:65536"
+		first, err := store.Load(layout, opts)
+		Expect(err).NotTo(HaveOccurred())
+		firstRootfs := store.RootfsPath(first[0])
+
+		opts.ExtractID = "uid=0:200000:65536"
+		second, err := store.Load(layout, opts)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(second[0].ID).To(Equal(first[0].ID))
+		Expect(store.RootfsPath(second[0])).NotTo(Equal(firstRootfs))
+		Expect(filepath.Join(store.RootfsPath(second[0]), "etc", "hostname")).To(BeARegularFile())
+		// the root filesystem unpacked with the previous options isn't used anymore
+		Expect(firstRootfs).NotTo(BeADirectory())
+	})
+
+	It("returns ErrNotFound for unknown images", func() {
+		_, err := store.Get("missing:latest")
+		Expect(err).To(MatchError(ErrNotFound))
+	})
+})
+
+var _ = Describe("ChainID", func() {
+	It("chains the diff IDs", func() {
+		a, b := Digest([]byte("a")), Digest([]byte("b"))
+		Expect(ChainID([]string{a})).To(Equal(a))
+		Expect(ChainID([]string{a, b})).To(Equal(Digest([]byte(a + " " + b))))
+	})
+})
@@ -0,0 +1,139 @@
+package image
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Media types of the OCI image specification, and of the Docker image manifest v2 schema 2 they derive from
+const (
+	MediaTypeImageIndex        = "application/vnd.oci.image.index.v1+json"
+	MediaTypeImageManifest     = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeImageConfig       = "application/vnd.oci.image.config.v1+json"
+	MediaTypeImageLayer        = "application/vnd.oci.image.layer.v1.tar"
+	MediaTypeImageLayerGzip    = "application/vnd.oci.image.layer.v1.tar+gzip"
+	MediaTypeImageLayerZstd    = "application/vnd.oci.image.layer.v1.tar+zstd"
+	MediaTypeDockerList        = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeDockerManifest    = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerConfig      = "application/vnd.docker.container.image.v1+json"
+	MediaTypeDockerLayer       = "application/vnd.docker.image.rootfs.diff.tar"
+	MediaTypeDockerLayerGzip   = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+	MediaTypeDockerForeignGzip = "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip"
+)
+
+const (
+	// AnnotationRefName holds the reference, or only the tag, of a manifest in an image layout index
+	AnnotationRefName = "org.opencontainers.image.ref.name"
+	// annotationImageName holds the full reference of a manifest in the layouts exported by containerd
+	annotationImageName = "io.containerd.image.name"
+)
+
+// Descriptor points to content by its digest
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	URLs        []string          `json:"urls,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *Platform         `json:"platform,omitempty"`
+}
+
+// Platform describes the operating system and CPU architecture an image runs on
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// DefaultPlatform returns the platform of the host: containers always run Linux images
+func DefaultPlatform() Platform {
+	return Platform{Architecture: runtime.GOARCH, OS: "linux"}
+}
+
+// Matches reports whether an image built for other can run on p. Variants are only compared when both are set.
+func (p Platform) Matches(other Platform) bool {
+	if p.OS != other.OS || p.Architecture != other.Architecture {
+		return false
+	}
+	return p.Variant == "" || other.Variant == "" || p.Variant == other.Variant
+}
+
+func (p Platform) String() string {
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+// Index lists the manifests of an image layout, or of a multi platform image
+type Index struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType,omitempty"`
+	Manifests     []Descriptor      `json:"manifests"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// Manifest describes an image for a single platform: its configuration and its layers, lowest first
+type Manifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType,omitempty"`
+	Config        Descriptor        `json:"config"`
+	Layers        []Descriptor      `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// Config is the image configuration: the platform, the runtime defaults and the digests of the uncompressed layers
+type Config struct {
+	Created      *time.Time      `json:"created,omitempty"`
+	Author       string          `json:"author,omitempty"`
+	Architecture string          `json:"architecture"`
+	OS           string          `json:"os"`
+	Variant      string          `json:"variant,omitempty"`
+	Config       ContainerConfig `json:"config"`
+	RootFS       RootFS          `json:"rootfs"`
+}
+
+// ContainerConfig holds the defaults of the containers created from an image
+type ContainerConfig struct {
+	User         string              `json:"User,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	Volumes      map[string]struct{} `json:"Volumes,omitempty"`
+	WorkingDir   string              `json:"WorkingDir,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+	StopSignal   string              `json:"StopSignal,omitempty"`
+}
+
+// RootFS lists the digests of the uncompressed layers, lowest first
+type RootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+// isIndex reports whether the media type is the one of a multi platform image
+func isIndex(mediaType string) bool {
+	return mediaType == MediaTypeImageIndex || mediaType == MediaTypeDockerList
+}
+
+// isManifest reports whether the media type is the one of a single platform image manifest
+func isManifest(mediaType string) bool {
+	return mediaType == MediaTypeImageManifest || mediaType == MediaTypeDockerManifest
+}
+
+// ParsePlatform parses a platform in the os/arch[/variant] format, e.g. linux/arm64/v8
+func ParsePlatform(s string) (Platform, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return Platform{}, fmt.Errorf("invalid platform '%s', expected os/arch[/variant]", s)
+	}
+	p := Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
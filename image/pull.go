@@ -0,0 +1,248 @@
+package image
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/NamelessOne91/coso/archive"
+	"github.com/NamelessOne91/coso/registry"
+)
+
+const (
+	// partialPrefix prefixes the blobs being downloaded, kept across pulls so that downloads can be resumed
+	partialPrefix = ".partial-"
+	// downloadAttempts is the number of times a blob download is attempted, resuming after network
+	// and server failures
+	downloadAttempts = 3
+)
+
+// downloadBackoff is the delay before the second download attempt, doubled for each following one
+var downloadBackoff = time.Second
+
+// manifestMediaTypes are the media types of the manifests accepted when pulling
+var manifestMediaTypes = []string{MediaTypeImageIndex, MediaTypeImageManifest, MediaTypeDockerList, MediaTypeDockerManifest}
+
+// PullOptions controls how images are pulled
+type PullOptions struct {
+	// Platform selects the manifest of multi platform images, the host platform when empty
+	Platform Platform
+	// Extract controls how the layers are extracted
+	Extract archive.Options
+	// ExtractID identifies the Extract options, as for LoadOptions
+	ExtractID string
+	// Progress, when set, receives a line for each blob downloaded
+	Progress io.Writer
+}
+
+// Pull downloads the image from its registry, verifying every manifest and blob against its digest.
+// Blobs already in the store aren't downloaded again, while interrupted downloads are resumed.
+func (s *Store) Pull(client *registry.Client, ref string, opts PullOptions) (*Image, error) {
+	reference, err := ParseReference(ref)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Platform.OS == "" {
+		opts.Platform = DefaultPlatform()
+	}
+
+	staged, err := s.stage()
+	if err != nil {
+		return nil, err
+	}
+	defer staged.discard()
+	return staged.pull(client, reference, opts)
+}
+
+// pull downloads the image with the given reference into the staging directory, and registers it
+func (s *Store) pull(client *registry.Client, reference *Reference, opts PullOptions) (*Image, error) {
+	desc, err := s.fetchManifest(client, reference, referenceOf(reference))
+	if err != nil {
+		return nil, err
+	}
+	for depth := 0; isIndex(desc.MediaType); depth++ {
+		if depth == maxLinks {
+			return nil, errors.New("too many nested image indexes")
+		}
+		var idx Index
+		if err := s.readJSON(desc.Digest, &idx); err != nil {
+			return nil, err
+		}
+		selected, err := SelectPlatform(idx.Manifests, opts.Platform)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", reference, err)
+		}
+		if desc, err = s.fetchManifest(client, reference, selected.Digest); err != nil {
+			return nil, err
+		}
+	}
+	if !isManifest(desc.MediaType) {
+		return nil, fmt.Errorf("unsupported manifest media type '%s'", desc.MediaType)
+	}
+
+	var tags []string
+	if reference.Tag != "" {
+		tags = []string{(&Reference{Domain: reference.Domain, Path: reference.Path, Tag: reference.Tag}).String()}
+	}
+	fetch := func(d Descriptor) error {
+		return s.downloadBlob(client, reference, d, opts.Progress)
+	}
+	return s.createImage(desc.Digest, fetch, tags, opts.Extract, opts.ExtractID)
+}
+
+// referenceOf returns the digest of the reference if pinned, its tag otherwise
+func referenceOf(ref *Reference) string {
+	if ref.Digest != "" {
+		return ref.Digest
+	}
+	return ref.Tag
+}
+
+// fetchManifest downloads the manifest, or image index, with the given tag or digest into the store.
+// Manifests fetched by digest are verified against it.
+func (s *Store) fetchManifest(client *registry.Client, ref *Reference, tagOrDigest string) (Descriptor, error) {
+	content, mediaType, err := client.Manifest(ref.Domain, ref.Path, tagOrDigest, manifestMediaTypes)
+	if err != nil {
+		return Descriptor{}, fmt.Errorf("fetching the manifest of %s: %w", ref, err)
+	}
+
+	expected := ""
+	if strings.HasPrefix(tagOrDigest, sha256Prefix) {
+		expected = tagOrDigest
+	}
+	digest, size, err := s.PutBlob(strings.NewReader(string(content)), expected, 0)
+	if err != nil {
+		return Descriptor{}, fmt.Errorf("manifest of %s: %w", ref, err)
+	}
+
+	// registries may omit the media type header, while manifests declare their own
+	var declared struct {
+		MediaType string `json:"mediaType"`
+	}
+	if err := s.readJSON(digest, &declared); err == nil && declared.MediaType != "" {
+		mediaType = declared.MediaType
+	}
+	return Descriptor{MediaType: mediaType, Digest: digest, Size: size}, nil
+}
+
+// downloadBlob downloads the blob into the store, unless already there. The content is written to a
+// partial file first, kept in the store across pulls, which is resumed with a range request after
+// a failure, and only moved to the staging directory once verified against its digest.
+func (s *Store) downloadBlob(client *registry.Client, ref *Reference, desc Descriptor, progress io.Writer) error {
+	if err := ValidateDigest(desc.Digest); err != nil {
+		return err
+	}
+	if s.HasBlob(desc.Digest) {
+		return nil
+	}
+	printProgress(progress, "Downloading %s (%d bytes)\n", desc.Digest, desc.Size)
+
+	dir := filepath.Join(s.path, blobsDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	partial := filepath.Join(dir, partialPrefix+digestHex(desc.Digest))
+	f, err := lockPartial(partial)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// only network and server failures are worth another attempt
+	backoff := downloadBackoff
+	for attempt := 1; ; attempt++ {
+		err = resumeDownload(client, ref, desc, f)
+		if err == nil || attempt == downloadAttempts || !registry.Temporary(err) {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	if err != nil {
+		return fmt.Errorf("downloading %s: %w", desc.Digest, err)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	d := newDigester()
+	if _, err := io.Copy(d, f); err != nil {
+		return err
+	}
+	if err := d.verify(desc.Digest, desc.Size); err != nil {
+		os.Remove(partial)
+		return err
+	}
+	staged := filepath.Join(s.root(), blobsDir)
+	if err := os.MkdirAll(staged, 0755); err != nil {
+		return err
+	}
+	return os.Rename(partial, filepath.Join(staged, digestHex(desc.Digest)))
+}
+
+// lockPartial opens the partial file at path, waiting for the pulls downloading the same blob to be done
+func lockPartial(path string) (*os.File, error) {
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return nil, err
+		}
+		if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+			f.Close()
+			return nil, err
+		}
+
+		// the pull holding the lock before may have moved the complete file away, or deleted it
+		locked, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if current, err := os.Stat(path); err == nil && os.SameFile(locked, current) {
+			return f, nil
+		}
+		f.Close()
+	}
+}
+
+// resumeDownload appends to the partial file the content of the blob following what it already holds
+func resumeDownload(client *registry.Client, ref *Reference, desc Descriptor, f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	offset := info.Size()
+	if desc.Size > 0 && offset == desc.Size {
+		return nil
+	}
+	if desc.Size > 0 && offset > desc.Size {
+		// the registry sent more than expected: the download starts over
+		offset = 0
+	}
+
+	body, start, err := client.Blob(ref.Domain, ref.Path, desc.Digest, offset)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	// the registry may ignore the range request and send the whole blob
+	if err := f.Truncate(start); err != nil {
+		return err
+	}
+	if _, err := f.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+
+	r := io.Reader(body)
+	if desc.Size > 0 {
+		// a registry sending more than the expected size is never trusted
+		r = io.LimitReader(body, desc.Size-start+1)
+	}
+	_, err = io.Copy(f, r)
+	return err
+}
@@ -0,0 +1,196 @@
+package image
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/NamelessOne91/coso/archive"
+	"github.com/NamelessOne91/coso/registry"
+	"github.com/NamelessOne91/coso/registry/registrytest"
+)
+
+// serveImage adds an image made of the given layers to the registry and returns the descriptor of its manifest
+func serveImage(server *registrytest.Server, repository, tag string, platform Platform, cmd string, layers ...[]entry) Descriptor {
+	config := Config{Architecture: platform.Architecture, OS: platform.OS, Config: ContainerConfig{Cmd: []string{cmd}}}
+	manifest := Manifest{SchemaVersion: 2, MediaType: MediaTypeImageManifest}
+	for _, entries := range layers {
+		content, diffID := buildLayer(entries...)
+		manifest.Layers = append(manifest.Layers, Descriptor{MediaType: MediaTypeImageLayerGzip, Digest: server.AddBlob(content), Size: int64(len(content))})
+		config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, diffID)
+	}
+	config.RootFS.Type = "layers"
+	content, err := json.Marshal(config)
+	Expect(err).NotTo(HaveOccurred())
+	manifest.Config = Descriptor{MediaType: MediaTypeImageConfig, Digest: server.AddBlob(content), Size: int64(len(content))}
+
+	content, err = json.Marshal(manifest)
+	Expect(err).NotTo(HaveOccurred())
+	digest := server.AddManifest(repository, tag, MediaTypeImageManifest, content)
+	return Descriptor{MediaType: MediaTypeImageManifest, Digest: digest, Size: int64(len(content)), Platform: &platform}
+}
+
+var _ = Describe("Pull", func() {
+
+	var (
+		dir    string
+		store  *Store
+		server *registrytest.Server
+		client *registry.Client
+		opts   PullOptions
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "coso-image")
+		Expect(err).NotTo(HaveOccurred())
+		store = NewStore(filepath.Join(dir, "store"))
+		server = registrytest.NewServer()
+		client = registry.NewClient()
+		client.Credentials = nil
+		opts = PullOptions{Extract: archive.Options{Rootless: os.Geteuid() != 0}}
+		downloadBackoff = time.Millisecond
+	})
+
+	AfterEach(func() {
+		server.Close()
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("pulls an image by tag", func() {
+		serveImage(server, "team/app", "1.0", DefaultPlatform(), "/bin/app", []entry{{"bin/", ""}, {"bin/app", "app"}})
+
+		img, err := store.Pull(client, server.Domain+"/team/app:1.0", opts)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(img.Tags).To(ConsistOf(server.Domain + "/team/app:1.0"))
+		Expect(filepath.Join(store.RootfsPath(img), "bin", "app")).To(BeAnExistingFile())
+
+		config, err := store.Config(img)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config.Config.Cmd).To(Equal([]string{"/bin/app"}))
+	})
+
+	It("pulls an image by digest without tagging it", func() {
+		manifest := serveImage(server, "app", "", DefaultPlatform(), "/bin/app", []entry{{"app", "app"}})
+
+		img, err := store.Pull(client, server.Domain+"/app@"+manifest.Digest, opts)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(img.ManifestDigest).To(Equal(manifest.Digest))
+		Expect(img.Tags).To(BeEmpty())
+	})
+
+	It("selects the manifest of the requested platform from an index", func() {
+		amd64 := serveImage(server, "app", "", Platform{OS: "linux", Architecture: "amd64"}, "amd64", []entry{{"arch", "amd64"}})
+		arm64 := serveImage(server, "app", "", Platform{OS: "linux", Architecture: "arm64"}, "arm64", []entry{{"arch", "arm64"}})
+		content, err := json.Marshal(Index{SchemaVersion: 2, MediaType: MediaTypeImageIndex, Manifests: []Descriptor{amd64, arm64}})
+		Expect(err).NotTo(HaveOccurred())
+		server.AddManifest("app", "latest", MediaTypeImageIndex, content)
+
+		opts.Platform = Platform{OS: "linux", Architecture: "arm64"}
+		img, err := store.Pull(client, server.Domain+"/app", opts)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(img.ManifestDigest).To(Equal(arm64.Digest))
+		Expect(os.ReadFile(filepath.Join(store.RootfsPath(img), "arch"))).To(Equal([]byte("arm64")))
+
+		opts.Platform = Platform{OS: "linux", Architecture: "s390x"}
+		_, err = store.Pull(client, server.Domain+"/app", opts)
+		Expect(err).To(MatchError(ContainSubstring("linux/s390x")))
+	})
+
+	It("resumes interrupted downloads", func() {
+		manifest := serveImage(server, "app", "latest", DefaultPlatform(), "/bin/app", []entry{{"big", string(make([]byte, 64<<10))}})
+		var m Manifest
+		content, _, err := client.Manifest(server.Domain, "app", manifest.Digest, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(json.Unmarshal(content, &m)).To(Succeed())
+		server.Interrupt(m.Layers[0].Digest, 10)
+
+		_, err = store.Pull(client, server.Domain+"/app", opts)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(server.Ranges()).To(ContainElement("bytes=10-"))
+	})
+
+	It("pulls the same image concurrently", func() {
+		serveImage(server, "app", "latest", DefaultPlatform(), "/bin/app", []entry{{"big", string(make([]byte, 256<<10))}})
+
+		errs := make(chan error, 4)
+		for i := 0; i < cap(errs); i++ {
+			go func() {
+				defer GinkgoRecover()
+				_, err := store.Pull(client, server.Domain+"/app", opts)
+				errs <- err
+			}()
+		}
+		for i := 0; i < cap(errs); i++ {
+			Expect(<-errs).NotTo(HaveOccurred())
+		}
+
+		img, err := store.Get(server.Domain + "/app")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(filepath.Join(store.RootfsPath(img), "big")).To(BeARegularFile())
+	})
+
+	It("doesn't retry downloads failing for good", func() {
+		layer, diffID := buildLayer(entry{"app", "app"})
+		config, err := json.Marshal(Config{OS: "linux", Architecture: DefaultPlatform().Architecture, RootFS: RootFS{Type: "layers", DiffIDs: []string{diffID}}})
+		Expect(err).NotTo(HaveOccurred())
+		manifest, err := json.Marshal(Manifest{
+			SchemaVersion: 2,
+			MediaType:     MediaTypeImageManifest,
+			Config:        Descriptor{MediaType: MediaTypeImageConfig, Digest: server.AddBlob(config), Size: int64(len(config))},
+			Layers:        []Descriptor{{MediaType: MediaTypeImageLayerGzip, Digest: Digest(layer), Size: int64(len(layer))}},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		server.AddManifest("app", "latest", MediaTypeImageManifest, manifest)
+
+		_, err = store.Pull(client, server.Domain+"/app", opts)
+		Expect(err).To(MatchError(registry.ErrNotFound))
+		// the configuration, then the missing layer once
+		Expect(server.Ranges()).To(HaveLen(2))
+	})
+
+	It("rejects blobs not matching their digest", func() {
+		layer, diffID := buildLayer(entry{"app", "app"})
+		config, err := json.Marshal(Config{OS: "linux", Architecture: DefaultPlatform().Architecture, RootFS: RootFS{Type: "layers", DiffIDs: []string{diffID}}})
+		Expect(err).NotTo(HaveOccurred())
+		tampered := Digest([]byte("something else"))
+		manifest, err := json.Marshal(Manifest{
+			SchemaVersion: 2,
+			MediaType:     MediaTypeImageManifest,
+			Config:        Descriptor{MediaType: MediaTypeImageConfig, Digest: server.AddBlob(config), Size: int64(len(config))},
+			Layers:        []Descriptor{{MediaType: MediaTypeImageLayerGzip, Digest: tampered, Size: int64(len(layer))}},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		server.AddManifest("app", "latest", MediaTypeImageManifest, manifest)
+		// the registry serves the layer under a digest which isn't its own
+		server.AddBlobAs(tampered, layer)
+
+		_, err = store.Pull(client, server.Domain+"/app", opts)
+		Expect(err).To(MatchError(ContainSubstring("digest mismatch")))
+		Expect(store.HasBlob(tampered)).To(BeFalse())
+	})
+
+	It("authenticates with the registry credentials", func() {
+		server.Username, server.Password = "user", "secret"
+		serveImage(server, "private/app", "latest", DefaultPlatform(), "/bin/app", []entry{{"app", "app"}})
+
+		_, err := store.Pull(client, server.Domain+"/private/app", opts)
+		Expect(err).To(MatchError(registry.ErrUnauthorized))
+
+		client.Credentials = func(domain string) (*registry.Credentials, error) {
+			Expect(domain).To(Equal(server.Domain))
+			return &registry.Credentials{Username: "user", Password: "secret"}, nil
+		}
+		_, err = store.Pull(client, server.Domain+"/private/app", opts)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("fails for unknown images", func() {
+		_, err := store.Pull(client, server.Domain+"/missing", opts)
+		Expect(err).To(MatchError(registry.ErrNotFound))
+	})
+})
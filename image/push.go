@@ -0,0 +1,153 @@
+package image
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/NamelessOne91/coso/registry"
+)
+
+// PushOptions controls how images are pushed
+type PushOptions struct {
+	// ChunkSize, when positive, makes the blobs larger than it be uploaded in chunks of that size,
+	// instead of with a single request
+	ChunkSize int64
+	// Progress, when set, receives a line for each blob pushed
+	Progress io.Writer
+}
+
+// Push uploads the image with the given reference or ID to the registry of destination, and returns
+// the digest of its manifest. Blobs the repository already holds aren't uploaded again, while the ones
+// held by other repositories of the same registry, which images in the store were tagged from, are
+// mounted from them.
+func (s *Store) Push(client *registry.Client, source, destination string, opts PushOptions) (string, error) {
+	img, err := s.Get(source)
+	if err != nil {
+		return "", err
+	}
+	ref, err := ParseReference(destination)
+	if err != nil {
+		return "", err
+	}
+	if ref.Digest != "" && ref.Digest != img.ManifestDigest {
+		return "", fmt.Errorf("the manifest of image %s doesn't match the digest of %s", img.ShortID(), destination)
+	}
+
+	manifest, err := s.Manifest(img)
+	if err != nil {
+		return "", err
+	}
+	sources, err := s.mountSources(ref)
+	if err != nil {
+		return "", err
+	}
+	for _, desc := range append([]Descriptor{manifest.Config}, manifest.Layers...) {
+		if err := s.pushBlob(client, ref, desc, sources[desc.Digest], opts); err != nil {
+			return "", fmt.Errorf("pushing blob %s: %w", desc.Digest, err)
+		}
+	}
+
+	f, err := s.OpenBlob(img.ManifestDigest)
+	if err != nil {
+		return "", err
+	}
+	content, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return "", err
+	}
+	mediaType := manifest.MediaType
+	if mediaType == "" {
+		mediaType = MediaTypeImageManifest
+	}
+	reference := ref.Tag
+	if reference == "" {
+		reference = ref.Digest
+	}
+	digest, err := client.PutManifest(ref.Domain, ref.Path, reference, mediaType, content)
+	if err != nil {
+		return "", fmt.Errorf("pushing the manifest of %s: %w", ref, err)
+	}
+	if digest != "" && digest != img.ManifestDigest {
+		return "", fmt.Errorf("the registry stored the manifest of %s as %s, expected %s", ref, digest, img.ManifestDigest)
+	}
+	return img.ManifestDigest, nil
+}
+
+// mountSources maps the digests of the blobs of the images in the store to the other repositories
+// of the registry of ref the images are tagged from, which the blobs can be mounted from
+func (s *Store) mountSources(ref *Reference) (map[string][]string, error) {
+	images, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	sources := map[string][]string{}
+	for _, img := range images {
+		for _, tag := range img.Tags {
+			tagged, err := ParseReference(tag)
+			if err != nil || tagged.Domain != ref.Domain || tagged.Path == ref.Path {
+				continue
+			}
+			addSource(sources, img.ID, tagged.Path)
+			for _, layer := range img.Layers {
+				addSource(sources, layer.Digest, tagged.Path)
+			}
+		}
+	}
+	return sources, nil
+}
+
+// addSource adds the repository to the ones the blob can be mounted from
+func addSource(sources map[string][]string, digest, repository string) {
+	for _, r := range sources[digest] {
+		if r == repository {
+			return
+		}
+	}
+	sources[digest] = append(sources[digest], repository)
+}
+
+// pushBlob uploads the blob to the repository of ref, unless it already holds it or the blob can be
+// mounted from one of the given repositories
+func (s *Store) pushBlob(client *registry.Client, ref *Reference, desc Descriptor, sources []string, opts PushOptions) error {
+	exists, err := client.BlobExists(ref.Domain, ref.Path, desc.Digest)
+	if err != nil {
+		return err
+	}
+	if exists {
+		printProgress(opts.Progress, "Blob %s already exists\n", desc.Digest)
+		return nil
+	}
+	for _, from := range sources {
+		mounted, err := client.MountBlob(ref.Domain, ref.Path, from, desc.Digest)
+		// the blob is uploaded when the repository can't be read from
+		if err != nil && !errors.Is(err, registry.ErrUnauthorized) {
+			return err
+		}
+		if mounted {
+			printProgress(opts.Progress, "Mounted %s from %s\n", desc.Digest, from)
+			return nil
+		}
+	}
+
+	f, err := s.OpenBlob(desc.Digest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	printProgress(opts.Progress, "Uploading %s (%d bytes)\n", desc.Digest, info.Size())
+	return client.UploadBlob(ref.Domain, ref.Path, desc.Digest, f, info.Size(), opts.ChunkSize)
+}
+
+// printProgress writes the progress line to w, if set
+func printProgress(w io.Writer, format string, args ...interface{}) {
+	if w != nil {
+		fmt.Fprintf(w, format, args...)
+	}
+}
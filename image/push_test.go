@@ -0,0 +1,138 @@
+package image
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/NamelessOne91/coso/archive"
+	"github.com/NamelessOne91/coso/registry"
+	"github.com/NamelessOne91/coso/registry/registrytest"
+)
+
+var _ = Describe("Push", func() {
+
+	var (
+		dir     string
+		store   *Store
+		server  *registrytest.Server
+		client  *registry.Client
+		img     *Image
+		extract archive.Options
+	)
+
+	// load loads an image with a large layer into the store, tagged with the given references
+	load := func(tags ...string) *Image {
+		layout := filepath.Join(dir, "layout")
+		manifest := writeImage(layout, DefaultPlatform(), "/bin/app", []entry{{"app", "app"}}, []entry{{"data", string(make([]byte, 32<<10))}})
+		writeIndex(layout, manifest)
+		images, err := store.Load(layout, LoadOptions{Tags: tags, Extract: extract})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(images).To(HaveLen(1))
+		return images[0]
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "coso-image")
+		Expect(err).NotTo(HaveOccurred())
+		store = NewStore(filepath.Join(dir, "store"))
+		server = registrytest.NewServer()
+		client = registry.NewClient()
+		client.Credentials = nil
+		extract = archive.Options{Rootless: os.Geteuid() != 0}
+		img = load("app:1.0")
+	})
+
+	AfterEach(func() {
+		server.Close()
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("pushes images which can be pulled back", func() {
+		digest, err := store.Push(client, "app:1.0", server.Domain+"/team/app:1.0", PushOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(digest).To(Equal(img.ManifestDigest))
+
+		uploads := server.Uploads()
+		Expect(uploads).To(HaveLen(3))
+		for _, upload := range uploads {
+			Expect(upload.Repository).To(Equal("team/app"))
+			Expect(upload.Chunks).To(BeZero())
+		}
+
+		pulled, err := NewStore(filepath.Join(dir, "pulled")).Pull(client, server.Domain+"/team/app:1.0", PullOptions{Extract: extract})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pulled.ManifestDigest).To(Equal(img.ManifestDigest))
+		Expect(pulled.ChainID).To(Equal(img.ChainID))
+	})
+
+	It("uploads large blobs in chunks", func() {
+		_, err := store.Push(client, img.ID, server.Domain+"/app", PushOptions{ChunkSize: 16})
+		Expect(err).NotTo(HaveOccurred())
+
+		chunks := map[string]int{}
+		for _, upload := range server.Uploads() {
+			chunks[upload.Digest] = upload.Chunks
+		}
+		for _, layer := range img.Layers {
+			Expect(chunks[layer.Digest]).To(BeNumerically("==", (layer.Size+15)/16))
+		}
+	})
+
+	It("doesn't upload the blobs already in the repository", func() {
+		_, err := store.Push(client, "app:1.0", server.Domain+"/app:1.0", PushOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = store.Push(client, "app:1.0", server.Domain+"/app:1.1", PushOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(server.Uploads()).To(HaveLen(3))
+
+		_, content, found := server.Manifest("app", "1.1")
+		Expect(found).To(BeTrue())
+		Expect(Digest(content)).To(Equal(img.ManifestDigest))
+	})
+
+	It("mounts the blobs of images tagged from other repositories of the registry", func() {
+		base := server.Domain + "/team/base:1.0"
+		load(base)
+		_, err := store.Push(client, base, base, PushOptions{})
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = store.Push(client, "app:1.0", server.Domain+"/team/app:1.0", PushOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		for _, upload := range server.Uploads()[3:] {
+			Expect(upload.Repository).To(Equal("team/app"))
+			Expect(upload.MountedFrom).To(Equal("team/base"))
+		}
+		Expect(server.Uploads()).To(HaveLen(6))
+	})
+
+	It("pushes by digest", func() {
+		_, err := store.Push(client, "app:1.0", server.Domain+"/app@"+img.ManifestDigest, PushOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		_, _, found := server.Manifest("app", img.ManifestDigest)
+		Expect(found).To(BeTrue())
+
+		_, err = store.Push(client, "app:1.0", server.Domain+"/app@"+Digest([]byte("other")), PushOptions{})
+		Expect(err).To(MatchError(ContainSubstring("doesn't match the digest")))
+	})
+
+	It("authenticates with the registry credentials", func() {
+		server.Username, server.Password = "user", "secret"
+		_, err := store.Push(client, "app:1.0", server.Domain+"/app:1.0", PushOptions{})
+		Expect(err).To(MatchError(registry.ErrUnauthorized))
+
+		client.Credentials = func(string) (*registry.Credentials, error) {
+			return &registry.Credentials{Username: "user", Password: "secret"}, nil
+		}
+		_, err = store.Push(client, "app:1.0", server.Domain+"/app:1.0", PushOptions{ChunkSize: 1024})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("fails for unknown images", func() {
+		_, err := store.Push(client, "missing", server.Domain+"/missing", PushOptions{})
+		Expect(err).To(MatchError(ErrNotFound))
+	})
+})
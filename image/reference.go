@@ -0,0 +1,112 @@
+package image
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// DefaultDomain is the registry of the references without a domain, as in Docker
+	DefaultDomain = "docker.io"
+	// DefaultTag is the tag of the references with neither a tag nor a digest
+	DefaultTag = "latest"
+
+	officialRepositoryPrefix = "library/"
+)
+
+var (
+	pathComponent = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*$`)
+	validTag      = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_.-]{0,127}$`)
+	validDomain   = regexp.MustCompile(`^(?:[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?\.?)+(?::[0-9]+)?$|^\[[a-fA-F0-9:]+\](?::[0-9]+)?$`)
+)
+
+// Reference identifies an image in a registry: registry.example.com:5000/team/app:1.0,
+// optionally pinned to the digest of its manifest
+type Reference struct {
+	// Domain is the registry host, and port
+	Domain string
+	// Path is the repository inside the registry
+	Path   string
+	Tag    string
+	Digest string
+}
+
+// ParseReference parses and normalizes an image reference with the same rules as Docker:
+// references without a domain point to Docker Hub, official images live in the library
+// repository, and the tag defaults to latest unless a digest is given
+func ParseReference(s string) (*Reference, error) {
+	ref := &Reference{}
+	name := s
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, ref.Digest = name[:i], name[i+1:]
+		if err := ValidateDigest(ref.Digest); err != nil {
+			return nil, fmt.Errorf("invalid reference '%s': %w", s, err)
+		}
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, ref.Tag = name[:i], name[i+1:]
+		if !validTag.MatchString(ref.Tag) {
+			return nil, fmt.Errorf("invalid reference '%s': invalid tag '%s'", s, ref.Tag)
+		}
+	}
+
+	components := strings.Split(name, "/")
+	// the first component is a registry when it can't be a repository name
+	if first := components[0]; len(components) > 1 && (strings.ContainsAny(first, ".:") || first == "localhost" || strings.ToLower(first) != first) {
+		if !validDomain.MatchString(first) {
+			return nil, fmt.Errorf("invalid reference '%s': invalid registry '%s'", s, first)
+		}
+		ref.Domain, components = first, components[1:]
+	} else {
+		ref.Domain = DefaultDomain
+	}
+	for _, component := range components {
+		if !pathComponent.MatchString(component) {
+			return nil, fmt.Errorf("invalid reference '%s': repository names must be lowercase and alphanumeric, with separators", s)
+		}
+	}
+	ref.Path = strings.Join(components, "/")
+	if ref.Domain == DefaultDomain && len(components) == 1 {
+		ref.Path = officialRepositoryPrefix + ref.Path
+	}
+
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = DefaultTag
+	}
+	return ref, nil
+}
+
+// Name returns the repository name, including the registry
+func (r *Reference) Name() string {
+	return r.Domain + "/" + r.Path
+}
+
+// String returns the normalized reference, e.g. docker.io/library/alpine:latest
+func (r *Reference) String() string {
+	s := r.Name()
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// Familiar returns the reference as Docker shows it, without the default registry and repository
+func (r *Reference) Familiar() string {
+	return FamiliarName(r.String())
+}
+
+// FamiliarName shortens a normalized reference to the form Docker shows, e.g. alpine:latest
+func FamiliarName(ref string) string {
+	name, found := strings.CutPrefix(ref, DefaultDomain+"/")
+	if !found {
+		return ref
+	}
+	if official, found := strings.CutPrefix(name, officialRepositoryPrefix); found && !strings.Contains(official, "/") {
+		return official
+	}
+	return name
+}
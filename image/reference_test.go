@@ -0,0 +1,40 @@
+package image
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseReference", func() {
+
+	digest := "sha256:" + "ab12000000000000000000000000000000000000000000000000000000000000"
+
+	DescribeTable("normalizes references",
+		func(ref, normalized, familiar string) {
+			parsed, err := ParseReference(ref)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(parsed.String()).To(Equal(normalized))
+			Expect(parsed.Familiar()).To(Equal(familiar))
+		},
+		Entry("official image", "alpine", "docker.io/library/alpine:latest", "alpine:latest"),
+		Entry("tag", "alpine:3.18", "docker.io/library/alpine:3.18", "alpine:3.18"),
+		Entry("user repository", "team/app:1.0", "docker.io/team/app:1.0", "team/app:1.0"),
+		Entry("registry", "registry.example.com/team/app", "registry.example.com/team/app:latest", "registry.example.com/team/app:latest"),
+		Entry("registry with port", "localhost:5000/app:dev", "localhost:5000/app:dev", "localhost:5000/app:dev"),
+		Entry("localhost", "localhost/app", "localhost/app:latest", "localhost/app:latest"),
+		Entry("digest", "alpine@"+digest, "docker.io/library/alpine@"+digest, "alpine@"+digest),
+	)
+
+	DescribeTable("rejects invalid references",
+		func(ref string) {
+			_, err := ParseReference(ref)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("uppercase repository", "Team/App"),
+		Entry("empty", ""),
+		Entry("invalid tag", "alpine:-3"),
+		Entry("invalid digest", "alpine@sha256:xyz"),
+		Entry("empty component", "team//app"),
+	)
+})
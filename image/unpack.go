@@ -0,0 +1,82 @@
+package image
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/NamelessOne91/coso/archive"
+)
+
+// ChainID returns the identifier of the root filesystem obtained applying the layers with the given
+// uncompressed digests in order, as defined by the OCI image specification
+func ChainID(diffIDs []string) string {
+	if len(diffIDs) == 0 {
+		return ""
+	}
+	chainID := diffIDs[0]
+	for _, diffID := range diffIDs[1:] {
+		chainID = Digest([]byte(chainID + " " + diffID))
+	}
+	return chainID
+}
+
+// unpack extracts the layers of the image on top of each other, applying their whiteouts, into the
+// staging directory, unless its root filesystem has already been unpacked. The uncompressed content
+// of each layer is verified against its diff ID.
+func (s *Store) unpack(img *Image, diffIDs []string, opts archive.Options) error {
+	path := filepath.Join(s.root(), rootfsDir, img.rootfsName())
+	for _, existing := range []string{s.RootfsPath(img), path} {
+		if _, err := os.Stat(existing); err == nil {
+			return nil
+		}
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(dir, ".unpack-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if err := os.Chmod(tmp, 0755); err != nil {
+		return err
+	}
+
+	opts.Whiteouts = true
+	for i, layer := range img.Layers {
+		if err := s.applyLayer(tmp, layer, diffIDs[i], opts); err != nil {
+			return fmt.Errorf("unpacking layer %s: %w", layer.Digest, err)
+		}
+	}
+	return os.Rename(tmp, path)
+}
+
+// applyLayer extracts the layer blob into dest, verifying its uncompressed content matches diffID
+func (s *Store) applyLayer(dest string, layer Descriptor, diffID string, opts archive.Options) error {
+	f, err := s.OpenBlob(layer.Digest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r, err := archive.Decompress(f)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	d := newDigester()
+	tee := io.TeeReader(r, d)
+	if err := archive.Extract(tee, dest, opts); err != nil {
+		return err
+	}
+	// the tar reader stops at the end of archive marker, which may be followed by padding
+	if _, err := io.Copy(io.Discard, tee); err != nil {
+		return err
+	}
+	return d.verify(diffID, 0)
+}
@@ -0,0 +1,13 @@
+// Package stringslice holds the helpers for slices of strings the standard library of the Go version
+// targeted by the module lacks
+package stringslice
+
+// Contains reports whether value is in values
+func Contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
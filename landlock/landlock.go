@@ -0,0 +1,155 @@
+// Package landlock restricts the filesystem hierarchies a container's workload can access,
+// using the Landlock LSM as a further layer of defence on top of its root filesystem isolation
+package landlock
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Access is the kind of access a rule grants
+type Access string
+
+const (
+	// ReadOnly allows reading and executing files, and listing directories
+	ReadOnly Access = "ro"
+	// ReadWrite allows every filesystem action Landlock can restrict
+	ReadWrite Access = "rw"
+)
+
+const (
+	// accessV1 is the set of filesystem rights handled by the first Landlock ABI
+	accessV1 = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE | unix.LANDLOCK_ACCESS_FS_READ_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_DIR | unix.LANDLOCK_ACCESS_FS_REMOVE_DIR | unix.LANDLOCK_ACCESS_FS_REMOVE_FILE |
+		unix.LANDLOCK_ACCESS_FS_MAKE_CHAR | unix.LANDLOCK_ACCESS_FS_MAKE_DIR | unix.LANDLOCK_ACCESS_FS_MAKE_REG |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SOCK | unix.LANDLOCK_ACCESS_FS_MAKE_FIFO | unix.LANDLOCK_ACCESS_FS_MAKE_BLOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SYM
+	// readOnlyAccess is granted by ReadOnly rules
+	readOnlyAccess = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_READ_DIR
+	// fileAccess are the only rights meaningful for rules on regular files
+	fileAccess = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_TRUNCATE
+)
+
+// Rule grants access to the filesystem hierarchy rooted at Path
+type Rule struct {
+	Path   string `json:"path"`
+	Access Access `json:"access"`
+}
+
+// ParseAccess validates the given access kind
+func ParseAccess(access string) (Access, error) {
+	switch Access(access) {
+	case ReadOnly, ReadWrite:
+		return Access(access), nil
+	default:
+		return "", fmt.Errorf("invalid landlock access '%s': must be %s or %s", access, ReadOnly, ReadWrite)
+	}
+}
+
+// Validate checks the rule path is absolute and its access kind is valid
+func (r Rule) Validate() error {
+	if !filepath.IsAbs(r.Path) {
+		return fmt.Errorf("the landlock path '%s' must be absolute", r.Path)
+	}
+	_, err := ParseAccess(string(r.Access))
+	return err
+}
+
+// Restrict enforces a Landlock ruleset on the calling thread, and the processes it executes, denying
+// any filesystem access not granted by the rules. Paths are resolved in the current mount namespace.
+//
+// Unless the no_new_privs flag is set, the calling thread must hold CAP_SYS_ADMIN in its user namespace.
+func Restrict(rules []Rule) error {
+	abi, err := abiVersion()
+	if err != nil {
+		return err
+	}
+
+	handled := handledAccess(abi)
+	attr := unix.LandlockRulesetAttr{Access_fs: handled}
+	rulesetFd, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("landlock_create_ruleset failed: %s", errno)
+	}
+	defer unix.Close(int(rulesetFd))
+
+	for _, rule := range rules {
+		if err := addRule(int(rulesetFd), rule, handled); err != nil {
+			return err
+		}
+	}
+
+	if _, _, errno := unix.Syscall(unix.SYS_LANDLOCK_RESTRICT_SELF, rulesetFd, 0, 0); errno != 0 {
+		return fmt.Errorf("landlock_restrict_self failed: %s", errno)
+	}
+	return nil
+}
+
+// addRule adds to the ruleset a rule granting the given access beneath rule.Path
+func addRule(rulesetFd int, rule Rule, handled uint64) error {
+	pathFd, err := unix.Open(rule.Path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("unable to open landlock path '%s': %s", rule.Path, err)
+	}
+	defer unix.Close(pathFd)
+
+	info, err := os.Stat(rule.Path)
+	if err != nil {
+		return err
+	}
+
+	attr := unix.LandlockPathBeneathAttr{
+		Allowed_access: allowedAccess(rule.Access, info.IsDir(), handled),
+		Parent_fd:      int32(pathFd),
+	}
+	_, _, errno := unix.Syscall6(
+		unix.SYS_LANDLOCK_ADD_RULE,
+		uintptr(rulesetFd),
+		unix.LANDLOCK_RULE_PATH_BENEATH,
+		uintptr(unsafe.Pointer(&attr)),
+		0, 0, 0,
+	)
+	if errno != 0 {
+		return fmt.Errorf("unable to add landlock rule for '%s': %s", rule.Path, errno)
+	}
+	return nil
+}
+
+// handledAccess returns the filesystem rights handled, and so denied unless granted, with the given ABI version
+func handledAccess(abi int) uint64 {
+	handled := uint64(accessV1)
+	if abi >= 2 {
+		handled |= unix.LANDLOCK_ACCESS_FS_REFER
+	}
+	if abi >= 3 {
+		handled |= unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+	return handled
+}
+
+// allowedAccess returns the rights a rule with the given access grants on a directory, or a file,
+// among the handled ones
+func allowedAccess(access Access, isDir bool, handled uint64) uint64 {
+	allowed := handled
+	if access == ReadOnly {
+		allowed = readOnlyAccess
+	}
+	if !isDir {
+		allowed &= fileAccess
+	}
+	return allowed & handled
+}
+
+// abiVersion returns the Landlock ABI version supported by the running kernel
+func abiVersion() (int, error) {
+	version, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if errno != 0 {
+		return 0, fmt.Errorf("landlock is not supported by the running kernel: %s", errno)
+	}
+	return int(version), nil
+}
@@ -0,0 +1,13 @@
+package landlock_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestLandlock(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Landlock suite")
+}
@@ -0,0 +1,69 @@
+package landlock
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"golang.org/x/sys/unix"
+)
+
+var _ = Describe("Landlock", func() {
+
+	DescribeTable("parses access kinds",
+		func(access string, expected Access, valid bool) {
+			parsed, err := ParseAccess(access)
+			if !valid {
+				Expect(err).To(MatchError(ContainSubstring("invalid landlock access")))
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(parsed).To(Equal(expected))
+		},
+		Entry("read only", "ro", ReadOnly, true),
+		Entry("read write", "rw", ReadWrite, true),
+		Entry("upper case", "RO", Access(""), false),
+		Entry("empty", "", Access(""), false),
+		Entry("unknown", "wo", Access(""), false),
+	)
+
+	DescribeTable("validates rules",
+		func(rule Rule, expectedErr string) {
+			err := rule.Validate()
+			if expectedErr == "" {
+				Expect(err).NotTo(HaveOccurred())
+				return
+			}
+			Expect(err).To(MatchError(ContainSubstring(expectedErr)))
+		},
+		Entry("absolute read only path", Rule{Path: "/usr", Access: ReadOnly}, ""),
+		Entry("absolute read write path", Rule{Path: "/tmp", Access: ReadWrite}, ""),
+		Entry("relative path", Rule{Path: "usr", Access: ReadOnly}, "must be absolute"),
+		Entry("invalid access", Rule{Path: "/usr", Access: "x"}, "invalid landlock access"),
+	)
+
+	DescribeTable("handles the rights of the ABI version",
+		func(abi int, refer, truncate bool) {
+			handled := handledAccess(abi)
+			Expect(handled & accessV1).To(Equal(uint64(accessV1)))
+			Expect(handled&unix.LANDLOCK_ACCESS_FS_REFER != 0).To(Equal(refer))
+			Expect(handled&unix.LANDLOCK_ACCESS_FS_TRUNCATE != 0).To(Equal(truncate))
+		},
+		Entry("version 1", 1, false, false),
+		Entry("version 2", 2, true, false),
+		Entry("version 3", 3, true, true),
+		Entry("later versions", 5, true, true),
+	)
+
+	DescribeTable("grants the rights of the rule access",
+		func(access Access, isDir bool, abi int, expected uint64) {
+			Expect(allowedAccess(access, isDir, handledAccess(abi))).To(Equal(expected))
+		},
+		Entry("read only directory", ReadOnly, true, 3, uint64(readOnlyAccess)),
+		Entry("read only file", ReadOnly, false, 3,
+			uint64(unix.LANDLOCK_ACCESS_FS_EXECUTE|unix.LANDLOCK_ACCESS_FS_READ_FILE)),
+		Entry("read write directory", ReadWrite, true, 3, handledAccess(3)),
+		Entry("read write file", ReadWrite, false, 3, uint64(fileAccess)),
+		Entry("read write file without truncate", ReadWrite, false, 1,
+			uint64(unix.LANDLOCK_ACCESS_FS_EXECUTE|unix.LANDLOCK_ACCESS_FS_WRITE_FILE|unix.LANDLOCK_ACCESS_FS_READ_FILE)),
+	)
+})
@@ -4,19 +4,61 @@ import (
 	"fmt"
 	"net"
 	"os"
-	"os/exec"
+	"runtime"
 	"syscall"
 	"time"
 
 	"github.com/NamelessOne91/coso/cgroups"
 	"github.com/NamelessOne91/coso/command"
 	"github.com/NamelessOne91/coso/filesystem"
+	"github.com/NamelessOne91/coso/spec"
+	"github.com/NamelessOne91/coso/users"
+)
+
+const (
+	// defaultPath is the PATH of the workload, unless overridden
+	defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+	// defaultTerm is the TERM of the workload, unless overridden
+	defaultTerm = "xterm"
 )
 
 // InitNamespaces performs the set of necessary syscalls allowing to run
 // a child process in its own isolated namespace(s)
 func InitNamespaces() {
-	newrootPath := os.Args[1]
+	// Landlock domains, seccomp filters, capabilities and no_new_privs are per thread attributes: the
+	// init process runs on a single OS thread, the one executing the workload, which must never change
+	runtime.LockOSThread()
+
+	// hold until the parent has configured the user namespace ID mappings, and sent the spec
+	encodedSpec, err := command.WaitForParent()
+	if err != nil {
+		fmt.Printf("Error waiting for the parent process - %s\n", err)
+		os.Exit(1)
+	}
+
+	containerSpec, err := spec.Decode(string(encodedSpec))
+	if err != nil {
+		fmt.Printf("Error decoding the container spec - %s\n", err)
+		os.Exit(1)
+	}
+
+	idmappedTrees, err := receiveIDMappedTrees(containerSpec)
+	if err != nil {
+		fmt.Printf("Error receiving the idmapped mounts - %s\n", err)
+		os.Exit(1)
+	}
+	// the mount namespace is a copy of the host's one, including its propagation settings
+	if err := filesystem.SetRootPropagation(containerSpec.RootfsPropagation); err != nil {
+		fmt.Printf("Error setting the root mount propagation - %s\n", err)
+		os.Exit(1)
+	}
+
+	// files written by the workload end up in the container's upper layer, leaving the rootfs untouched
+	newrootPath, err := filesystem.MountOverlay(containerSpec.Rootfs, filesystem.ContainerPath(containerSpec.ID), containerSpec.StorageQuota)
+	if err != nil {
+		fmt.Printf("Error mounting the overlay filesystem - %s\n", err)
+		os.Exit(1)
+	}
 
 	if err := cgroups.ConfigureCgroup(newrootPath, "10000"); err != nil {
 		fmt.Printf("Error creating Cgroups - %s\n", err)
@@ -28,6 +70,34 @@ func InitNamespaces() {
 		os.Exit(1)
 	}
 
+	if err := filesystem.MountSysfs(newrootPath, containerSpec.ReadonlySysfs); err != nil {
+		fmt.Printf("Error mounting /sys - %s\n", err)
+		os.Exit(1)
+	}
+
+	if err := filesystem.SetupDev(newrootPath, filesystem.ContainerDevices(containerSpec.Devices)); err != nil {
+		fmt.Printf("Error populating /dev - %s\n", err)
+		os.Exit(1)
+	}
+
+	if err := filesystem.MaskPaths(newrootPath, containerSpec.MaskedPaths); err != nil {
+		fmt.Printf("Error masking paths - %s\n", err)
+		os.Exit(1)
+	}
+
+	if err := filesystem.MakeReadonly(newrootPath, containerSpec.ReadonlyPaths); err != nil {
+		fmt.Printf("Error making paths read only - %s\n", err)
+		os.Exit(1)
+	}
+
+	if err := filesystem.MountAll(newrootPath, containerSpec.Mounts, idmappedTrees); err != nil {
+		fmt.Printf("Error mounting filesystems - %s\n", err)
+		os.Exit(1)
+	}
+	for _, tree := range idmappedTrees {
+		tree.Close()
+	}
+
 	// the pivot_root syscall must happen inside the new mount namespace
 	// otherwise, you'll end up changing the host's /
 	if err := filesystem.PivotRoot(newrootPath); err != nil {
@@ -35,28 +105,133 @@ func InitNamespaces() {
 		os.Exit(1)
 	}
 
-	if err := syscall.Sethostname([]byte("coso")); err != nil {
+	if err := filesystem.ShareRoot(containerSpec.RootfsPropagation); err != nil {
+		fmt.Printf("Error sharing the root mount - %s\n", err)
+		os.Exit(1)
+	}
+
+	// names are resolved against the container's databases, now available at /etc
+	execUser, err := users.Lookup(containerSpec.Process.User, containerSpec.Process.AdditionalGroups, users.PasswdPath, users.GroupPath)
+	if err != nil {
+		fmt.Printf("Error resolving the container user - %s\n", err)
+		os.Exit(1)
+	}
+
+	hostname := containerSpec.Hostname
+	if err := setupUTS(hostname, containerSpec.Domainname); err != nil {
 		fmt.Printf("Error setting hostname - %s\n", err)
 		os.Exit(1)
 	}
 
+	// the working directory is created, if missing, while the root filesystem is still writable
+	// and the init process still holds root privileges
+	if containerSpec.Process.Cwd == "" {
+		containerSpec.Process.Cwd = "/"
+	}
+	if err := os.MkdirAll(containerSpec.Process.Cwd, 0755); err != nil {
+		fmt.Printf("Error creating the working directory - %s\n", err)
+		os.Exit(1)
+	}
+
+	// done after writing /etc/hostname and /etc/hosts
+	if containerSpec.ReadonlyRootfs {
+		if err := filesystem.RemountRootReadonly(); err != nil {
+			fmt.Printf("Error making the root filesystem read only - %s\n", err)
+			os.Exit(1)
+		}
+	}
+
 	// hold launching /bin/sh untill the network is ready
 	if err := waitForNetwork(); err != nil {
 		fmt.Printf("Error waiting for network - %s\n", err)
 		os.Exit(1)
 	}
 
-	nsRun()
+	nsRun(&containerSpec.Process, execUser, hostname)
 }
 
-// nsRun starts the system shell inside the namespace
-func nsRun() {
-	cmd := exec.Command("/bin/sh")
+// receiveIDMappedTrees receives the trees of the idmapped mounts, prepared by the parent before releasing
+// the init process, and closes the fd socket unless it is needed to send the seccomp notification fd
+func receiveIDMappedTrees(containerSpec *spec.Spec) ([]*os.File, error) {
+	if !containerSpec.UsesFdSocket() {
+		return nil, nil
+	}
 
-	command.SetupProcessEnv(cmd)
+	var trees []*os.File
+	if n := len(containerSpec.IDMappedMounts()); n > 0 {
+		var err error
+		if trees, err = command.ReceiveFds(n); err != nil {
+			return nil, err
+		}
+	}
+	if containerSpec.Process.SeccompAudit == nil {
+		command.CloseFdSocket()
+	}
+	return trees, nil
+}
 
-	if err := cmd.Run(); err != nil {
-		fmt.Printf("Error running the /bin/sh command - %s\n", err)
+// nsRun replaces the init process with the system shell, running as the given user
+// with reduced privileges inside the process' working directory and environment
+func nsRun(process *spec.Process, execUser *users.ExecUser, hostname string) {
+	profileFilter, auditFilter, err := compileSeccompFilters(process)
+	if err != nil {
+		fmt.Printf("Error compiling the seccomp profile - %s\n", err)
+		os.Exit(1)
+	}
+	// without no_new_privs applying Landlock and seccomp requires CAP_SYS_ADMIN, which may be about to be dropped:
+	// in this case the seccomp profile must allow the syscalls needed to drop privileges
+	if !process.NoNewPrivileges {
+		if err := restrictFilesystem(process.Landlock); err != nil {
+			fmt.Printf("Error applying the Landlock ruleset - %s\n", err)
+			os.Exit(1)
+		}
+		if err := installSeccompFilters(profileFilter, auditFilter); err != nil {
+			fmt.Printf("Error installing the seccomp filters - %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if err := dropPrivileges(process, execUser); err != nil {
+		fmt.Printf("Error dropping privileges - %s\n", err)
+		os.Exit(1)
+	}
+
+	if err := os.Chdir(process.Cwd); err != nil {
+		fmt.Printf("Error changing working directory - %s\n", err)
+		os.Exit(1)
+	}
+
+	// installed as late as possible, so that the profile only needs to allow what the workload does
+	if process.NoNewPrivileges {
+		if err := restrictFilesystem(process.Landlock); err != nil {
+			fmt.Printf("Error applying the Landlock ruleset - %s\n", err)
+			os.Exit(1)
+		}
+		if err := installSeccompFilters(profileFilter, auditFilter); err != nil {
+			fmt.Printf("Error installing the seccomp filters - %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	defaultEnv := []string{
+		"PATH=" + defaultPath,
+		"TERM=" + defaultTerm,
+		"HOSTNAME=" + hostname,
+		"HOME=" + execUser.Home,
+		"PS1=-[coso]- # ",
+	}
+	env := command.MergeEnv(defaultEnv, process.Env)
+	if len(process.Args) == 0 {
+		fmt.Printf("Error running the workload - no command specified\n")
+		os.Exit(1)
+	}
+	executable, err := command.LookPath(process.Args[0], env)
+	if err != nil {
+		fmt.Printf("Error running the %s command - %s\n", process.Args[0], err)
+		os.Exit(1)
+	}
+	if err := syscall.Exec(executable, process.Args, env); err != nil {
+		fmt.Printf("Error running the %s command - %s\n", process.Args[0], err)
 		os.Exit(1)
 	}
 }
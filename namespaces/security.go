@@ -0,0 +1,103 @@
+package namespaces
+
+import (
+	"fmt"
+
+	"github.com/NamelessOne91/coso/capabilities"
+	"github.com/NamelessOne91/coso/command"
+	"github.com/NamelessOne91/coso/landlock"
+	"github.com/NamelessOne91/coso/seccomp"
+	"github.com/NamelessOne91/coso/spec"
+	"github.com/NamelessOne91/coso/users"
+	"golang.org/x/sys/unix"
+)
+
+// dropPrivileges switches the init process to the workload's user, restricting its
+// capabilities to the configured set and optionally setting the no_new_privs flag.
+//
+// Capabilities and no_new_privs are per thread attributes: the calling goroutine must be locked
+// to its OS thread, which must be the one executing the workload.
+func dropPrivileges(process *spec.Process, execUser *users.ExecUser) error {
+	// dropping from the bounding set requires CAP_SETPCAP, so it must happen while still root
+	if err := capabilities.LimitBoundingSet(process.Capabilities); err != nil {
+		return err
+	}
+	// retain the permitted capabilities across the switch to a non root user
+	if err := capabilities.KeepOnSetuid(true); err != nil {
+		return fmt.Errorf("unable to keep capabilities on setuid: %s", err)
+	}
+
+	if err := users.SwitchUser(execUser); err != nil {
+		return err
+	}
+
+	if err := capabilities.Set(process.Capabilities); err != nil {
+		return fmt.Errorf("capset failed: %s", err)
+	}
+	// root gets its capabilities back on execve, other users only keep the ambient ones
+	if execUser.Uid != 0 {
+		if err := capabilities.RaiseAmbient(process.AmbientCapabilities); err != nil {
+			return err
+		}
+	}
+
+	if process.NoNewPrivileges {
+		if err := capabilities.SetNoNewPrivileges(); err != nil {
+			return fmt.Errorf("unable to set no_new_privs: %s", err)
+		}
+	}
+	return nil
+}
+
+// compileSeccompFilters compiles the seccomp profile of the workload, if any, against its capabilities
+// and, when auditing, the filter sending the audited syscalls to the parent
+func compileSeccompFilters(process *spec.Process) ([]unix.SockFilter, []unix.SockFilter, error) {
+	var profileFilter, auditFilter []unix.SockFilter
+	var err error
+
+	if process.Seccomp != nil {
+		if profileFilter, err = seccomp.Compile(process.Seccomp, process.Capabilities); err != nil {
+			return nil, nil, err
+		}
+	}
+	if process.SeccompAudit != nil {
+		if auditFilter, err = seccomp.Compile(process.SeccompAudit.NotifyProfile(), process.Capabilities); err != nil {
+			return nil, nil, err
+		}
+	}
+	return profileFilter, auditFilter, nil
+}
+
+// installSeccompFilters installs the given filters and hands the audit filter's notification fd over
+// to the parent. The kernel runs every filter and applies the action with the highest precedence, so
+// syscalls denied by the profile fail without being notified whatever the order. The audit filter is
+// installed last because the syscalls following it must not be audited until the parent listens.
+func installSeccompFilters(profileFilter, auditFilter []unix.SockFilter) error {
+	if profileFilter != nil {
+		if err := seccomp.Install(profileFilter); err != nil {
+			return err
+		}
+	}
+	if auditFilter == nil {
+		return nil
+	}
+
+	notifyFd, err := seccomp.InstallWithListener(auditFilter)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(notifyFd)
+
+	if err := command.SendFd(notifyFd); err != nil {
+		return fmt.Errorf("unable to send the seccomp notification fd to the parent: %s", err)
+	}
+	return nil
+}
+
+// restrictFilesystem applies the Landlock rules of the workload, if any
+func restrictFilesystem(rules []landlock.Rule) error {
+	if len(rules) == 0 {
+		return nil
+	}
+	return landlock.Restrict(rules)
+}
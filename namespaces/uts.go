@@ -0,0 +1,84 @@
+package namespaces
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"syscall"
+)
+
+const (
+	hostnamePath = "/etc/hostname"
+	hostsPath    = "/etc/hosts"
+	// maxHostnameLength is the maximum length of both the hostname and the domain name (HOST_NAME_MAX)
+	maxHostnameLength = 64
+)
+
+// hostnameRegexp matches RFC 1123 host names, made of dot separated labels
+var hostnameRegexp = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?)*$`)
+
+// ValidateHostname checks the given name can be used as hostname or domain name
+func ValidateHostname(name string) error {
+	if len(name) > maxHostnameLength {
+		return fmt.Errorf("'%s' is longer than %d characters", name, maxHostnameLength)
+	}
+	if !hostnameRegexp.MatchString(name) {
+		return fmt.Errorf("'%s' is not a valid RFC 1123 host name", name)
+	}
+	return nil
+}
+
+// SanitizeHostname turns name into a valid host name, replacing the characters RFC 1123 doesn't allow
+// with dashes and truncating it to the maximum length. It returns an empty string when nothing is left.
+func SanitizeHostname(name string) string {
+	var labels []string
+	for _, label := range strings.Split(name, ".") {
+		label = strings.Trim(strings.Map(func(r rune) rune {
+			if r < 0x80 && (r == '-' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+				return r
+			}
+			return '-'
+		}, label), "-")
+		if label != "" {
+			labels = append(labels, label)
+		}
+	}
+	hostname := strings.Join(labels, ".")
+	if len(hostname) > maxHostnameLength {
+		hostname = strings.TrimRight(hostname[:maxHostnameLength], "-.")
+	}
+	return hostname
+}
+
+// setupUTS sets the hostname and domain name of the container's UTS namespace and writes
+// the matching /etc/hostname and /etc/hosts files. It must be called after pivot_root.
+func setupUTS(hostname, domainname string) error {
+	if err := syscall.Sethostname([]byte(hostname)); err != nil {
+		return fmt.Errorf("sethostname failed: %s", err)
+	}
+	if domainname != "" {
+		if err := syscall.Setdomainname([]byte(domainname)); err != nil {
+			return fmt.Errorf("setdomainname failed: %s", err)
+		}
+	}
+
+	if err := os.WriteFile(hostnamePath, []byte(hostname+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(hostsPath, []byte(hostsFileContent(hostname, domainname)), 0644)
+}
+
+// hostsFileContent returns an /etc/hosts resolving localhost and the container's own names
+func hostsFileContent(hostname, domainname string) string {
+	names := hostname
+	if domainname != "" {
+		names = hostname + "." + domainname + " " + hostname
+	}
+
+	sb := strings.Builder{}
+	sb.WriteString("127.0.0.1\tlocalhost\n")
+	sb.WriteString("::1\tlocalhost ip6-localhost ip6-loopback\n")
+	sb.WriteString(fmt.Sprintf("127.0.1.1\t%s\n", names))
+	return sb.String()
+}
@@ -0,0 +1,350 @@
+// Package registry is a client of the OCI distribution API, the HTTP API registries serve images with
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// dockerHubDomain is the domain of Docker Hub references, served by dockerHubRegistry
+	dockerHubDomain   = "docker.io"
+	dockerHubRegistry = "registry-1.docker.io"
+)
+
+var (
+	// ErrNotFound is returned when the registry doesn't have the requested manifest or blob
+	ErrNotFound = errors.New("not found in the registry")
+	// ErrUnauthorized is returned when the registry denies access to a repository
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrServer is returned when the registry fails to handle a request, which may succeed if retried
+	ErrServer = errors.New("registry server error")
+)
+
+// Credentials authenticate a user to a registry
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// Client talks to registries through the OCI distribution API, authenticating with the Docker
+// token flow or basic authentication as requested by each registry
+type Client struct {
+	// HTTP performs the requests
+	HTTP *http.Client
+	// Credentials returns the credentials of the registry at domain, if any
+	Credentials func(domain string) (*Credentials, error)
+	// PlainHTTP reports whether the registry at domain is reached without TLS
+	PlainHTTP func(domain string) bool
+
+	mu sync.Mutex
+	// authorizations caches the Authorization header of each domain and scope
+	authorizations map[string]string
+}
+
+// NewClient returns a client authenticating with the credentials stored by docker login, which
+// reaches the registries on the loopback interface without TLS
+func NewClient() *Client {
+	return &Client{
+		HTTP:        &http.Client{Timeout: 30 * time.Minute},
+		Credentials: DockerCredentials,
+		PlainHTTP:   IsLoopback,
+	}
+}
+
+// IsLoopback reports whether the domain is localhost or a loopback address, with or without a port
+func IsLoopback(domain string) bool {
+	host := domain
+	if h, _, err := net.SplitHostPort(domain); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && ip.IsLoopback()
+}
+
+// Manifest fetches the manifest, or image index, with the given tag or digest and returns its content
+// and media type, accepting the given media types. The content isn't verified against its digest.
+func (c *Client) Manifest(domain, repository, reference string, accept []string) ([]byte, string, error) {
+	req, err := c.newRequest(http.MethodGet, domain, fmt.Sprintf("/v2/%s/manifests/%s", repository, reference), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Accept", strings.Join(accept, ", "))
+
+	resp, err := c.do(req, domain, scope(repository, "pull"))
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", responseError(resp, "manifest "+reference)
+	}
+
+	// manifests are small, unlike blobs: a limit protects from misbehaving registries
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(content) > maxManifestSize {
+		return nil, "", fmt.Errorf("manifest %s exceeds %d bytes", reference, maxManifestSize)
+	}
+	mediaType, _, _ := strings.Cut(resp.Header.Get("Content-Type"), ";")
+	return content, strings.TrimSpace(mediaType), nil
+}
+
+// maxManifestSize is the largest manifest accepted, as in the distribution reference implementation
+const maxManifestSize = 4 << 20
+
+// Blob fetches the blob with the given digest, starting from offset when the registry supports range
+// requests, and returns its content and the offset the content actually starts from
+func (c *Client) Blob(domain, repository, digest string, offset int64) (io.ReadCloser, int64, error) {
+	req, err := c.newRequest(http.MethodGet, domain, fmt.Sprintf("/v2/%s/blobs/%s", repository, digest), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := c.do(req, domain, scope(repository, "pull"))
+	if err != nil {
+		return nil, 0, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, 0, nil
+	case http.StatusPartialContent:
+		return resp.Body, offset, nil
+	default:
+		defer resp.Body.Close()
+		return nil, 0, responseError(resp, "blob "+digest)
+	}
+}
+
+func (c *Client) newRequest(method, domain, path string, body io.Reader) (*http.Request, error) {
+	scheme := "https"
+	if c.PlainHTTP != nil && c.PlainHTTP(domain) {
+		scheme = "http"
+	}
+	host := domain
+	if domain == dockerHubDomain {
+		host = dockerHubRegistry
+	}
+	return http.NewRequest(method, scheme+"://"+host+path, body)
+}
+
+// scope returns the scope of a token granting the actions, such as pull or pull,push, on the repository
+func scope(repository, actions string) string {
+	return fmt.Sprintf("repository:%s:%s", repository, actions)
+}
+
+// do sends the request, authenticating with the registry when it asks to: the authorization is cached,
+// so that the following requests needing the same scopes don't need another round trip
+func (c *Client) do(req *http.Request, domain string, scopes ...string) (*http.Response, error) {
+	key := domain + " " + strings.Join(scopes, " ")
+
+	c.mu.Lock()
+	authorization := c.authorizations[key]
+	c.mu.Unlock()
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+	if challenge == "" {
+		return nil, fmt.Errorf("%w: %s", ErrUnauthorized, strings.Join(scopes, " "))
+	}
+
+	authorization, err = c.authorize(domain, scopes, challenge)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	if c.authorizations == nil {
+		c.authorizations = map[string]string{}
+	}
+	c.authorizations[key] = authorization
+	c.mu.Unlock()
+
+	// the body of the request has been consumed
+	if req.Body != nil {
+		if req.GetBody == nil {
+			return nil, fmt.Errorf("can't retry %s %s after authenticating", req.Method, req.URL.Path)
+		}
+		if req.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	req.Header.Set("Authorization", authorization)
+	resp, err = c.HTTP.Do(req)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %s", ErrUnauthorized, strings.Join(scopes, " "))
+	}
+	return resp, err
+}
+
+// authorize returns the Authorization header answering the challenge of the WWW-Authenticate header
+func (c *Client) authorize(domain string, scopes []string, challenge string) (string, error) {
+	scheme, params := parseChallenge(challenge)
+
+	var credentials *Credentials
+	if c.Credentials != nil {
+		var err error
+		if credentials, err = c.Credentials(domain); err != nil {
+			return "", err
+		}
+	}
+
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if credentials == nil {
+			return "", fmt.Errorf("%w: the registry %s requires credentials", ErrUnauthorized, domain)
+		}
+		return "Basic " + basicAuth(credentials), nil
+	case "bearer":
+		token, err := c.fetchToken(params, scopes, credentials)
+		if err != nil {
+			return "", fmt.Errorf("authenticating to %s: %w", domain, err)
+		}
+		return "Bearer " + token, nil
+	default:
+		return "", fmt.Errorf("unsupported authentication scheme '%s' of registry %s", scheme, domain)
+	}
+}
+
+// fetchToken requests a token for the scopes to the authorization server named in the challenge
+func (c *Client) fetchToken(params map[string]string, scopes []string, credentials *Credentials) (string, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Host == "" {
+		return "", fmt.Errorf("invalid realm '%s' in the authentication challenge", params["realm"])
+	}
+	query := realm.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	for _, scope := range scopes {
+		query.Add("scope", scope)
+	}
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if credentials != nil {
+		req.SetBasicAuth(credentials.Username, credentials.Password)
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", responseError(resp, "token")
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("invalid token response: %w", err)
+	}
+	if token.Token != "" {
+		return token.Token, nil
+	}
+	if token.AccessToken != "" {
+		return token.AccessToken, nil
+	}
+	return "", errors.New("the token response holds no token")
+}
+
+// parseChallenge parses a WWW-Authenticate header such as
+// Bearer realm="https://auth.example.com/token",service="registry.example.com"
+func parseChallenge(header string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	params := map[string]string{}
+	for rest = strings.TrimSpace(rest); rest != ""; rest = strings.TrimLeft(rest, ", ") {
+		key, value, found := strings.Cut(rest, "=")
+		if !found {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		if strings.HasPrefix(value, `"`) {
+			// quoted values may hold commas, and escaped quotes
+			var b strings.Builder
+			i := 1
+			for ; i < len(value) && value[i] != '"'; i++ {
+				if value[i] == '\\' && i+1 < len(value) {
+					i++
+				}
+				b.WriteByte(value[i])
+			}
+			params[key] = b.String()
+			if i < len(value) {
+				i++
+			}
+			rest = value[i:]
+			continue
+		}
+		params[key], rest, _ = strings.Cut(value, ",")
+	}
+	return scheme, params
+}
+
+// responseError returns the error described by an unexpected response, including the
+// errors listed in the body as defined by the distribution API
+func responseError(resp *http.Response, what string) error {
+	var body struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	content, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	details := ""
+	if json.Unmarshal(content, &body) == nil && len(body.Errors) > 0 {
+		var messages []string
+		for _, e := range body.Errors {
+			messages = append(messages, fmt.Sprintf("%s: %s", e.Code, e.Message))
+		}
+		details = " (" + strings.Join(messages, "; ") + ")"
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return fmt.Errorf("%w: %s%s", ErrNotFound, what, details)
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("%w: %s%s", ErrUnauthorized, what, details)
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return fmt.Errorf("%w: %s: %s%s", ErrServer, what, resp.Status, details)
+	default:
+		return fmt.Errorf("unexpected response to the request of %s: %s%s", what, resp.Status, details)
+	}
+}
+
+// Temporary reports whether a request failed because of the network, or of the registry server,
+// so that it may succeed if retried
+func Temporary(err error) bool {
+	var opErr *net.OpError
+	var urlErr *url.Error
+	return errors.Is(err, ErrServer) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) ||
+		errors.As(err, &opErr) || (errors.As(err, &urlErr) && urlErr.Timeout())
+}
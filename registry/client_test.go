@@ -0,0 +1,196 @@
+package registry
+
+import (
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/NamelessOne91/coso/registry/registrytest"
+)
+
+var _ = Describe("Client", func() {
+
+	var (
+		server *registrytest.Server
+		client *Client
+	)
+
+	BeforeEach(func() {
+		server = registrytest.NewServer()
+		client = NewClient()
+		client.Credentials = nil
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("fetches manifests with their media type", func() {
+		digest := server.AddManifest("team/app", "1.0", "application/vnd.oci.image.manifest.v1+json", []byte(`{"schemaVersion":2}`))
+
+		for _, reference := range []string{"1.0", digest} {
+			content, mediaType, err := client.Manifest(server.Domain, "team/app", reference, []string{"application/vnd.oci.image.manifest.v1+json"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal(`{"schemaVersion":2}`))
+			Expect(mediaType).To(Equal("application/vnd.oci.image.manifest.v1+json"))
+		}
+
+		_, _, err := client.Manifest(server.Domain, "team/app", "2.0", nil)
+		Expect(err).To(MatchError(ErrNotFound))
+		Expect(err).To(MatchError(ContainSubstring("MANIFEST_UNKNOWN")))
+	})
+
+	It("fetches blobs from an offset", func() {
+		digest := server.AddBlob([]byte("0123456789"))
+
+		body, offset, err := client.Blob(server.Domain, "app", digest, 4)
+		Expect(err).NotTo(HaveOccurred())
+		defer body.Close()
+		Expect(offset).To(Equal(int64(4)))
+		Expect(io.ReadAll(body)).To(Equal([]byte("456789")))
+		Expect(server.Ranges()).To(Equal([]string{"bytes=4-"}))
+	})
+
+	It("starts over when the registry ignores the range", func() {
+		registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("0123456789"))
+		}))
+		defer registry.Close()
+
+		body, offset, err := client.Blob(strings.TrimPrefix(registry.URL, "http://"), "app", "sha256:0", 4)
+		Expect(err).NotTo(HaveOccurred())
+		defer body.Close()
+		Expect(offset).To(BeZero())
+		Expect(io.ReadAll(body)).To(Equal([]byte("0123456789")))
+	})
+
+	It("reports server failures as temporary", func() {
+		registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer registry.Close()
+
+		_, _, err := client.Blob(strings.TrimPrefix(registry.URL, "http://"), "app", "sha256:0", 0)
+		Expect(err).To(MatchError(ErrServer))
+		Expect(Temporary(err)).To(BeTrue())
+
+		_, _, err = client.Blob(server.Domain, "app", "sha256:0", 0)
+		Expect(err).To(MatchError(ErrNotFound))
+		Expect(Temporary(err)).To(BeFalse())
+
+		registry.Close()
+		_, _, err = client.Blob(strings.TrimPrefix(registry.URL, "http://"), "app", "sha256:0", 0)
+		Expect(Temporary(err)).To(BeTrue())
+	})
+
+	It("authenticates with tokens", func() {
+		server.Username, server.Password = "user", "secret"
+		digest := server.AddBlob([]byte("content"))
+
+		_, _, err := client.Blob(server.Domain, "app", digest, 0)
+		Expect(err).To(MatchError(ErrUnauthorized))
+
+		client.Credentials = func(string) (*Credentials, error) {
+			return &Credentials{Username: "user", Password: "secret"}, nil
+		}
+		body, _, err := client.Blob(server.Domain, "app", digest, 0)
+		Expect(err).NotTo(HaveOccurred())
+		body.Close()
+		Expect(client.authorizations).To(HaveKey(server.Domain + " repository:app:pull"))
+	})
+
+	It("authenticates with basic authentication", func() {
+		registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if username, password, _ := r.BasicAuth(); username != "user" || password != "secret" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte("content"))
+		}))
+		defer registry.Close()
+		domain := strings.TrimPrefix(registry.URL, "http://")
+
+		_, _, err := client.Blob(domain, "app", "sha256:0", 0)
+		Expect(err).To(MatchError(ContainSubstring("requires credentials")))
+
+		client.Credentials = func(string) (*Credentials, error) {
+			return &Credentials{Username: "user", Password: "secret"}, nil
+		}
+		body, _, err := client.Blob(domain, "app", "sha256:0", 0)
+		Expect(err).NotTo(HaveOccurred())
+		defer body.Close()
+		Expect(io.ReadAll(body)).To(Equal([]byte("content")))
+	})
+
+	DescribeTable("parses authentication challenges",
+		func(header, scheme string, params map[string]string) {
+			s, p := parseChallenge(header)
+			Expect(s).To(Equal(scheme))
+			Expect(p).To(Equal(params))
+		},
+		Entry("bearer", `Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`, "Bearer",
+			map[string]string{"realm": "https://auth.docker.io/token", "service": "registry.docker.io"}),
+		Entry("quoted commas and escapes", `Bearer realm="https://auth/token",scope="repository:a:pull,push",error="say \"hi\""`, "Bearer",
+			map[string]string{"realm": "https://auth/token", "scope": "repository:a:pull,push", "error": `say "hi"`}),
+		Entry("unquoted values", `Basic realm=registry, charset=UTF-8`, "Basic",
+			map[string]string{"realm": "registry", "charset": "UTF-8"}),
+		Entry("no parameters", `Basic`, "Basic", map[string]string{}),
+	)
+
+	DescribeTable("reaches loopback registries without TLS",
+		func(domain string, loopback bool) {
+			Expect(IsLoopback(domain)).To(Equal(loopback))
+		},
+		Entry("localhost", "localhost", true),
+		Entry("localhost with port", "localhost:5000", true),
+		Entry("IPv4", "127.0.0.1:5000", true),
+		Entry("IPv6", "[::1]:5000", true),
+		Entry("remote", "registry.example.com:5000", false),
+		Entry("docker hub", "docker.io", false),
+	)
+})
+
+var _ = Describe("DockerCredentials", func() {
+
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "coso-registry")
+		Expect(err).NotTo(HaveOccurred())
+		os.Setenv("DOCKER_CONFIG", dir)
+	})
+
+	AfterEach(func() {
+		os.Unsetenv("DOCKER_CONFIG")
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("reads the credentials stored by docker login", func() {
+		auth := func(credentials string) string {
+			return base64.StdEncoding.EncodeToString([]byte(credentials))
+		}
+		config := `{"auths": {
+			"https://index.docker.io/v1/": {"auth": "` + auth("hub:hubpass") + `"},
+			"https://registry.example.com/v2/": {"auth": "` + auth("user:pass:word") + `"}
+		}}`
+		Expect(os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0600)).To(Succeed())
+
+		Expect(DockerCredentials("docker.io")).To(Equal(&Credentials{Username: "hub", Password: "hubpass"}))
+		Expect(DockerCredentials("registry.example.com")).To(Equal(&Credentials{Username: "user", Password: "pass:word"}))
+		Expect(DockerCredentials("other.example.com")).To(BeNil())
+	})
+
+	It("returns no credentials without a configuration", func() {
+		Expect(DockerCredentials("docker.io")).To(BeNil())
+	})
+})
@@ -0,0 +1,74 @@
+package registry
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// dockerHubAuthKey is the key docker login stores the Docker Hub credentials under
+const dockerHubAuthKey = "https://index.docker.io/v1/"
+
+// DockerCredentials returns the credentials of the registry at domain stored by docker login in
+// $DOCKER_CONFIG/config.json, or ~/.docker/config.json. Credentials kept by credential helpers
+// aren't supported.
+func DockerCredentials(domain string) (*Credentials, error) {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var config struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}
+	if err := json.Unmarshal(content, &config); err != nil {
+		return nil, fmt.Errorf("invalid docker configuration: %w", err)
+	}
+
+	for key, entry := range config.Auths {
+		if !matchesDomain(key, domain) || entry.Auth == "" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+		if err != nil {
+			return nil, fmt.Errorf("invalid credentials of %s in the docker configuration: %w", key, err)
+		}
+		username, password, found := strings.Cut(string(decoded), ":")
+		if !found {
+			return nil, fmt.Errorf("invalid credentials of %s in the docker configuration", key)
+		}
+		return &Credentials{Username: username, Password: password}, nil
+	}
+	return nil, nil
+}
+
+// matchesDomain reports whether the key of the auths section of the docker configuration, a domain
+// or a URL, refers to the registry at domain
+func matchesDomain(key, domain string) bool {
+	if domain == dockerHubDomain {
+		return key == dockerHubAuthKey || key == dockerHubDomain || key == dockerHubRegistry
+	}
+	key = strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+	key, _, _ = strings.Cut(key, "/")
+	return key == domain
+}
+
+func basicAuth(credentials *Credentials) string {
+	return base64.StdEncoding.EncodeToString([]byte(credentials.Username + ":" + credentials.Password))
+}
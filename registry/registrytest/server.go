@@ -0,0 +1,423 @@
+// Package registrytest provides an in memory registry serving the OCI distribution API,
+// to test registry clients without network access
+package registrytest
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Server is a registry keeping blobs and manifests in memory. Blobs added with AddBlob are available
+// in every repository, while uploaded blobs only in the repositories they were uploaded or mounted to.
+type Server struct {
+	*httptest.Server
+	// Domain is the host and port references to the server use
+	Domain string
+	// Username and Password, when set, are required to get the tokens the API requires
+	Username string
+	Password string
+
+	mu    sync.Mutex
+	blobs map[string][]byte
+	// shared are the digests of the blobs available in every repository
+	shared map[string]bool
+	// linked are the uploaded blobs, in the repository@digest format
+	linked    map[string]bool
+	manifests map[string]manifest
+	tokens    map[string]bool
+	// sessions are the blob uploads in progress, by ID
+	sessions map[string]*session
+	uploads  []Upload
+	// interrupted maps the digests of the blobs whose next download is interrupted to the bytes sent before
+	interrupted map[string]int
+	// ranges are the Range headers of the blob requests
+	ranges []string
+}
+
+type manifest struct {
+	mediaType string
+	content   []byte
+}
+
+// Upload describes how a blob was added to a repository
+type Upload struct {
+	Repository string
+	Digest     string
+	// Chunks is the number of PATCH requests the content was sent with, 0 for monolithic uploads
+	Chunks int
+	// MountedFrom is the repository the blob was mounted from, empty unless mounted
+	MountedFrom string
+}
+
+// session is a blob upload in progress
+type session struct {
+	repository string
+	content    []byte
+	chunks     int
+}
+
+// NewServer starts a registry, which must be closed once done
+func NewServer() *Server {
+	s := &Server{
+		blobs:       map[string][]byte{},
+		shared:      map[string]bool{},
+		linked:      map[string]bool{},
+		manifests:   map[string]manifest{},
+		tokens:      map[string]bool{},
+		sessions:    map[string]*session{},
+		interrupted: map[string]int{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	s.Domain = strings.TrimPrefix(s.URL, "http://")
+	return s
+}
+
+// Digest returns the digest of content, as the registry computes it
+func Digest(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// AddBlob stores the blob and returns its digest
+func (s *Server) AddBlob(content []byte) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	digest := Digest(content)
+	s.blobs[digest] = content
+	s.shared[digest] = true
+	return digest
+}
+
+// AddBlobAs stores the blob under the given digest, even if not its own, to test digest verification
+func (s *Server) AddBlobAs(digest string, content []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blobs[digest] = content
+	s.shared[digest] = true
+}
+
+// AddManifest stores the manifest in the repository, under its digest and the given tag if not empty,
+// and returns its digest
+func (s *Server) AddManifest(repository, tag, mediaType string, content []byte) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	digest := Digest(content)
+	s.manifests[repository+"@"+digest] = manifest{mediaType, content}
+	if tag != "" {
+		s.manifests[repository+":"+tag] = manifest{mediaType, content}
+	}
+	return digest
+}
+
+// HasBlob reports whether the blob is available in the repository
+func (s *Server) HasBlob(repository, digest string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hasBlob(repository, digest)
+}
+
+func (s *Server) hasBlob(repository, digest string) bool {
+	_, found := s.blobs[digest]
+	return found && (s.shared[digest] || s.linked[repository+"@"+digest])
+}
+
+// Manifest returns the media type and the content of the manifest with the given tag or digest
+func (s *Server) Manifest(repository, reference string) (string, []byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, found := s.manifests[manifestKey(repository, reference)]
+	return m.mediaType, m.content, found
+}
+
+// manifestKey returns the key of the manifest with the given tag or digest
+func manifestKey(repository, reference string) string {
+	if strings.Contains(reference, ":") {
+		return repository + "@" + reference
+	}
+	return repository + ":" + reference
+}
+
+// Uploads returns the blobs uploaded, or mounted, so far
+func (s *Server) Uploads() []Upload {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Upload(nil), s.uploads...)
+}
+
+// Interrupt makes the next download of the blob stop after the given number of bytes
+func (s *Server) Interrupt(digest string, after int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.interrupted[digest] = after
+}
+
+// Ranges returns the Range headers of the blob requests received so far, empty for full downloads
+func (s *Server) Ranges() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.ranges...)
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/token" {
+		s.serveToken(w, r)
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, "/v2/") {
+		http.NotFound(w, r)
+		return
+	}
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registrytest"`, s.URL))
+		writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "authentication required")
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/v2/")
+	if path == "" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if i := strings.LastIndex(path, "/manifests/"); i > 0 {
+		if r.Method == http.MethodPut {
+			s.putManifest(w, r, path[:i], path[i+len("/manifests/"):])
+			return
+		}
+		s.serveManifest(w, r, path[:i], path[i+len("/manifests/"):])
+		return
+	}
+	if i := strings.LastIndex(path, "/blobs/uploads/"); i > 0 {
+		s.serveUpload(w, r, path[:i], path[i+len("/blobs/uploads/"):])
+		return
+	}
+	if i := strings.LastIndex(path, "/blobs/"); i > 0 {
+		s.serveBlob(w, r, path[:i], path[i+len("/blobs/"):])
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// authorized reports whether the request holds a token issued by the server, when credentials are required
+func (s *Server) authorized(r *http.Request) bool {
+	if s.Username == "" {
+		return true
+	}
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return found && s.tokens[token]
+}
+
+func (s *Server) serveToken(w http.ResponseWriter, r *http.Request) {
+	username, password, _ := r.BasicAuth()
+	if s.Username != "" && (username != s.Username || password != s.Password) {
+		writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "invalid credentials")
+		return
+	}
+
+	id := make([]byte, 16)
+	rand.Read(id)
+	token := hex.EncodeToString(id)
+	s.mu.Lock()
+	s.tokens[token] = true
+	s.mu.Unlock()
+	json.NewEncoder(w).Encode(map[string]string{"token": token})
+}
+
+func (s *Server) serveManifest(w http.ResponseWriter, r *http.Request, repository, reference string) {
+	s.mu.Lock()
+	m, found := s.manifests[manifestKey(repository, reference)]
+	s.mu.Unlock()
+	if !found {
+		writeError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown")
+		return
+	}
+
+	w.Header().Set("Content-Type", m.mediaType)
+	w.Header().Set("Docker-Content-Digest", Digest(m.content))
+	w.Header().Set("Content-Length", strconv.Itoa(len(m.content)))
+	if r.Method == http.MethodGet {
+		w.Write(m.content)
+	}
+}
+
+func (s *Server) serveBlob(w http.ResponseWriter, r *http.Request, repository, digest string) {
+	s.mu.Lock()
+	content, found := s.blobs[digest], s.hasBlob(repository, digest)
+	after, interrupt := s.interrupted[digest]
+	if r.Method == http.MethodGet {
+		delete(s.interrupted, digest)
+		s.ranges = append(s.ranges, r.Header.Get("Range"))
+	}
+	s.mu.Unlock()
+	if !found {
+		writeError(w, http.StatusNotFound, "BLOB_UNKNOWN", "blob unknown")
+		return
+	}
+
+	status := http.StatusOK
+	if start, found := strings.CutPrefix(r.Header.Get("Range"), "bytes="); found {
+		offset, err := strconv.Atoi(strings.TrimSuffix(start, "-"))
+		if err != nil || offset >= len(content) {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, len(content)-1, len(content)))
+		content, status = content[offset:], http.StatusPartialContent
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.WriteHeader(status)
+	if r.Method != http.MethodGet {
+		return
+	}
+	if interrupt && after < len(content) {
+		// the connection is closed short of the announced length
+		w.Write(content[:after])
+		return
+	}
+	w.Write(content)
+}
+
+// putManifest stores the manifest pushed, once all the blobs and manifests it references are in the repository
+func (s *Server) putManifest(w http.ResponseWriter, r *http.Request, repository, reference string) {
+	content, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "MANIFEST_INVALID", err.Error())
+		return
+	}
+	var references struct {
+		Config    *struct{ Digest string }  `json:"config"`
+		Layers    []struct{ Digest string } `json:"layers"`
+		Manifests []struct{ Digest string } `json:"manifests"`
+	}
+	if err := json.Unmarshal(content, &references); err != nil {
+		writeError(w, http.StatusBadRequest, "MANIFEST_INVALID", err.Error())
+		return
+	}
+	digest := Digest(content)
+	if strings.Contains(reference, ":") && reference != digest {
+		writeError(w, http.StatusBadRequest, "DIGEST_INVALID", "the manifest doesn't match the digest")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	blobs := references.Layers
+	if references.Config != nil {
+		blobs = append(blobs, *references.Config)
+	}
+	for _, blob := range blobs {
+		if !s.hasBlob(repository, blob.Digest) {
+			writeError(w, http.StatusBadRequest, "MANIFEST_BLOB_UNKNOWN", "blob unknown: "+blob.Digest)
+			return
+		}
+	}
+	for _, m := range references.Manifests {
+		if _, found := s.manifests[repository+"@"+m.Digest]; !found {
+			writeError(w, http.StatusBadRequest, "MANIFEST_UNKNOWN", "manifest unknown: "+m.Digest)
+			return
+		}
+	}
+
+	m := manifest{r.Header.Get("Content-Type"), content}
+	s.manifests[repository+"@"+digest] = m
+	s.manifests[manifestKey(repository, reference)] = m
+	w.Header().Set("Location", fmt.Sprintf("/v2/%s/manifests/%s", repository, digest))
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// serveUpload handles the requests of the blob uploads: POST starts an upload, or mounts a blob from
+// another repository, PATCH sends a chunk, PUT completes the upload, optionally sending the last chunk,
+// and DELETE cancels it
+func (s *Server) serveUpload(w http.ResponseWriter, r *http.Request, repository, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.Method == http.MethodPost {
+		query := r.URL.Query()
+		if digest, from := query.Get("mount"), query.Get("from"); digest != "" && from != "" && s.hasBlob(from, digest) {
+			s.linked[repository+"@"+digest] = true
+			s.uploads = append(s.uploads, Upload{Repository: repository, Digest: digest, MountedFrom: from})
+			w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", repository, digest))
+			w.Header().Set("Docker-Content-Digest", digest)
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		uuid := make([]byte, 16)
+		rand.Read(uuid)
+		id = hex.EncodeToString(uuid)
+		s.sessions[id] = &session{repository: repository}
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", repository, id))
+		w.Header().Set("Docker-Upload-UUID", id)
+		w.Header().Set("Range", "0-0")
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	upload, found := s.sessions[id]
+	if !found || upload.repository != repository {
+		writeError(w, http.StatusNotFound, "BLOB_UPLOAD_UNKNOWN", "blob upload unknown")
+		return
+	}
+	content, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "BLOB_UPLOAD_INVALID", err.Error())
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPatch:
+		if contentRange := r.Header.Get("Content-Range"); contentRange != "" {
+			start, _, _ := strings.Cut(contentRange, "-")
+			if start != strconv.Itoa(len(upload.content)) {
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+		}
+		upload.content = append(upload.content, content...)
+		upload.chunks++
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", repository, id))
+		w.Header().Set("Range", fmt.Sprintf("0-%d", len(upload.content)-1))
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodPut:
+		upload.content = append(upload.content, content...)
+		digest := r.URL.Query().Get("digest")
+		if Digest(upload.content) != digest {
+			writeError(w, http.StatusBadRequest, "DIGEST_INVALID", "the content doesn't match the digest")
+			return
+		}
+		delete(s.sessions, id)
+		s.blobs[digest] = upload.content
+		s.linked[repository+"@"+digest] = true
+		s.uploads = append(s.uploads, Upload{Repository: repository, Digest: digest, Chunks: upload.chunks})
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", repository, digest))
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		delete(s.sessions, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []map[string]string{{"code": code, "message": message}},
+	})
+}
@@ -0,0 +1,173 @@
+package registry
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// BlobExists reports whether the repository holds the blob with the given digest
+func (c *Client) BlobExists(domain, repository, digest string) (bool, error) {
+	req, err := c.newRequest(http.MethodHead, domain, fmt.Sprintf("/v2/%s/blobs/%s", repository, digest), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := c.do(req, domain, scope(repository, "pull,push"))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, responseError(resp, "blob "+digest)
+	}
+}
+
+// MountBlob adds the blob with the given digest, held by the repository from of the same registry,
+// to the repository without uploading it again, and reports whether the registry did so
+func (c *Client) MountBlob(domain, repository, from, digest string) (bool, error) {
+	query := url.Values{"mount": {digest}, "from": {from}}
+	req, err := c.newRequest(http.MethodPost, domain, fmt.Sprintf("/v2/%s/blobs/uploads/?%s", repository, query.Encode()), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := c.do(req, domain, scope(repository, "pull,push"), scope(from, "pull"))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		return true, nil
+	case http.StatusAccepted:
+		// the registry started a regular upload instead, which isn't needed
+		if location, err := uploadLocation(req.URL, resp); err == nil {
+			c.cancelUpload(domain, repository, location)
+		}
+		return false, nil
+	default:
+		return false, responseError(resp, "mount of blob "+digest)
+	}
+}
+
+// UploadBlob uploads the blob of the given size and digest, read from content, to the repository.
+// Blobs larger than a positive chunkSize are sent in chunks of that size, while the others are sent
+// with a single request.
+func (c *Client) UploadBlob(domain, repository, digest string, content io.ReaderAt, size, chunkSize int64) error {
+	req, err := c.newRequest(http.MethodPost, domain, fmt.Sprintf("/v2/%s/blobs/uploads/", repository), nil)
+	if err != nil {
+		return err
+	}
+	location, err := c.uploadStep(req, domain, repository, http.StatusAccepted)
+	if err != nil {
+		return err
+	}
+
+	var offset int64
+	if chunkSize > 0 && size > chunkSize {
+		chunk := make([]byte, chunkSize)
+		for offset < size {
+			if remaining := size - offset; remaining < chunkSize {
+				chunk = chunk[:remaining]
+			}
+			if n, err := content.ReadAt(chunk, offset); n < len(chunk) {
+				return fmt.Errorf("reading blob %s: %w", digest, err)
+			}
+			req, err := http.NewRequest(http.MethodPatch, location.String(), bytes.NewReader(chunk))
+			if err != nil {
+				return err
+			}
+			req.Header.Set("Content-Type", "application/octet-stream")
+			req.Header.Set("Content-Range", fmt.Sprintf("%d-%d", offset, offset+int64(len(chunk))-1))
+			if location, err = c.uploadStep(req, domain, repository, http.StatusAccepted); err != nil {
+				return err
+			}
+			offset += int64(len(chunk))
+		}
+	}
+
+	// the upload completes with the last request, which sends the whole blob for monolithic uploads
+	query := location.Query()
+	query.Set("digest", digest)
+	location.RawQuery = query.Encode()
+	req, err = http.NewRequest(http.MethodPut, location.String(), http.NoBody)
+	if err != nil {
+		return err
+	}
+	if offset < size {
+		req.Body = io.NopCloser(io.NewSectionReader(content, offset, size-offset))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(io.NewSectionReader(content, offset, size-offset)), nil
+		}
+		req.ContentLength = size - offset
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	_, err = c.uploadStep(req, domain, repository, http.StatusCreated)
+	return err
+}
+
+// uploadStep sends a request of a blob upload and returns the location the next one is sent to
+func (c *Client) uploadStep(req *http.Request, domain, repository string, status int) (*url.URL, error) {
+	resp, err := c.do(req, domain, scope(repository, "pull,push"))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != status {
+		if req.Method != http.MethodPost {
+			c.cancelUpload(domain, repository, req.URL)
+		}
+		return nil, responseError(resp, "upload to "+repository)
+	}
+	io.Copy(io.Discard, resp.Body)
+	if status == http.StatusCreated {
+		return nil, nil
+	}
+	return uploadLocation(req.URL, resp)
+}
+
+// uploadLocation returns the location of the upload named in the response, which may be relative to the request
+func uploadLocation(base *url.URL, resp *http.Response) (*url.URL, error) {
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return nil, fmt.Errorf("the registry answered %s without the location of the upload", resp.Status)
+	}
+	return base.Parse(location)
+}
+
+// cancelUpload discards the upload in progress at location, if the registry allows it
+func (c *Client) cancelUpload(domain, repository string, location *url.URL) {
+	req, err := http.NewRequest(http.MethodDelete, location.String(), nil)
+	if err != nil {
+		return
+	}
+	if resp, err := c.do(req, domain, scope(repository, "pull,push")); err == nil {
+		resp.Body.Close()
+	}
+}
+
+// PutManifest uploads the manifest, or image index, with the given media type to the repository under
+// reference, a tag or its digest, and returns its digest as computed by the registry
+func (c *Client) PutManifest(domain, repository, reference, mediaType string, content []byte) (string, error) {
+	req, err := c.newRequest(http.MethodPut, domain, fmt.Sprintf("/v2/%s/manifests/%s", repository, reference), bytes.NewReader(content))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", mediaType)
+
+	resp, err := c.do(req, domain, scope(repository, "pull,push"))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return "", responseError(resp, "manifest "+reference)
+	}
+	return resp.Header.Get("Docker-Content-Digest"), nil
+}
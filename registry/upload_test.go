@@ -0,0 +1,105 @@
+package registry
+
+import (
+	"bytes"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/NamelessOne91/coso/registry/registrytest"
+)
+
+var _ = Describe("Upload", func() {
+
+	var (
+		server *registrytest.Server
+		client *Client
+	)
+
+	BeforeEach(func() {
+		server = registrytest.NewServer()
+		client = NewClient()
+		client.Credentials = nil
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	DescribeTable("uploads blobs",
+		func(size, chunkSize int64, chunks int) {
+			content := bytes.Repeat([]byte("0123456789"), 10)[:size]
+			digest := registrytest.Digest(content)
+
+			Expect(client.BlobExists(server.Domain, "app", digest)).To(BeFalse())
+			Expect(client.UploadBlob(server.Domain, "app", digest, bytes.NewReader(content), size, chunkSize)).To(Succeed())
+			Expect(client.BlobExists(server.Domain, "app", digest)).To(BeTrue())
+			Expect(server.Uploads()).To(Equal([]registrytest.Upload{{Repository: "app", Digest: digest, Chunks: chunks}}))
+
+			body, _, err := client.Blob(server.Domain, "app", digest, 0)
+			Expect(err).NotTo(HaveOccurred())
+			defer body.Close()
+			var buf bytes.Buffer
+			_, err = buf.ReadFrom(body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(buf.Bytes()).To(Equal(content))
+		},
+		Entry("monolithic", int64(100), int64(0), 0),
+		Entry("smaller than a chunk", int64(100), int64(100), 0),
+		Entry("chunked", int64(100), int64(30), 4),
+		Entry("chunked in whole chunks", int64(100), int64(25), 4),
+		Entry("empty", int64(0), int64(0), 0),
+	)
+
+	It("rejects blobs not matching their digest", func() {
+		content := []byte("content")
+		err := client.UploadBlob(server.Domain, "app", registrytest.Digest([]byte("other")), bytes.NewReader(content), int64(len(content)), 0)
+		Expect(err).To(MatchError(ContainSubstring("DIGEST_INVALID")))
+		Expect(server.Uploads()).To(BeEmpty())
+	})
+
+	It("mounts blobs from other repositories", func() {
+		content := []byte("layer")
+		digest := registrytest.Digest(content)
+		Expect(client.UploadBlob(server.Domain, "base", digest, bytes.NewReader(content), int64(len(content)), 0)).To(Succeed())
+		Expect(server.HasBlob("app", digest)).To(BeFalse())
+
+		Expect(client.MountBlob(server.Domain, "app", "base", digest)).To(BeTrue())
+		Expect(server.HasBlob("app", digest)).To(BeTrue())
+		Expect(server.Uploads()).To(ContainElement(registrytest.Upload{Repository: "app", Digest: digest, MountedFrom: "base"}))
+
+		Expect(client.MountBlob(server.Domain, "other", "missing", digest)).To(BeFalse())
+		Expect(server.HasBlob("other", digest)).To(BeFalse())
+	})
+
+	It("pushes manifests once their blobs are in the repository", func() {
+		config := []byte("{}")
+		digest := registrytest.Digest(config)
+		manifest := []byte(fmt.Sprintf(`{"schemaVersion":2,"config":{"digest":"%s"},"layers":[]}`, digest))
+
+		_, err := client.PutManifest(server.Domain, "app", "1.0", "application/vnd.oci.image.manifest.v1+json", manifest)
+		Expect(err).To(MatchError(ContainSubstring("MANIFEST_BLOB_UNKNOWN")))
+
+		Expect(client.UploadBlob(server.Domain, "app", digest, bytes.NewReader(config), int64(len(config)), 0)).To(Succeed())
+		Expect(client.PutManifest(server.Domain, "app", "1.0", "application/vnd.oci.image.manifest.v1+json", manifest)).To(Equal(registrytest.Digest(manifest)))
+
+		content, mediaType, err := client.Manifest(server.Domain, "app", "1.0", nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(content).To(Equal(manifest))
+		Expect(mediaType).To(Equal("application/vnd.oci.image.manifest.v1+json"))
+	})
+
+	It("authenticates uploads", func() {
+		server.Username, server.Password = "user", "secret"
+		client.Credentials = func(string) (*Credentials, error) {
+			return &Credentials{Username: "user", Password: "secret"}, nil
+		}
+		content := bytes.Repeat([]byte("x"), 50)
+		digest := registrytest.Digest(content)
+
+		Expect(client.UploadBlob(server.Domain, "app", digest, bytes.NewReader(content), int64(len(content)), 20)).To(Succeed())
+		Expect(client.authorizations).To(HaveKey(server.Domain + " repository:app:pull,push"))
+	})
+})
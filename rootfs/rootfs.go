@@ -0,0 +1,211 @@
+// Package rootfs stores named root filesystems, imported from tar archives,
+// which containers can use as lower layer with --rootfs name
+package rootfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/NamelessOne91/coso/archive"
+)
+
+// DefaultStorePath holds the root filesystems imported by root
+const DefaultStorePath = "/var/lib/coso/rootfs"
+
+var (
+	// ErrNotFound is returned when a root filesystem doesn't exist
+	ErrNotFound = errors.New("no such root filesystem")
+	// ErrInUse is returned when removing a root filesystem a container runs on
+	ErrInUse = errors.New("root filesystem is in use")
+
+	validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+	// archiveExtensions are stripped from archive names to get the default root filesystem name
+	archiveExtensions = []string{".tar.gz", ".tgz", ".tar.xz", ".txz", ".tar.zst", ".tar.bz2", ".tar"}
+)
+
+// Store keeps each root filesystem in a directory named after it
+type Store struct {
+	path string
+}
+
+// NewStore returns a store keeping the root filesystems under path
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+// UserStorePath returns the store path of the calling user: DefaultStorePath for root,
+// a directory under $XDG_DATA_HOME, or ~/.local/share, otherwise
+func UserStorePath() (string, error) {
+	if os.Geteuid() == 0 {
+		return DefaultStorePath, nil
+	}
+	if dataHome := os.Getenv("XDG_DATA_HOME"); dataHome != "" {
+		return filepath.Join(dataHome, "coso", "rootfs"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share", "coso", "rootfs"), nil
+}
+
+// ValidateName checks name can be used as a root filesystem name
+func ValidateName(name string) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid root filesystem name '%s', only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name)
+	}
+	return nil
+}
+
+// NameFromArchive returns the default name of a root filesystem imported from the archive at path,
+// e.g. alpine-minirootfs for alpine-minirootfs.tar.gz
+func NameFromArchive(path string) string {
+	name := filepath.Base(path)
+	for _, ext := range archiveExtensions {
+		if trimmed, found := strings.CutSuffix(name, ext); found {
+			return trimmed
+		}
+	}
+	return name
+}
+
+// Path returns the path of the root filesystem with the given name
+func (s *Store) Path(name string) (string, error) {
+	if err := ValidateName(name); err != nil {
+		return "", err
+	}
+	path := filepath.Join(s.path, name)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	return path, nil
+}
+
+// Exists reports whether the root filesystem with the given name exists
+func (s *Store) Exists(name string) bool {
+	_, err := s.Path(name)
+	return err == nil
+}
+
+// Import extracts the tar archive at archivePath, compressed with gzip, bzip2, xz, zstd or not at all,
+// as the root filesystem with the given name
+func (s *Store) Import(name, archivePath string, opts archive.Options) (string, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	return s.ImportReader(name, f, opts)
+}
+
+// ImportReader extracts the tar archive read from r as the root filesystem with the given name.
+// The archive is extracted to a temporary directory first, so that a failed import never leaves
+// a partial root filesystem behind.
+func (s *Store) ImportReader(name string, r io.Reader, opts archive.Options) (string, error) {
+	if err := ValidateName(name); err != nil {
+		return "", err
+	}
+	path := filepath.Join(s.path, name)
+	if _, err := os.Stat(path); err == nil {
+		return "", fmt.Errorf("root filesystem '%s' already exists", name)
+	}
+
+	if err := os.MkdirAll(s.path, 0755); err != nil {
+		return "", err
+	}
+	tmp, err := os.MkdirTemp(s.path, ".import-"+name+"-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+
+	// the temporary directory is created with 0700, unless the archive holds an entry for its root
+	if err := os.Chmod(tmp, 0755); err != nil {
+		return "", err
+	}
+	if err := extract(r, tmp, opts); err != nil {
+		return "", err
+	}
+	return path, os.Rename(tmp, path)
+}
+
+// List returns the names of the root filesystems, sorted
+func (s *Store) List() ([]string, error) {
+	entries, err := os.ReadDir(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Remove deletes the root filesystem with the given name, unless a container holds a lock on it
+func (s *Store) Remove(name string) error {
+	path, err := s.Path(name)
+	if err != nil {
+		return err
+	}
+
+	lock, err := lockDir(path, syscall.LOCK_EX)
+	if err == syscall.EWOULDBLOCK {
+		return fmt.Errorf("%w: %s", ErrInUse, name)
+	}
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	return os.RemoveAll(path)
+}
+
+// Lock takes a shared lock on the root filesystem at path, preventing its removal until the returned
+// file is closed. Containers hold it while they run, like image.LockRootfs for the image ones.
+func Lock(path string) (*os.File, error) {
+	lock, err := lockDir(path, syscall.LOCK_SH)
+	if err == syscall.EWOULDBLOCK {
+		return nil, errors.New("the root filesystem is being removed")
+	}
+	return lock, err
+}
+
+// lockDir locks the directory at path without blocking
+func lockDir(path string, how int) (*os.File, error) {
+	lock, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(lock.Fd()), how|syscall.LOCK_NB); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	return lock, nil
+}
+
+func extract(f io.Reader, dest string, opts archive.Options) error {
+	r, err := archive.Decompress(f)
+	if err != nil {
+		return err
+	}
+	if err := archive.Extract(r, dest, opts); err != nil {
+		r.Close()
+		return err
+	}
+	return r.Close()
+}
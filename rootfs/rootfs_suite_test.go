@@ -0,0 +1,13 @@
+package rootfs_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestRootfs(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Rootfs suite")
+}
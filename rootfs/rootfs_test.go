@@ -0,0 +1,120 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/NamelessOne91/coso/archive"
+)
+
+// writeArchive writes a gzip compressed tar archive holding /etc/hostname to path
+func writeArchive(path string) {
+	f, err := os.Create(path)
+	Expect(err).NotTo(HaveOccurred())
+	defer f.Close()
+
+	zw := gzip.NewWriter(f)
+	tw := tar.NewWriter(zw)
+	Expect(tw.WriteHeader(&tar.Header{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755})).To(Succeed())
+	Expect(tw.WriteHeader(&tar.Header{Name: "etc/hostname", Typeflag: tar.TypeReg, Mode: 0644, Size: 4})).To(Succeed())
+	_, err = tw.Write([]byte("coso"))
+	Expect(err).NotTo(HaveOccurred())
+	Expect(tw.Close()).To(Succeed())
+	Expect(zw.Close()).To(Succeed())
+}
+
+var _ = Describe("Store", func() {
+
+	var (
+		dir   string
+		store *Store
+		opts  archive.Options
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "coso-rootfs")
+		Expect(err).NotTo(HaveOccurred())
+		store = NewStore(filepath.Join(dir, "store"))
+		opts = archive.Options{
+			MapOwner: func(int, int) (int, int, error) { return os.Getuid(), os.Getgid(), nil },
+			Rootless: os.Geteuid() != 0,
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("imports, lists and removes root filesystems", func() {
+		archivePath := filepath.Join(dir, "alpine.tar.gz")
+		writeArchive(archivePath)
+
+		path, err := store.Import("alpine", archivePath, opts)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(filepath.Join(path, "etc", "hostname")).To(BeARegularFile())
+
+		resolved, err := store.Path("alpine")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resolved).To(Equal(path))
+
+		names, err := store.List()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(names).To(Equal([]string{"alpine"}))
+
+		_, err = store.Import("alpine", archivePath, opts)
+		Expect(err).To(HaveOccurred())
+
+		Expect(store.Remove("alpine")).To(Succeed())
+		Expect(store.Exists("alpine")).To(BeFalse())
+	})
+
+	It("leaves nothing behind when the import fails", func() {
+		archivePath := filepath.Join(dir, "broken.tar.gz")
+		Expect(os.WriteFile(archivePath, []byte{0x1f, 0x8b, 0, 0}, 0644)).To(Succeed())
+
+		_, err := store.Import("broken", archivePath, opts)
+		Expect(err).To(HaveOccurred())
+
+		entries, err := os.ReadDir(filepath.Join(dir, "store"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(BeEmpty())
+	})
+
+	It("refuses to remove root filesystems containers run on", func() {
+		archivePath := filepath.Join(dir, "alpine.tar.gz")
+		writeArchive(archivePath)
+		path, err := store.Import("alpine", archivePath, opts)
+		Expect(err).NotTo(HaveOccurred())
+
+		lock, err := Lock(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(store.Remove("alpine")).To(MatchError(ErrInUse))
+		Expect(path).To(BeADirectory())
+
+		Expect(lock.Close()).To(Succeed())
+		Expect(store.Remove("alpine")).To(Succeed())
+		Expect(path).NotTo(BeADirectory())
+	})
+
+	It("returns ErrNotFound for missing root filesystems", func() {
+		_, err := store.Path("missing")
+		Expect(err).To(MatchError(ErrNotFound))
+	})
+
+	DescribeTable("NameFromArchive",
+		func(path, expected string) {
+			Expect(NameFromArchive(path)).To(Equal(expected))
+		},
+		Entry("gzip", "assets/alpine-minirootfs-3.18.2-x86_64.tar.gz", "alpine-minirootfs-3.18.2-x86_64"),
+		Entry("tgz", "/tmp/debian.tgz", "debian"),
+		Entry("zstd", "ubuntu.tar.zst", "ubuntu"),
+		Entry("plain tar", "busybox.tar", "busybox"),
+	)
+})
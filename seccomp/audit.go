@@ -0,0 +1,226 @@
+package seccomp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/NamelessOne91/coso/internal/stringslice"
+)
+
+// unauditable lists the syscalls the init process may perform between installing the notification
+// filter and handing its fd over to coso: auditing them would block the container forever, as nobody
+// listens to the notifications yet. Besides the ones sending the fd, and writing and exiting when that
+// fails, they are the syscalls the Go runtime issues on a thread locked to a goroutine: scheduling,
+// memory management and signal handling, preemption signals included.
+var unauditable = []string{
+	"sendmsg", "close", "write", "exit", "exit_group",
+	"futex", "sched_yield", "nanosleep", "clock_gettime", "gettid", "getpid", "tgkill",
+	"mmap", "munmap", "madvise",
+	"rt_sigreturn", "rt_sigprocmask", "rt_sigaction", "sigaltstack",
+}
+
+// AuditPolicy lists the syscalls to audit through seccomp user notifications,
+// together with the decision coso takes each time one is performed
+type AuditPolicy struct {
+	// LogPath is the file audited syscalls are appended to, stderr when empty
+	LogPath  string      `json:"logPath,omitempty"`
+	Syscalls []AuditRule `json:"syscalls"`
+}
+
+// AuditRule allows (ActAllow) or denies (ActErrno) the audited syscalls
+type AuditRule struct {
+	Names    []string `json:"names"`
+	Action   Action   `json:"action"`
+	ErrnoRet *uint    `json:"errnoRet,omitempty"`
+}
+
+// seccompNotif is struct seccomp_notif, received for each audited syscall
+type seccompNotif struct {
+	id    uint64
+	pid   uint32
+	flags uint32
+	data  seccompData
+}
+
+// seccompData is struct seccomp_data, describing the syscall performed
+type seccompData struct {
+	nr                 int32
+	arch               uint32
+	instructionPointer uint64
+	args               [maxArgs]uint64
+}
+
+// seccompNotifResp is struct seccomp_notif_resp, the decision sent back to the kernel
+type seccompNotifResp struct {
+	id    uint64
+	val   int64
+	error int32
+	flags uint32
+}
+
+// LoadAuditPolicy reads and validates the JSON audit policy found at the given path
+func LoadAuditPolicy(path string) (*AuditPolicy, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &AuditPolicy{}
+	if err := json.Unmarshal(content, p); err != nil {
+		return nil, fmt.Errorf("invalid seccomp audit policy '%s': %s", path, err)
+	}
+
+	for _, rule := range p.Syscalls {
+		if rule.Action != ActAllow && rule.Action != ActErrno {
+			return nil, fmt.Errorf("invalid seccomp audit policy '%s': action must be %s or %s", path, ActAllow, ActErrno)
+		}
+		for _, name := range rule.Names {
+			if stringslice.Contains(unauditable, name) {
+				return nil, fmt.Errorf("invalid seccomp audit policy '%s': %s can't be audited", path, name)
+			}
+		}
+	}
+	return p, nil
+}
+
+// NotifyProfile returns a profile allowing every syscall, but sending the audited ones to the notification fd
+func (p *AuditPolicy) NotifyProfile() *Profile {
+	var names []string
+	for _, rule := range p.Syscalls {
+		names = append(names, rule.Names...)
+	}
+
+	return &Profile{
+		DefaultAction: ActAllow,
+		Syscalls:      []Syscall{{Names: names, Action: ActNotify}},
+	}
+}
+
+// InstallWithListener loads the BPF program as seccomp filter of the calling thread,
+// returning the fd user notifications can be received from.
+//
+// The filter can't be synchronized to the other threads, so the calling goroutine must be
+// locked to the OS thread executing the workload.
+func InstallWithListener(program []unix.SockFilter) (int, error) {
+	fprog := unix.SockFprog{
+		Len:    uint16(len(program)),
+		Filter: &program[0],
+	}
+	fd, _, errno := unix.Syscall(
+		unix.SYS_SECCOMP,
+		unix.SECCOMP_SET_MODE_FILTER,
+		unix.SECCOMP_FILTER_FLAG_NEW_LISTENER,
+		uintptr(unsafe.Pointer(&fprog)),
+	)
+	if errno != 0 {
+		return -1, fmt.Errorf("seccomp(SECCOMP_SET_MODE_FILTER) failed: %s", errno)
+	}
+	return int(fd), nil
+}
+
+// Auditor logs the syscalls received from a seccomp notification fd, and lets
+// the kernel perform or deny them according to the policy
+type Auditor struct {
+	policy *AuditPolicy
+	names  map[int32]string
+	log    io.Writer
+}
+
+// NewAuditor returns an auditor deciding on the notified syscalls according to the policy,
+// and logging them to log
+func NewAuditor(policy *AuditPolicy, log io.Writer) *Auditor {
+	names := make(map[int32]string, len(syscalls))
+	for name, nr := range syscalls {
+		names[int32(nr)] = name
+	}
+
+	return &Auditor{
+		policy: policy,
+		names:  names,
+		log:    log,
+	}
+}
+
+// Run handles the notifications until every process using the filter has exited
+func (a *Auditor) Run(notifyFd *os.File) error {
+	defer notifyFd.Close()
+	fd := int(notifyFd.Fd())
+
+	for {
+		pollFds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+		if _, err := unix.Poll(pollFds, -1); err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			return err
+		}
+		if pollFds[0].Revents&unix.POLLHUP != 0 {
+			return nil
+		}
+
+		// the kernel requires the request to be zeroed
+		req := seccompNotif{}
+		if err := ioctl(fd, unix.SECCOMP_IOCTL_NOTIF_RECV, unsafe.Pointer(&req)); err != nil {
+			// the process may have been killed before the notification was received
+			if err == unix.EINTR || err == unix.ENOENT {
+				continue
+			}
+			return fmt.Errorf("unable to receive seccomp notification: %s", err)
+		}
+
+		resp := a.decide(&req)
+		if err := ioctl(fd, unix.SECCOMP_IOCTL_NOTIF_SEND, unsafe.Pointer(&resp)); err != nil && err != unix.ENOENT {
+			return fmt.Errorf("unable to send seccomp notification response: %s", err)
+		}
+	}
+}
+
+// decide logs the syscall described by req and returns the response matching the policy
+func (a *Auditor) decide(req *seccompNotif) seccompNotifResp {
+	name, known := a.names[req.data.nr]
+	if !known {
+		name = fmt.Sprintf("syscall_%d", req.data.nr)
+	}
+
+	// syscalls missing from the policy can't be notified, allowing is just a safe fallback
+	resp := seccompNotifResp{id: req.id, flags: unix.SECCOMP_USER_NOTIF_FLAG_CONTINUE}
+	decision := "allowed"
+	for _, rule := range a.policy.Syscalls {
+		if !stringslice.Contains(rule.Names, name) {
+			continue
+		}
+		if rule.Action == ActErrno {
+			errno := uint(unix.EPERM)
+			if rule.ErrnoRet != nil {
+				errno = *rule.ErrnoRet
+			}
+			resp = seccompNotifResp{id: req.id, error: -int32(errno)}
+			decision = fmt.Sprintf("denied (%s)", unix.Errno(errno))
+		}
+		break
+	}
+
+	args := make([]string, len(req.data.args))
+	for i, arg := range req.data.args {
+		args[i] = fmt.Sprintf("%#x", arg)
+	}
+	fmt.Fprintf(a.log, "%s [seccomp-audit] pid=%d syscall=%s args=[%s] %s\n",
+		time.Now().Format(time.RFC3339), req.pid, name, strings.Join(args, " "), decision)
+
+	return resp
+}
+
+func ioctl(fd int, request uintptr, arg unsafe.Pointer) error {
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), request, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
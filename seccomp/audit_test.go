@@ -0,0 +1,107 @@
+package seccomp
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"golang.org/x/sys/unix"
+)
+
+var _ = Describe("Audit", func() {
+	var dir, policyPath string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "coso-seccomp")
+		Expect(err).NotTo(HaveOccurred())
+		policyPath = filepath.Join(dir, "policy.json")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	Describe("LoadAuditPolicy", func() {
+		It("decodes the policy", func() {
+			content := `{"syscalls": [{"names": ["connect"], "action": "SCMP_ACT_ERRNO", "errnoRet": 13}]}`
+			Expect(os.WriteFile(policyPath, []byte(content), 0644)).To(Succeed())
+
+			p, err := LoadAuditPolicy(policyPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(p.Syscalls[0].Names).To(Equal([]string{"connect"}))
+			Expect(*p.Syscalls[0].ErrnoRet).To(Equal(uint(13)))
+		})
+
+		It("rejects actions other than allow and errno", func() {
+			content := `{"syscalls": [{"names": ["connect"], "action": "SCMP_ACT_KILL"}]}`
+			Expect(os.WriteFile(policyPath, []byte(content), 0644)).To(Succeed())
+
+			_, err := LoadAuditPolicy(policyPath)
+			Expect(err).To(HaveOccurred())
+		})
+
+		DescribeTable("rejects syscalls performed before the notification fd is handed over",
+			func(name string) {
+				content := `{"syscalls": [{"names": ["openat", "` + name + `"], "action": "SCMP_ACT_ALLOW"}]}`
+				Expect(os.WriteFile(policyPath, []byte(content), 0644)).To(Succeed())
+
+				_, err := LoadAuditPolicy(policyPath)
+				Expect(err).To(MatchError(ContainSubstring(name + " can't be audited")))
+			},
+			Entry("sending the fd", "sendmsg"),
+			Entry("the runtime's locks", "futex"),
+			Entry("the runtime's allocations", "mmap"),
+			Entry("the runtime's signal handlers", "rt_sigreturn"),
+		)
+	})
+
+	Describe("NotifyProfile", func() {
+		It("notifies the audited syscalls and allows the others", func() {
+			policy := &AuditPolicy{Syscalls: []AuditRule{{Names: []string{"getpid"}, Action: ActAllow}}}
+			program, err := Compile(policy.NotifyProfile(), nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			getpid := uint32(syscalls["getpid"])
+			Expect(run(program, nativeArch, getpid)).To(Equal(uint32(unix.SECCOMP_RET_USER_NOTIF)))
+			Expect(run(program, nativeArch, getpid+1)).To(Equal(uint32(unix.SECCOMP_RET_ALLOW)))
+		})
+	})
+
+	Describe("Auditor", func() {
+		var (
+			log     *bytes.Buffer
+			auditor *Auditor
+			errno   = uint(unix.EACCES)
+		)
+
+		BeforeEach(func() {
+			log = &bytes.Buffer{}
+			policy := &AuditPolicy{Syscalls: []AuditRule{
+				{Names: []string{"getpid"}, Action: ActAllow},
+				{Names: []string{"getuid"}, Action: ActErrno, ErrnoRet: &errno},
+			}}
+			auditor = NewAuditor(policy, log)
+		})
+
+		It("lets allowed syscalls continue and logs them", func() {
+			req := &seccompNotif{id: 7, pid: 42, data: seccompData{nr: int32(syscalls["getpid"])}}
+			resp := auditor.decide(req)
+
+			Expect(resp).To(Equal(seccompNotifResp{id: 7, flags: unix.SECCOMP_USER_NOTIF_FLAG_CONTINUE}))
+			Expect(log.String()).To(ContainSubstring("pid=42 syscall=getpid"))
+			Expect(log.String()).To(ContainSubstring("allowed"))
+		})
+
+		It("fails denied syscalls with the configured errno", func() {
+			req := &seccompNotif{id: 8, pid: 42, data: seccompData{nr: int32(syscalls["getuid"]), args: [maxArgs]uint64{0x10}}}
+			resp := auditor.decide(req)
+
+			Expect(resp).To(Equal(seccompNotifResp{id: 8, error: -int32(unix.EACCES)}))
+			Expect(log.String()).To(ContainSubstring("args=[0x10 0x0 0x0 0x0 0x0 0x0] denied"))
+		})
+	})
+})
@@ -0,0 +1,336 @@
+package seccomp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/NamelessOne91/coso/internal/stringslice"
+)
+
+const (
+	// offsets of the fields of struct seccomp_data, the input of the BPF program
+	offsetNr   = 0
+	offsetArch = 4
+	offsetArgs = 16
+	// maxInstructions is the maximum length of a BPF program accepted by the kernel (BPF_MAXINSNS)
+	maxInstructions = 4096
+	// maxArgs is the number of syscall arguments available in struct seccomp_data
+	maxArgs = 6
+)
+
+// instruction is a BPF instruction whose conditional jumps can target the end of the rule it
+// belongs to: those offsets are only known once the whole rule has been generated
+type instruction struct {
+	unix.SockFilter
+	jtNextRule bool
+	jfNextRule bool
+}
+
+// Compile translates the profile to a BPF program for the native architecture.
+//
+// Rules are evaluated in the order they appear in: the action of the first one matching the syscall,
+// and all its argument conditions, is returned. Rules are filtered by the given capabilities and the running
+// kernel version according to their includes and excludes. Syscalls unknown on the native architecture are ignored,
+// while syscalls from any other architecture or ABI kill the process.
+func Compile(p *Profile, caps []string) ([]unix.SockFilter, error) {
+	if nativeArch == 0 {
+		return nil, fmt.Errorf("seccomp filters are not supported on this architecture")
+	}
+	if !p.supportsNativeArch() {
+		return nil, fmt.Errorf("the seccomp profile doesn't support the %s architecture", nativeArchName)
+	}
+
+	kernel, err := kernelVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	defaultAction, err := actionValue(p.DefaultAction, p.DefaultErrnoRet)
+	if err != nil {
+		return nil, err
+	}
+
+	program := []unix.SockFilter{
+		// kill syscalls from foreign architectures, which would be matched against the wrong numbers
+		loadAbs(offsetArch),
+		jump(unix.BPF_JEQ, nativeArch, 1, 0),
+		ret(unix.SECCOMP_RET_KILL_PROCESS),
+		loadAbs(offsetNr),
+	}
+	if x32SyscallBit != 0 {
+		program = append(program,
+			jump(unix.BPF_JGE, x32SyscallBit, 0, 1),
+			ret(unix.SECCOMP_RET_KILL_PROCESS),
+		)
+	}
+
+	// tracks whether the accumulator still holds the syscall number
+	nrLoaded := true
+	for _, rule := range p.Syscalls {
+		if !rule.applies(caps, kernel) {
+			continue
+		}
+
+		action, err := actionValue(rule.Action, rule.ErrnoRet)
+		if err != nil {
+			return nil, err
+		}
+		// unconditional rules with the default action are redundant
+		if action == defaultAction && len(rule.Args) == 0 {
+			continue
+		}
+
+		for _, name := range rule.names() {
+			nr, known := syscalls[name]
+			if !known {
+				continue
+			}
+
+			block, err := ruleBlock(uint32(nr), rule.Args, action, !nrLoaded)
+			if err != nil {
+				return nil, fmt.Errorf("invalid rule for syscall '%s': %s", name, err)
+			}
+			program = append(program, block...)
+			nrLoaded = len(rule.Args) == 0
+		}
+	}
+	program = append(program, ret(defaultAction))
+
+	if len(program) > maxInstructions {
+		return nil, fmt.Errorf("the compiled seccomp program is too long: %d instructions", len(program))
+	}
+	return program, nil
+}
+
+// ruleBlock generates the instructions returning action for the syscall nr when all args conditions
+// are met, otherwise falling through to the next rule
+func ruleBlock(nr uint32, args []Arg, action uint32, reloadNr bool) ([]unix.SockFilter, error) {
+	var block []instruction
+	if reloadNr {
+		block = append(block, instruction{SockFilter: loadAbs(offsetNr)})
+	}
+	block = append(block, instruction{SockFilter: jump(unix.BPF_JEQ, nr, 0, 0), jfNextRule: true})
+
+	for _, arg := range args {
+		conditions, err := argConditions(arg)
+		if err != nil {
+			return nil, err
+		}
+		block = append(block, conditions...)
+	}
+	block = append(block, instruction{SockFilter: ret(action)})
+
+	// the next rule starts right after the last instruction of this block
+	filters := make([]unix.SockFilter, len(block))
+	for i, ins := range block {
+		offset := len(block) - (i + 1)
+		if offset > 0xff && (ins.jtNextRule || ins.jfNextRule) {
+			return nil, fmt.Errorf("too many argument conditions")
+		}
+		if ins.jtNextRule {
+			ins.Jt = uint8(offset)
+		}
+		if ins.jfNextRule {
+			ins.Jf = uint8(offset)
+		}
+		filters[i] = ins.SockFilter
+	}
+	return filters, nil
+}
+
+// argConditions generates the instructions checking a 64 bit argument, one 32 bit word at a time,
+// jumping to the next rule when the condition is not met
+func argConditions(arg Arg) ([]instruction, error) {
+	if arg.Index >= maxArgs {
+		return nil, fmt.Errorf("argument index %d out of range", arg.Index)
+	}
+
+	// little endian: the low word comes first
+	lowOffset := uint32(offsetArgs + 8*arg.Index)
+	highOffset := lowOffset + 4
+	high, low := uint32(arg.Value>>32), uint32(arg.Value)
+
+	loadHigh := instruction{SockFilter: loadAbs(highOffset)}
+	loadLow := instruction{SockFilter: loadAbs(lowOffset)}
+	failIfTrue := func(op uint16, k uint32) instruction {
+		return instruction{SockFilter: jump(op, k, 0, 0), jtNextRule: true}
+	}
+	failIfFalse := func(op uint16, k uint32) instruction {
+		return instruction{SockFilter: jump(op, k, 0, 0), jfNextRule: true}
+	}
+
+	switch arg.Op {
+	case OpEqualTo:
+		return []instruction{loadHigh, failIfFalse(unix.BPF_JEQ, high), loadLow, failIfFalse(unix.BPF_JEQ, low)}, nil
+	case OpNotEqual:
+		// differing high words skip the low word check
+		return []instruction{
+			loadHigh, {SockFilter: jump(unix.BPF_JEQ, high, 0, 2)},
+			loadLow, failIfTrue(unix.BPF_JEQ, low),
+		}, nil
+	case OpGreaterThan, OpGreaterEqual:
+		lowOp := uint16(unix.BPF_JGT)
+		if arg.Op == OpGreaterEqual {
+			lowOp = unix.BPF_JGE
+		}
+		// a greater high word passes, a lower one fails, an equal one depends on the low word
+		return []instruction{
+			loadHigh, {SockFilter: jump(unix.BPF_JGT, high, 3, 0)}, failIfFalse(unix.BPF_JEQ, high),
+			loadLow, failIfFalse(lowOp, low),
+		}, nil
+	case OpLessThan, OpLessEqual:
+		lowOp := uint16(unix.BPF_JGE)
+		if arg.Op == OpLessEqual {
+			lowOp = unix.BPF_JGT
+		}
+		// a greater high word fails, a lower one passes, an equal one depends on the low word
+		return []instruction{
+			loadHigh, failIfTrue(unix.BPF_JGT, high), {SockFilter: jump(unix.BPF_JEQ, high, 0, 2)},
+			loadLow, failIfTrue(lowOp, low),
+		}, nil
+	case OpMaskedEqual:
+		highValue, lowValue := uint32(arg.ValueTwo>>32), uint32(arg.ValueTwo)
+		return []instruction{
+			loadHigh, {SockFilter: and(high)}, failIfFalse(unix.BPF_JEQ, highValue),
+			loadLow, {SockFilter: and(low)}, failIfFalse(unix.BPF_JEQ, lowValue),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown operator '%s'", arg.Op)
+	}
+}
+
+// actionValue converts an action to the value returned by the BPF program
+func actionValue(action Action, errnoRet *uint) (uint32, error) {
+	data := func(defaultValue uint32) uint32 {
+		if errnoRet != nil {
+			return uint32(*errnoRet) & unix.SECCOMP_RET_DATA
+		}
+		return defaultValue
+	}
+
+	switch action {
+	case ActAllow:
+		return unix.SECCOMP_RET_ALLOW, nil
+	case ActErrno:
+		return unix.SECCOMP_RET_ERRNO | data(uint32(unix.EPERM)), nil
+	case ActKill, ActKillThread:
+		return unix.SECCOMP_RET_KILL_THREAD, nil
+	case ActKillProcess:
+		return unix.SECCOMP_RET_KILL_PROCESS, nil
+	case ActTrap:
+		return unix.SECCOMP_RET_TRAP, nil
+	case ActTrace:
+		return unix.SECCOMP_RET_TRACE | data(0), nil
+	case ActLog:
+		return unix.SECCOMP_RET_LOG, nil
+	case ActNotify:
+		return unix.SECCOMP_RET_USER_NOTIF, nil
+	default:
+		return 0, fmt.Errorf("unsupported action '%s'", action)
+	}
+}
+
+// supportsNativeArch reports whether the profile applies to the native architecture.
+// Profiles not listing any architecture apply to all of them.
+func (p *Profile) supportsNativeArch() bool {
+	if len(p.Architectures) == 0 && len(p.ArchMap) == 0 {
+		return true
+	}
+	for _, arch := range p.Architectures {
+		if arch == nativeArchName {
+			return true
+		}
+	}
+	for _, m := range p.ArchMap {
+		if m.Arch == nativeArchName {
+			return true
+		}
+	}
+	return false
+}
+
+// applies reports whether the rule is part of the program, given the workload capabilities and the kernel version
+func (s *Syscall) applies(caps []string, kernel [2]int) bool {
+	for _, c := range s.Includes.Caps {
+		if !stringslice.Contains(caps, c) {
+			return false
+		}
+	}
+	if len(s.Includes.Arches) > 0 && !containsArch(s.Includes.Arches) {
+		return false
+	}
+	if s.Includes.MinKernel != "" {
+		min, err := parseKernelVersion(s.Includes.MinKernel)
+		if err != nil || kernel[0] < min[0] || (kernel[0] == min[0] && kernel[1] < min[1]) {
+			return false
+		}
+	}
+
+	for _, c := range s.Excludes.Caps {
+		if stringslice.Contains(caps, c) {
+			return false
+		}
+	}
+	if len(s.Excludes.Arches) > 0 && containsArch(s.Excludes.Arches) {
+		return false
+	}
+	return true
+}
+
+// containsArch reports whether the native architecture is in the given list, which
+// may use both the SCMP_ARCH_X86_64 and the short x86_64 naming
+func containsArch(arches []string) bool {
+	for _, arch := range arches {
+		if arch == nativeArchName || "SCMP_ARCH_"+strings.ToUpper(arch) == nativeArchName {
+			return true
+		}
+	}
+	return false
+}
+
+// kernelVersion returns the major and minor version of the running kernel
+func kernelVersion() ([2]int, error) {
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		return [2]int{}, err
+	}
+	return parseKernelVersion(unix.ByteSliceToString(uts.Release[:]))
+}
+
+// parseKernelVersion parses the major.minor prefix of a kernel release, e.g. 6.1.0-13-amd64
+func parseKernelVersion(release string) ([2]int, error) {
+	parts := strings.SplitN(release, ".", 3)
+	if len(parts) < 2 {
+		return [2]int{}, fmt.Errorf("invalid kernel version '%s'", release)
+	}
+
+	var version [2]int
+	for i := 0; i < 2; i++ {
+		digits := strings.TrimRightFunc(parts[i], func(r rune) bool { return r < '0' || r > '9' })
+		n, err := strconv.Atoi(digits)
+		if err != nil {
+			return [2]int{}, fmt.Errorf("invalid kernel version '%s'", release)
+		}
+		version[i] = n
+	}
+	return version, nil
+}
+
+func loadAbs(offset uint32) unix.SockFilter {
+	return unix.SockFilter{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: offset}
+}
+
+func jump(op uint16, k uint32, jt, jf uint8) unix.SockFilter {
+	return unix.SockFilter{Code: unix.BPF_JMP | op | unix.BPF_K, K: k, Jt: jt, Jf: jf}
+}
+
+func and(k uint32) unix.SockFilter {
+	return unix.SockFilter{Code: unix.BPF_ALU | unix.BPF_AND | unix.BPF_K, K: k}
+}
+
+func ret(k uint32) unix.SockFilter {
+	return unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: k}
+}
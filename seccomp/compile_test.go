@@ -0,0 +1,221 @@
+package seccomp
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"golang.org/x/sys/unix"
+)
+
+var _ = Describe("Compile", func() {
+	var (
+		getpid uint32
+		errno  = uint(unix.EACCES)
+	)
+
+	BeforeEach(func() {
+		getpid = uint32(syscalls["getpid"])
+	})
+
+	It("returns the default action for syscalls without rules", func() {
+		program, err := Compile(&Profile{DefaultAction: ActErrno}, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(run(program, nativeArch, getpid)).To(Equal(uint32(unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM))))
+	})
+
+	It("returns the action of the matching rule", func() {
+		p := &Profile{
+			DefaultAction: ActAllow,
+			Syscalls:      []Syscall{{Names: []string{"getpid", "unknown_syscall"}, Action: ActErrno, ErrnoRet: &errno}},
+		}
+		program, err := Compile(p, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(run(program, nativeArch, getpid)).To(Equal(uint32(unix.SECCOMP_RET_ERRNO | uint32(unix.EACCES))))
+		Expect(run(program, nativeArch, getpid+1)).To(Equal(uint32(unix.SECCOMP_RET_ALLOW)))
+	})
+
+	It("kills syscalls from foreign architectures", func() {
+		program, err := Compile(&Profile{DefaultAction: ActAllow}, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(run(program, 0x40000003, getpid)).To(Equal(uint32(unix.SECCOMP_RET_KILL_PROCESS)))
+	})
+
+	DescribeTable("knows syscalls by their libseccomp names",
+		func(name string) {
+			Expect(syscalls).To(HaveKey(name))
+		},
+		Entry("newfstatat", "newfstatat"),
+		Entry("fstat", "fstat"),
+		Entry("openat", "openat"),
+		Entry("rt_sigreturn", "rt_sigreturn"),
+	)
+
+	DescribeTable("argument conditions",
+		func(arg Arg, value uint64, matches bool) {
+			p := &Profile{
+				DefaultAction: ActAllow,
+				Syscalls:      []Syscall{{Names: []string{"getpid"}, Action: ActKillProcess, Args: []Arg{arg}}},
+			}
+			program, err := Compile(p, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			expected := uint32(unix.SECCOMP_RET_ALLOW)
+			if matches {
+				expected = unix.SECCOMP_RET_KILL_PROCESS
+			}
+			Expect(run(program, nativeArch, getpid, 0, value)).To(Equal(expected))
+		},
+		Entry("EQ matching", Arg{Index: 1, Value: 1 << 40, Op: OpEqualTo}, uint64(1<<40), true),
+		Entry("EQ with a different high word", Arg{Index: 1, Value: 1 << 40, Op: OpEqualTo}, uint64(0), false),
+		Entry("NE matching on the high word", Arg{Index: 1, Value: 5, Op: OpNotEqual}, uint64(1<<32|5), true),
+		Entry("NE not matching", Arg{Index: 1, Value: 5, Op: OpNotEqual}, uint64(5), false),
+		Entry("GT on the high word", Arg{Index: 1, Value: 0xffffffff, Op: OpGreaterThan}, uint64(1<<32), true),
+		Entry("GT on equal values", Arg{Index: 1, Value: 7, Op: OpGreaterThan}, uint64(7), false),
+		Entry("GE on equal values", Arg{Index: 1, Value: 7, Op: OpGreaterEqual}, uint64(7), true),
+		Entry("LT on the high word", Arg{Index: 1, Value: 1 << 32, Op: OpLessThan}, uint64(0xffffffff), true),
+		Entry("LT on equal values", Arg{Index: 1, Value: 7, Op: OpLessThan}, uint64(7), false),
+		Entry("LE on equal values", Arg{Index: 1, Value: 7, Op: OpLessEqual}, uint64(7), true),
+		Entry("LE on a greater high word", Arg{Index: 1, Value: 7, Op: OpLessEqual}, uint64(1<<32), false),
+		Entry("MASKED_EQ matching", Arg{Index: 1, Value: 0xf0, ValueTwo: 0x30, Op: OpMaskedEqual}, uint64(0x3f), true),
+		Entry("MASKED_EQ not matching", Arg{Index: 1, Value: 0xf0, ValueTwo: 0x30, Op: OpMaskedEqual}, uint64(0x4f), false),
+	)
+
+	It("evaluates rules in order", func() {
+		p := &Profile{
+			DefaultAction: ActAllow,
+			Syscalls: []Syscall{
+				{Names: []string{"getpid"}, Action: ActLog, Args: []Arg{{Index: 0, Value: 1, Op: OpEqualTo}}},
+				{Names: []string{"getpid"}, Action: ActTrap},
+			},
+		}
+		program, err := Compile(p, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(run(program, nativeArch, getpid, 1)).To(Equal(uint32(unix.SECCOMP_RET_LOG)))
+		Expect(run(program, nativeArch, getpid, 2)).To(Equal(uint32(unix.SECCOMP_RET_TRAP)))
+	})
+
+	It("filters rules by capabilities", func() {
+		p := &Profile{
+			DefaultAction: ActAllow,
+			Syscalls: []Syscall{
+				{Names: []string{"getpid"}, Action: ActErrno, Excludes: Filter{Caps: []string{"CAP_SYS_ADMIN"}}},
+			},
+		}
+
+		program, err := Compile(p, []string{"CAP_SYS_ADMIN"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(run(program, nativeArch, getpid)).To(Equal(uint32(unix.SECCOMP_RET_ALLOW)))
+
+		program, err = Compile(p, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(run(program, nativeArch, getpid)).To(Equal(uint32(unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM))))
+	})
+
+	It("compiles the default profile", func() {
+		program, err := Compile(DefaultProfile(), nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(run(program, nativeArch, uint32(syscalls["keyctl"]))).To(Equal(uint32(unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM))))
+		Expect(run(program, nativeArch, uint32(syscalls["clone"]), uint64(unix.SIGCHLD))).To(Equal(uint32(unix.SECCOMP_RET_ALLOW)))
+		Expect(run(program, nativeArch, uint32(syscalls["clone"]), unix.CLONE_NEWUSER)).To(Equal(uint32(unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM))))
+		Expect(run(program, nativeArch, getpid)).To(Equal(uint32(unix.SECCOMP_RET_ALLOW)))
+	})
+
+	It("rejects profiles not supporting the native architecture", func() {
+		_, err := Compile(&Profile{DefaultAction: ActAllow, Architectures: []string{"SCMP_ARCH_PPC"}}, nil)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("rejects unknown actions", func() {
+		_, err := Compile(&Profile{DefaultAction: "SCMP_ACT_MAYBE"}, nil)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("LoadProfile", func() {
+	var dir, path string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "coso-seccomp")
+		Expect(err).NotTo(HaveOccurred())
+		path = filepath.Join(dir, "profile.json")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("decodes Docker format profiles", func() {
+		content := `{
+			"defaultAction": "SCMP_ACT_ERRNO",
+			"architectures": ["SCMP_ARCH_X86_64"],
+			"syscalls": [{"names": ["read", "write"], "action": "SCMP_ACT_ALLOW",
+				"args": [{"index": 0, "value": 1, "op": "SCMP_CMP_EQ"}]}]
+		}`
+		Expect(os.WriteFile(path, []byte(content), 0644)).To(Succeed())
+
+		p, err := LoadProfile(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(p.DefaultAction).To(Equal(ActErrno))
+		Expect(p.Syscalls[0].Names).To(Equal([]string{"read", "write"}))
+		Expect(p.Syscalls[0].Args[0].Op).To(Equal(OpEqualTo))
+	})
+
+	It("requires a default action", func() {
+		Expect(os.WriteFile(path, []byte(`{"syscalls": []}`), 0644)).To(Succeed())
+
+		_, err := LoadProfile(path)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+// run interprets the classic BPF program against a struct seccomp_data built from the given values
+func run(program []unix.SockFilter, arch, nr uint32, args ...uint64) uint32 {
+	data := make([]byte, offsetArgs+8*maxArgs)
+	binary.LittleEndian.PutUint32(data[offsetNr:], nr)
+	binary.LittleEndian.PutUint32(data[offsetArch:], arch)
+	for i, arg := range args {
+		binary.LittleEndian.PutUint64(data[offsetArgs+8*i:], arg)
+	}
+
+	var a uint32
+	for pc := 0; pc < len(program); pc++ {
+		ins := program[pc]
+		switch ins.Code {
+		case unix.BPF_LD | unix.BPF_W | unix.BPF_ABS:
+			a = binary.LittleEndian.Uint32(data[ins.K:])
+		case unix.BPF_ALU | unix.BPF_AND | unix.BPF_K:
+			a &= ins.K
+		case unix.BPF_RET | unix.BPF_K:
+			return ins.K
+		default:
+			var cond bool
+			switch ins.Code {
+			case unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K:
+				cond = a == ins.K
+			case unix.BPF_JMP | unix.BPF_JGT | unix.BPF_K:
+				cond = a > ins.K
+			case unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K:
+				cond = a >= ins.K
+			default:
+				Fail("unexpected BPF instruction")
+			}
+			if cond {
+				pc += int(ins.Jt)
+			} else {
+				pc += int(ins.Jf)
+			}
+		}
+	}
+	Fail("the BPF program didn't return")
+	return 0
+}
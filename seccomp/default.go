@@ -0,0 +1,107 @@
+package seccomp
+
+import "golang.org/x/sys/unix"
+
+// namespaceFlags are the clone flags creating new namespaces
+const namespaceFlags = unix.CLONE_NEWNS | unix.CLONE_NEWUTS | unix.CLONE_NEWIPC | unix.CLONE_NEWUSER |
+	unix.CLONE_NEWPID | unix.CLONE_NEWNET | unix.CLONE_NEWCGROUP
+
+// DefaultProfile returns the built in profile, which allows every syscall but the ones letting
+// the workload escape or tamper with the host kernel. Most of them are only allowed when the
+// container holds the capability which would be needed to perform them outside of a user namespace.
+func DefaultProfile() *Profile {
+	enosys := uint(unix.ENOSYS)
+
+	return &Profile{
+		DefaultAction: ActAllow,
+		ArchMap:       []ArchMap{{Arch: nativeArchName}},
+		Syscalls: []Syscall{
+			{
+				Names: []string{
+					"add_key", "keyctl", "request_key", "lookup_dcookie", "nfsservctl", "uselib",
+					"_sysctl", "create_module", "get_kernel_syms", "query_module", "userfaultfd",
+				},
+				Action:  ActErrno,
+				Comment: "kernel keyring is not namespaced, the others are obsolete or abused to exploit the kernel",
+			},
+			{
+				Names:    []string{"kexec_load", "kexec_file_load", "reboot"},
+				Action:   ActErrno,
+				Excludes: Filter{Caps: []string{"CAP_SYS_BOOT"}},
+			},
+			{
+				Names: []string{
+					"mount", "umount", "umount2", "pivot_root", "mount_setattr", "move_mount", "open_tree",
+					"fsopen", "fsconfig", "fsmount", "fspick", "swapon", "swapoff", "quotactl", "quotactl_fd",
+					"setns", "unshare", "bpf", "fanotify_init", "vm86", "vm86old",
+				},
+				Action:   ActErrno,
+				Excludes: Filter{Caps: []string{"CAP_SYS_ADMIN"}},
+			},
+			{
+				Names:    []string{"clone"},
+				Action:   ActAllow,
+				Args:     []Arg{{Index: 0, Value: namespaceFlags, ValueTwo: 0, Op: OpMaskedEqual}},
+				Excludes: Filter{Caps: []string{"CAP_SYS_ADMIN"}},
+				Comment:  "clone is allowed unless creating new namespaces",
+			},
+			{
+				Names:    []string{"clone"},
+				Action:   ActErrno,
+				Excludes: Filter{Caps: []string{"CAP_SYS_ADMIN"}},
+			},
+			{
+				Names:    []string{"clone3"},
+				Action:   ActErrno,
+				ErrnoRet: &enosys,
+				Excludes: Filter{Caps: []string{"CAP_SYS_ADMIN"}},
+				Comment:  "flags are passed in memory and can't be inspected, ENOSYS makes libc fall back to clone",
+			},
+			{
+				Names:    []string{"init_module", "finit_module", "delete_module"},
+				Action:   ActErrno,
+				Excludes: Filter{Caps: []string{"CAP_SYS_MODULE"}},
+			},
+			{
+				Names:    []string{"ptrace", "process_vm_readv", "process_vm_writev", "kcmp", "pidfd_getfd"},
+				Action:   ActErrno,
+				Excludes: Filter{Caps: []string{"CAP_SYS_PTRACE"}},
+			},
+			{
+				Names:    []string{"perf_event_open"},
+				Action:   ActErrno,
+				Excludes: Filter{Caps: []string{"CAP_PERFMON"}},
+			},
+			{
+				Names:    []string{"settimeofday", "stime", "clock_settime", "clock_adjtime"},
+				Action:   ActErrno,
+				Excludes: Filter{Caps: []string{"CAP_SYS_TIME"}},
+			},
+			{
+				Names:    []string{"iopl", "ioperm"},
+				Action:   ActErrno,
+				Excludes: Filter{Caps: []string{"CAP_SYS_RAWIO"}},
+			},
+			{
+				Names:    []string{"open_by_handle_at"},
+				Action:   ActErrno,
+				Excludes: Filter{Caps: []string{"CAP_DAC_READ_SEARCH"}},
+			},
+			{
+				Names:    []string{"acct"},
+				Action:   ActErrno,
+				Excludes: Filter{Caps: []string{"CAP_SYS_PACCT"}},
+			},
+			{
+				Names:    []string{"syslog"},
+				Action:   ActErrno,
+				Excludes: Filter{Caps: []string{"CAP_SYSLOG"}},
+			},
+			{
+				Names:    []string{"vhangup"},
+				Action:   ActErrno,
+				Excludes: Filter{Caps: []string{"CAP_SYS_TTY_CONFIG"}},
+			},
+		},
+	}
+}
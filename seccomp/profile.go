@@ -0,0 +1,107 @@
+// Package seccomp compiles Docker/OCI format seccomp profiles into BPF programs
+// restricting the syscalls a container's workload can perform
+package seccomp
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Action is what the kernel does when a syscall matches a rule
+type Action string
+
+const (
+	ActKill        Action = "SCMP_ACT_KILL"
+	ActKillThread  Action = "SCMP_ACT_KILL_THREAD"
+	ActKillProcess Action = "SCMP_ACT_KILL_PROCESS"
+	ActTrap        Action = "SCMP_ACT_TRAP"
+	ActErrno       Action = "SCMP_ACT_ERRNO"
+	ActTrace       Action = "SCMP_ACT_TRACE"
+	ActAllow       Action = "SCMP_ACT_ALLOW"
+	ActLog         Action = "SCMP_ACT_LOG"
+	ActNotify      Action = "SCMP_ACT_NOTIFY"
+)
+
+// Operator compares a syscall argument with the values of an Arg
+type Operator string
+
+const (
+	OpNotEqual     Operator = "SCMP_CMP_NE"
+	OpLessThan     Operator = "SCMP_CMP_LT"
+	OpLessEqual    Operator = "SCMP_CMP_LE"
+	OpEqualTo      Operator = "SCMP_CMP_EQ"
+	OpGreaterEqual Operator = "SCMP_CMP_GE"
+	OpGreaterThan  Operator = "SCMP_CMP_GT"
+	OpMaskedEqual  Operator = "SCMP_CMP_MASKED_EQ"
+)
+
+// Profile is a seccomp profile in the format used by Docker and the OCI runtime spec
+type Profile struct {
+	DefaultAction   Action    `json:"defaultAction"`
+	DefaultErrnoRet *uint     `json:"defaultErrnoRet,omitempty"`
+	Architectures   []string  `json:"architectures,omitempty"`
+	ArchMap         []ArchMap `json:"archMap,omitempty"`
+	Syscalls        []Syscall `json:"syscalls,omitempty"`
+}
+
+// ArchMap lists an architecture together with its sub architectures (e.g. x86_64 with x86 and x32)
+type ArchMap struct {
+	Arch      string   `json:"architecture"`
+	SubArches []string `json:"subArchitectures"`
+}
+
+// Syscall is a rule applying an action to a set of syscalls, optionally only when
+// all of its argument conditions are met
+type Syscall struct {
+	Name     string   `json:"name,omitempty"`
+	Names    []string `json:"names,omitempty"`
+	Action   Action   `json:"action"`
+	ErrnoRet *uint    `json:"errnoRet,omitempty"`
+	Args     []Arg    `json:"args,omitempty"`
+	Comment  string   `json:"comment,omitempty"`
+	Includes Filter   `json:"includes,omitempty"`
+	Excludes Filter   `json:"excludes,omitempty"`
+}
+
+// Arg is a condition on the syscall argument at Index.
+// For OpMaskedEqual, Value is the mask and ValueTwo the value the masked argument is compared to.
+type Arg struct {
+	Index    uint     `json:"index"`
+	Value    uint64   `json:"value"`
+	ValueTwo uint64   `json:"valueTwo,omitempty"`
+	Op       Operator `json:"op"`
+}
+
+// Filter restricts when a rule is part of the compiled program: for includes all the
+// conditions must be met, for excludes the rule is skipped if any of them is
+type Filter struct {
+	Caps      []string `json:"caps,omitempty"`
+	Arches    []string `json:"arches,omitempty"`
+	MinKernel string   `json:"minKernel,omitempty"`
+}
+
+// LoadProfile reads and decodes the JSON seccomp profile found at the given path
+func LoadProfile(path string) (*Profile, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Profile{}
+	if err := json.Unmarshal(content, p); err != nil {
+		return nil, fmt.Errorf("invalid seccomp profile '%s': %s", path, err)
+	}
+	if p.DefaultAction == "" {
+		return nil, fmt.Errorf("invalid seccomp profile '%s': missing defaultAction", path)
+	}
+	return p, nil
+}
+
+// names returns the syscalls the rule applies to, in both the current and legacy formats
+func (s *Syscall) names() []string {
+	if s.Name != "" {
+		return append([]string{s.Name}, s.Names...)
+	}
+	return s.Names
+}
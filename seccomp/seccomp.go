@@ -0,0 +1,36 @@
+package seccomp
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Install loads the BPF program as seccomp filter of the calling process, synchronizing it to all of its threads.
+//
+// Unless the no_new_privs flag is set, the calling thread must hold CAP_SYS_ADMIN in its user namespace.
+func Install(program []unix.SockFilter) error {
+	if len(program) == 0 {
+		return fmt.Errorf("empty seccomp program")
+	}
+
+	fprog := unix.SockFprog{
+		Len:    uint16(len(program)),
+		Filter: &program[0],
+	}
+	r, _, errno := unix.Syscall(
+		unix.SYS_SECCOMP,
+		unix.SECCOMP_SET_MODE_FILTER,
+		unix.SECCOMP_FILTER_FLAG_TSYNC,
+		uintptr(unsafe.Pointer(&fprog)),
+	)
+	if errno != 0 {
+		return fmt.Errorf("seccomp(SECCOMP_SET_MODE_FILTER) failed: %s", errno)
+	}
+	// with TSYNC, a positive return value is the ID of the thread which couldn't be synchronized
+	if r != 0 {
+		return fmt.Errorf("unable to synchronize the seccomp filter to thread %d", r)
+	}
+	return nil
+}
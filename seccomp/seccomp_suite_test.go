@@ -0,0 +1,13 @@
+package seccomp_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestSeccomp(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Seccomp suite")
+}
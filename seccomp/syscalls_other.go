@@ -0,0 +1,13 @@
+//go:build !amd64 && !arm64
+
+package seccomp
+
+const (
+	// nativeArch is zero on architectures seccomp filters can't be compiled for
+	nativeArch     = 0
+	nativeArchName = ""
+	x32SyscallBit  = 0
+)
+
+// syscalls is empty on unsupported architectures
+var syscalls = map[string]int{}
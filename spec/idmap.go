@@ -0,0 +1,129 @@
+package spec
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	// SubUIDPath is the file listing the subordinate user IDs each user is allowed to map
+	SubUIDPath = "/etc/subuid"
+	// SubGIDPath is the file listing the subordinate group IDs each user is allowed to map
+	SubGIDPath = "/etc/subgid"
+)
+
+// IDMapping maps a contiguous range of IDs inside the container's user namespace
+// to a range of the same size on the host
+type IDMapping struct {
+	ContainerID int `json:"containerID"`
+	HostID      int `json:"hostID"`
+	Size        int `json:"size"`
+}
+
+// ParseIDMapping parses an ID mapping in the containerID:hostID:size format
+func ParseIDMapping(mapping string) (IDMapping, error) {
+	parts := strings.Split(mapping, ":")
+	if len(parts) != 3 {
+		return IDMapping{}, fmt.Errorf("invalid ID mapping '%s': expected containerID:hostID:size", mapping)
+	}
+
+	ids := make([]int, len(parts))
+	for i, part := range parts {
+		id, err := strconv.Atoi(part)
+		if err != nil || id < 0 {
+			return IDMapping{}, fmt.Errorf("invalid ID mapping '%s': '%s' is not a valid ID", mapping, part)
+		}
+		ids[i] = id
+	}
+	if ids[2] == 0 {
+		return IDMapping{}, fmt.Errorf("invalid ID mapping '%s': size must be greater than 0", mapping)
+	}
+
+	return IDMapping{ContainerID: ids[0], HostID: ids[1], Size: ids[2]}, nil
+}
+
+// DefaultIDMappings maps root in the container to the given host ID and, if the subordinate IDs file
+// at subIDPath lists a range for the user with the given name or uid, the following container IDs to that range
+func DefaultIDMappings(subIDPath, name string, uid, hostID int) []IDMapping {
+	mappings := []IDMapping{{ContainerID: 0, HostID: hostID, Size: 1}}
+
+	start, size, err := lookupSubIDs(subIDPath, name, uid)
+	if err != nil || size == 0 {
+		return mappings
+	}
+
+	return append(mappings, IDMapping{ContainerID: 1, HostID: start, Size: size})
+}
+
+// lookupSubIDs returns the first subordinate IDs range assigned to the user with the given name or uid
+//
+// Each line of the file is in the name:start:size format, where name can also be a numeric ID
+func lookupSubIDs(subIDPath, name string, uid int) (int, int, error) {
+	file, err := os.Open(subIDPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.Split(line, ":")
+		if len(parts) != 3 || (parts[0] != name && parts[0] != strconv.Itoa(uid)) {
+			continue
+		}
+
+		start, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid subordinate IDs start '%s' in %s", parts[1], subIDPath)
+		}
+		size, err := strconv.Atoi(parts[2])
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid subordinate IDs count '%s' in %s", parts[2], subIDPath)
+		}
+		return start, size, nil
+	}
+
+	return 0, 0, scanner.Err()
+}
+
+// SysProcIDMaps converts the given mappings to the format expected by syscall.SysProcAttr
+func SysProcIDMaps(mappings []IDMapping) []syscall.SysProcIDMap {
+	sysMappings := make([]syscall.SysProcIDMap, 0, len(mappings))
+	for _, m := range mappings {
+		sysMappings = append(sysMappings, syscall.SysProcIDMap{
+			ContainerID: m.ContainerID,
+			HostID:      m.HostID,
+			Size:        m.Size,
+		})
+	}
+	return sysMappings
+}
+
+// HostID translates an ID of the container's user namespace to the host ID it is mapped to
+func HostID(mappings []IDMapping, id int) (int, error) {
+	for _, m := range mappings {
+		if id >= m.ContainerID && id < m.ContainerID+m.Size {
+			return m.HostID + id - m.ContainerID, nil
+		}
+	}
+	return 0, fmt.Errorf("the container ID %d is not mapped", id)
+}
+
+// ContainerID translates a host ID to the ID it is mapped to in the container's user namespace
+func ContainerID(mappings []IDMapping, hostID int) (int, error) {
+	for _, m := range mappings {
+		if hostID >= m.HostID && hostID < m.HostID+m.Size {
+			return m.ContainerID + hostID - m.HostID, nil
+		}
+	}
+	return 0, fmt.Errorf("the host ID %d is not mapped", hostID)
+}
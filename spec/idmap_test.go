@@ -0,0 +1,116 @@
+package spec
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("IDMapping", func() {
+
+	Describe("ParseIDMapping", func() {
+		It("parses a containerID:hostID:size mapping", func() {
+			m, err := ParseIDMapping("1:100000:65536")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(m).To(Equal(IDMapping{ContainerID: 1, HostID: 100000, Size: 65536}))
+		})
+
+		It("rejects mappings with a wrong number of fields", func() {
+			_, err := ParseIDMapping("0:1000")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("rejects non numeric IDs", func() {
+			_, err := ParseIDMapping("0:root:1")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("rejects empty ranges", func() {
+			_, err := ParseIDMapping("0:1000:0")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("HostID", func() {
+		mappings := []IDMapping{
+			{ContainerID: 0, HostID: 1000, Size: 1},
+			{ContainerID: 1, HostID: 100000, Size: 65536},
+		}
+
+		It("translates mapped IDs", func() {
+			Expect(HostID(mappings, 0)).To(Equal(1000))
+			Expect(HostID(mappings, 1)).To(Equal(100000))
+			Expect(HostID(mappings, 65536)).To(Equal(165535))
+		})
+
+		It("rejects unmapped IDs", func() {
+			_, err := HostID(mappings, 65537)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("ContainerID", func() {
+		mappings := []IDMapping{
+			{ContainerID: 0, HostID: 1000, Size: 1},
+			{ContainerID: 1, HostID: 100000, Size: 65536},
+		}
+
+		It("translates mapped host IDs", func() {
+			Expect(ContainerID(mappings, 1000)).To(Equal(0))
+			Expect(ContainerID(mappings, 100000)).To(Equal(1))
+			Expect(ContainerID(mappings, 165535)).To(Equal(65536))
+		})
+
+		It("rejects unmapped host IDs", func() {
+			_, err := ContainerID(mappings, 0)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("DefaultIDMappings", func() {
+		var dir, subIDPath string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = os.MkdirTemp("", "coso-spec")
+			Expect(err).NotTo(HaveOccurred())
+
+			subIDPath = filepath.Join(dir, "subuid")
+			content := "# comment\nalice:100000:65536\n1001:200000:1000\n"
+			Expect(os.WriteFile(subIDPath, []byte(content), 0644)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(dir)).To(Succeed())
+		})
+
+		It("maps the subordinate range of the user with the given name", func() {
+			mappings := DefaultIDMappings(subIDPath, "alice", 1000, 1000)
+			Expect(mappings).To(Equal([]IDMapping{
+				{ContainerID: 0, HostID: 1000, Size: 1},
+				{ContainerID: 1, HostID: 100000, Size: 65536},
+			}))
+		})
+
+		It("maps the subordinate range of the user with the given uid", func() {
+			mappings := DefaultIDMappings(subIDPath, "bob", 1001, 1001)
+			Expect(mappings).To(ContainElement(IDMapping{ContainerID: 1, HostID: 200000, Size: 1000}))
+		})
+
+		Context("when the user has no subordinate range", func() {
+			It("only maps container root", func() {
+				mappings := DefaultIDMappings(subIDPath, "carol", 1002, 1002)
+				Expect(mappings).To(Equal([]IDMapping{{ContainerID: 0, HostID: 1002, Size: 1}}))
+			})
+		})
+
+		Context("when the subordinate IDs file doesn't exist", func() {
+			It("only maps container root", func() {
+				mappings := DefaultIDMappings("/nonexistent/subuid", "alice", 1000, 1000)
+				Expect(mappings).To(HaveLen(1))
+			})
+		})
+	})
+})
@@ -0,0 +1,172 @@
+// Package spec defines the configuration of a container, shared between the coso CLI
+// and the init process running inside the newly created namespaces
+package spec
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/NamelessOne91/coso/filesystem"
+	"github.com/NamelessOne91/coso/landlock"
+	"github.com/NamelessOne91/coso/seccomp"
+)
+
+const shortIDLength = 12
+
+// Spec holds the whole configuration needed to create and run a container
+type Spec struct {
+	// ID uniquely identifies the container
+	ID string `json:"id"`
+	// Name is the human friendly name of the container
+	Name string `json:"name,omitempty"`
+	// Hostname is the hostname set in the container's UTS namespace
+	Hostname string `json:"hostname,omitempty"`
+	// Domainname is the NIS domain name set in the container's UTS namespace
+	Domainname string `json:"domainname,omitempty"`
+	// Rootfs is the path to the root filesystem used as lower layer
+	Rootfs string `json:"rootfs"`
+	// Image is the ID of the image the root filesystem was unpacked from, if any
+	Image string `json:"image,omitempty"`
+	// RootfsPropagation is the propagation mode of the container's root mount, rprivate when empty
+	RootfsPropagation string `json:"rootfsPropagation,omitempty"`
+	// ReadonlyRootfs makes the container's root filesystem read only
+	ReadonlyRootfs bool `json:"readonlyRootfs,omitempty"`
+	// Mounts are the filesystems mounted inside the container, in order
+	Mounts []filesystem.Mount `json:"mounts,omitempty"`
+	// StorageQuota limits the size of the container's writable layer, unlimited when nil
+	StorageQuota *filesystem.StorageQuota `json:"storageQuota,omitempty"`
+	// AutoRemove discards the container's writable layer when it exits
+	AutoRemove bool `json:"autoRemove"`
+	// StopSignal is the signal the workload receives when coso is asked to terminate, SIGTERM when empty
+	StopSignal string `json:"stopSignal,omitempty"`
+	// Labels are metadata attached to the container
+	Labels map[string]string `json:"labels,omitempty"`
+	// ExposedPorts are the ports, in the port/protocol format, the workload listens on
+	ExposedPorts []string `json:"exposedPorts,omitempty"`
+	// Process describes the workload executed inside the container
+	Process Process `json:"process"`
+	// UIDMappings maps user IDs in the container's user namespace to host user IDs
+	UIDMappings []IDMapping `json:"uidMappings,omitempty"`
+	// GIDMappings maps group IDs in the container's user namespace to host group IDs
+	GIDMappings []IDMapping `json:"gidMappings,omitempty"`
+	// MaskedPaths are hidden from the workload, covered by /dev/null or an empty tmpfs
+	MaskedPaths []string `json:"maskedPaths"`
+	// ReadonlyPaths are remounted read only inside the container
+	ReadonlyPaths []string `json:"readonlyPaths"`
+	// Devices are the host devices exposed in the container's /dev, besides the default ones
+	Devices []filesystem.Device `json:"devices,omitempty"`
+	// ReadonlySysfs mounts /sys read only
+	ReadonlySysfs bool `json:"readonlySysfs"`
+}
+
+// Process describes how the container's workload is executed
+type Process struct {
+	// Args is the command line of the workload: the executable is looked up in the
+	// PATH of the workload unless it contains a slash
+	Args []string `json:"args,omitempty"`
+	// User is the user the workload runs as, in the name|uid[:group|gid] format.
+	// Names are looked up in the container's root filesystem.
+	User string `json:"user,omitempty"`
+	// AdditionalGroups are the supplementary groups, names or gids, the workload belongs to
+	AdditionalGroups []string `json:"additionalGroups,omitempty"`
+	// Env are the KEY=VALUE environment variables of the workload, overriding the default ones
+	Env []string `json:"env,omitempty"`
+	// Cwd is the working directory of the workload, created if it doesn't exist
+	Cwd string `json:"cwd,omitempty"`
+	// Capabilities is the bounding, effective, permitted and inheritable capability set of the workload.
+	// When nil, a default reduced set is used.
+	Capabilities []string `json:"capabilities"`
+	// AmbientCapabilities are raised in the ambient set when the workload runs as a non root user
+	AmbientCapabilities []string `json:"ambientCapabilities,omitempty"`
+	// Seccomp is the seccomp profile restricting the syscalls of the workload, nil when unconfined
+	Seccomp *seccomp.Profile `json:"seccomp"`
+	// SeccompAudit lists the syscalls the parent is notified of, and decides upon, through seccomp user notifications
+	SeccompAudit *seccomp.AuditPolicy `json:"seccompAudit,omitempty"`
+	// Landlock lists the only filesystem hierarchies the workload can access, no restriction is applied when empty
+	Landlock []landlock.Rule `json:"landlock,omitempty"`
+	// NoNewPrivileges prevents the workload from gaining privileges through setuid binaries or file capabilities
+	NoNewPrivileges bool `json:"noNewPrivileges"`
+}
+
+// New returns a spec holding the default values of the options which are enabled unless explicitly disabled
+func New() *Spec {
+	return &Spec{
+		MaskedPaths:   append([]string(nil), filesystem.DefaultMaskedPaths...),
+		ReadonlyPaths: append([]string(nil), filesystem.DefaultReadonlyPaths...),
+		ReadonlySysfs: true,
+		AutoRemove:    true,
+		Process: Process{
+			Seccomp:         seccomp.DefaultProfile(),
+			NoNewPrivileges: true,
+		},
+	}
+}
+
+// UsesFdSocket reports whether the init process and the parent hand file descriptors over to each other:
+// the seccomp notification fd and the trees of the idmapped mounts
+func (s *Spec) UsesFdSocket() bool {
+	return s.Process.SeccompAudit != nil || len(s.IDMappedMounts()) > 0
+}
+
+// IDMappedMounts returns the mounts which are idmapped with the container's user namespace
+func (s *Spec) IDMappedMounts() []filesystem.Mount {
+	var mounts []filesystem.Mount
+	for _, m := range s.Mounts {
+		if filesystem.IDMapped(m) {
+			mounts = append(mounts, m)
+		}
+	}
+	return mounts
+}
+
+// NewID generates a random container ID, as a 64 characters hexadecimal string
+func NewID() (string, error) {
+	id := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// ShortID returns the abbreviated form of the container ID, its first 12 characters
+func (s *Spec) ShortID() string {
+	if len(s.ID) > shortIDLength {
+		return s.ID[:shortIDLength]
+	}
+	return s.ID
+}
+
+// Load reads and decodes the JSON container spec found at the given path
+func Load(path string) (*Spec, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := New()
+	if err := json.Unmarshal(content, s); err != nil {
+		return nil, fmt.Errorf("invalid spec file '%s': %s", path, err)
+	}
+	return s, nil
+}
+
+// Encode serializes the spec so that it can be handed to the init process
+func (s *Spec) Encode() (string, error) {
+	content, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// Decode deserializes a spec previously serialized with Encode
+func Decode(encoded string) (*Spec, error) {
+	s := &Spec{}
+	if err := json.Unmarshal([]byte(encoded), s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
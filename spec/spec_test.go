@@ -0,0 +1,38 @@
+package spec
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Spec", func() {
+
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "coso-spec")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	load := func(content string) *Spec {
+		path := filepath.Join(dir, "config.json")
+		Expect(os.WriteFile(path, []byte(content), 0644)).To(Succeed())
+		s, err := Load(path)
+		Expect(err).NotTo(HaveOccurred())
+		return s
+	}
+
+	It("discards the writable layer unless the spec keeps it", func() {
+		Expect(load(`{"rootfs": "/tmp/coso/rootfs"}`).AutoRemove).To(BeTrue())
+		Expect(load(`{"rootfs": "/tmp/coso/rootfs", "autoRemove": false}`).AutoRemove).To(BeFalse())
+	})
+
+})
@@ -0,0 +1,101 @@
+package users
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+const (
+	// setgroupsPath tells whether setgroups is allowed in the current user namespace
+	setgroupsPath = "/proc/self/setgroups"
+	// gidMapPath lists the group IDs mapped in the current user namespace
+	gidMapPath = "/proc/self/gid_map"
+)
+
+// SwitchUser sets the supplementary groups, gid and uid of the calling process to the ones
+// of the given user. The order matters: after setuid the process may lose the right to change the others.
+func SwitchUser(u *ExecUser) error {
+	if err := setGroups(u); err != nil {
+		return fmt.Errorf("setgroups failed: %s", err)
+	}
+	if err := syscall.Setgid(u.Gid); err != nil {
+		return fmt.Errorf("setgid %d failed, is the gid mapped in the user namespace? %s", u.Gid, err)
+	}
+	if err := syscall.Setuid(u.Uid); err != nil {
+		return fmt.Errorf("setuid %d failed, is the uid mapped in the user namespace? %s", u.Uid, err)
+	}
+	return nil
+}
+
+// setGroups sets the supplementary groups of the calling process.
+//
+// Groups listed in the groups database which are not mapped in the user namespace are skipped, as they
+// couldn't be set anyway, while the explicitly requested ones must be mapped. When the gid_map has been
+// written by an unprivileged process setgroups is denied: that's only an error if additional groups were requested.
+func setGroups(u *ExecUser) error {
+	if content, err := os.ReadFile(setgroupsPath); err == nil && strings.TrimSpace(string(content)) == "deny" {
+		if len(u.AdditionalGroups) > 0 {
+			return fmt.Errorf("additional groups requested but setgroups is denied in the user namespace")
+		}
+		return nil
+	}
+
+	mapped, err := readMappedRanges(gidMapPath)
+	if err != nil {
+		return err
+	}
+
+	groups := make([]int, 0, len(u.Groups)+len(u.AdditionalGroups))
+	for _, gid := range u.Groups {
+		if isMapped(mapped, gid) {
+			groups = append(groups, gid)
+		}
+	}
+	for _, gid := range u.AdditionalGroups {
+		if !isMapped(mapped, gid) {
+			return fmt.Errorf("group %d is not mapped in the user namespace", gid)
+		}
+		groups = append(groups, gid)
+	}
+
+	return syscall.Setgroups(groups)
+}
+
+// idRange is a range of IDs mapped in the current user namespace
+type idRange struct {
+	start int
+	size  int
+}
+
+// readMappedRanges parses a /proc/<pid>/{uid,gid}_map file, made of containerID hostID size lines
+func readMappedRanges(path string) ([]idRange, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var ranges []idRange
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var r idRange
+		var hostID int
+		if _, err := fmt.Sscanf(scanner.Text(), "%d %d %d", &r.start, &hostID, &r.size); err != nil {
+			return nil, fmt.Errorf("malformed line '%s' in %s", scanner.Text(), path)
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, scanner.Err()
+}
+
+func isMapped(ranges []idRange, id int) bool {
+	for _, r := range ranges {
+		if id >= r.start && id-r.start < r.size {
+			return true
+		}
+	}
+	return false
+}
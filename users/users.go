@@ -0,0 +1,204 @@
+// Package users resolves the user a container's workload runs as, looking it up in the
+// /etc/passwd and /etc/group files of the container's root filesystem
+package users
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/NamelessOne91/coso/internal/stringslice"
+)
+
+const (
+	// PasswdPath is the path to the users database inside the container, after pivot_root
+	PasswdPath = "/etc/passwd"
+	// GroupPath is the path to the groups database inside the container, after pivot_root
+	GroupPath = "/etc/group"
+	// defaultHome is the home directory of users not listed in the users database
+	defaultHome = "/"
+)
+
+// ExecUser holds the credentials the container's workload is executed with
+type ExecUser struct {
+	Uid int
+	Gid int
+	// Groups are the supplementary groups listing the user as member in the groups database
+	Groups []int
+	// AdditionalGroups are the supplementary groups explicitly requested for the workload
+	AdditionalGroups []int
+	Home             string
+}
+
+// passwdEntry is a line of the /etc/passwd file: name:password:uid:gid:gecos:home:shell
+type passwdEntry struct {
+	name string
+	uid  int
+	gid  int
+	home string
+}
+
+// groupEntry is a line of the /etc/group file: name:password:gid:member1,member2,...
+type groupEntry struct {
+	name    string
+	gid     int
+	members []string
+}
+
+// Lookup resolves the given user, in the name|uid[:group|gid] format, and additional groups
+// (names or gids) against the users and groups databases found at the given paths.
+//
+// An empty user resolves to root. Numeric IDs not listed in the databases are allowed, in which case
+// the user belongs to the root group, unless specified otherwise, and its home is /
+func Lookup(user string, additionalGroups []string, passwdPath, groupPath string) (*ExecUser, error) {
+	passwd, err := parsePasswd(passwdPath)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := parseGroup(groupPath)
+	if err != nil {
+		return nil, err
+	}
+
+	userPart, groupPart, hasGroup := strings.Cut(user, ":")
+	if userPart == "" {
+		userPart = "0"
+	}
+
+	execUser := &ExecUser{Home: defaultHome}
+	entry, found := findPasswdEntry(passwd, userPart)
+	if found {
+		execUser.Uid = entry.uid
+		execUser.Gid = entry.gid
+		execUser.Home = entry.home
+	} else {
+		uid, err := strconv.Atoi(userPart)
+		if err != nil {
+			return nil, fmt.Errorf("unable to find user '%s' in %s", userPart, passwdPath)
+		}
+		execUser.Uid = uid
+	}
+
+	if hasGroup {
+		if execUser.Gid, err = resolveGroup(groups, groupPart, groupPath); err != nil {
+			return nil, err
+		}
+	}
+
+	// the user's supplementary groups are only considered when its primary group hasn't been overridden
+	if found && !hasGroup {
+		for _, g := range groups {
+			if g.gid != execUser.Gid && stringslice.Contains(g.members, entry.name) {
+				execUser.Groups = append(execUser.Groups, g.gid)
+			}
+		}
+	}
+
+	for _, group := range additionalGroups {
+		gid, err := resolveGroup(groups, group, groupPath)
+		if err != nil {
+			return nil, err
+		}
+		execUser.AdditionalGroups = append(execUser.AdditionalGroups, gid)
+	}
+
+	return execUser, nil
+}
+
+// findPasswdEntry looks up a user by name or, when not found, by uid
+func findPasswdEntry(passwd []passwdEntry, user string) (passwdEntry, bool) {
+	for _, e := range passwd {
+		if e.name == user {
+			return e, true
+		}
+	}
+	if uid, err := strconv.Atoi(user); err == nil {
+		for _, e := range passwd {
+			if e.uid == uid {
+				return e, true
+			}
+		}
+	}
+	return passwdEntry{}, false
+}
+
+// resolveGroup returns the gid of the group with the given name, or the given numeric gid
+func resolveGroup(groups []groupEntry, group, groupPath string) (int, error) {
+	for _, g := range groups {
+		if g.name == group {
+			return g.gid, nil
+		}
+	}
+	gid, err := strconv.Atoi(group)
+	if err != nil {
+		return 0, fmt.Errorf("unable to find group '%s' in %s", group, groupPath)
+	}
+	return gid, nil
+}
+
+// parsePasswd reads the users database at the given path. A missing file is treated as empty.
+func parsePasswd(path string) ([]passwdEntry, error) {
+	var entries []passwdEntry
+	err := parseColonFile(path, 7, func(fields []string) error {
+		uid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return err
+		}
+		gid, err := strconv.Atoi(fields[3])
+		if err != nil {
+			return err
+		}
+		entries = append(entries, passwdEntry{name: fields[0], uid: uid, gid: gid, home: fields[5]})
+		return nil
+	})
+	return entries, err
+}
+
+// parseGroup reads the groups database at the given path. A missing file is treated as empty.
+func parseGroup(path string) ([]groupEntry, error) {
+	var entries []groupEntry
+	err := parseColonFile(path, 4, func(fields []string) error {
+		gid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return err
+		}
+		var members []string
+		if fields[3] != "" {
+			members = strings.Split(fields[3], ",")
+		}
+		entries = append(entries, groupEntry{name: fields[0], gid: gid, members: members})
+		return nil
+	})
+	return entries, err
+}
+
+// parseColonFile calls parseLine for each line of a colon separated database having at least minFields fields
+func parseColonFile(path string, minFields int, parseLine func(fields []string) error) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, ":")
+		if len(fields) < minFields {
+			continue
+		}
+		if err := parseLine(fields); err != nil {
+			return fmt.Errorf("malformed line '%s' in %s: %s", line, path, err)
+		}
+	}
+	return scanner.Err()
+}
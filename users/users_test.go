@@ -0,0 +1,122 @@
+package users
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	testPasswd = `root:x:0:0:root:/root:/bin/ash
+daemon:x:2:2:daemon:/sbin:/sbin/nologin
+app:x:1000:1000:app:/home/app:/bin/sh
+`
+	testGroup = `root:x:0:root
+daemon:x:2:root,daemon
+wheel:x:10:root,app
+app:x:1000:
+docker:x:999:
+`
+)
+
+var _ = Describe("Lookup", func() {
+	var (
+		dir        string
+		passwdPath string
+		groupPath  string
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "coso-users")
+		Expect(err).NotTo(HaveOccurred())
+
+		passwdPath = filepath.Join(dir, "passwd")
+		groupPath = filepath.Join(dir, "group")
+		Expect(os.WriteFile(passwdPath, []byte(testPasswd), 0644)).To(Succeed())
+		Expect(os.WriteFile(groupPath, []byte(testGroup), 0644)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("resolves an empty user to root", func() {
+		u, err := Lookup("", nil, passwdPath, groupPath)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(u.Uid).To(Equal(0))
+		Expect(u.Gid).To(Equal(0))
+		Expect(u.Home).To(Equal("/root"))
+		Expect(u.Groups).To(Equal([]int{2, 10}))
+	})
+
+	It("resolves a user by name", func() {
+		u, err := Lookup("app", nil, passwdPath, groupPath)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(u.Uid).To(Equal(1000))
+		Expect(u.Gid).To(Equal(1000))
+		Expect(u.Home).To(Equal("/home/app"))
+		Expect(u.Groups).To(Equal([]int{10}))
+	})
+
+	It("resolves a user by uid", func() {
+		u, err := Lookup("2", nil, passwdPath, groupPath)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(u.Uid).To(Equal(2))
+		Expect(u.Home).To(Equal("/sbin"))
+	})
+
+	It("overrides the primary group", func() {
+		u, err := Lookup("app:docker", nil, passwdPath, groupPath)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(u.Gid).To(Equal(999))
+		Expect(u.Groups).To(BeEmpty())
+	})
+
+	It("resolves additional groups by name and gid", func() {
+		u, err := Lookup("app", []string{"docker", "42"}, passwdPath, groupPath)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(u.AdditionalGroups).To(Equal([]int{999, 42}))
+	})
+
+	Context("when a numeric uid is not listed in the users database", func() {
+		It("runs as that uid in the root group", func() {
+			u, err := Lookup("4242", nil, passwdPath, groupPath)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(u.Uid).To(Equal(4242))
+			Expect(u.Gid).To(Equal(0))
+			Expect(u.Home).To(Equal("/"))
+		})
+
+		It("runs as that uid in the given group", func() {
+			u, err := Lookup("4242:4343", nil, passwdPath, groupPath)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(u.Uid).To(Equal(4242))
+			Expect(u.Gid).To(Equal(4343))
+			Expect(u.Home).To(Equal("/"))
+		})
+	})
+
+	Context("when the user doesn't exist", func() {
+		It("returns a descriptive error", func() {
+			_, err := Lookup("ghost", nil, passwdPath, groupPath)
+			Expect(err).To(MatchError(ContainSubstring("unable to find user 'ghost'")))
+		})
+	})
+
+	Context("when an additional group doesn't exist", func() {
+		It("returns a descriptive error", func() {
+			_, err := Lookup("app", []string{"ghosts"}, passwdPath, groupPath)
+			Expect(err).To(MatchError(ContainSubstring("unable to find group 'ghosts'")))
+		})
+	})
+})
@@ -0,0 +1,295 @@
+// Package volumes manages named volumes: host directories, stored by coso, which keep
+// their content across containers and are bind mounted with -v name:/path
+package volumes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// DefaultStorePath holds the volumes created by root
+	DefaultStorePath = "/var/lib/coso/volumes"
+
+	dataDir      = "_data"
+	metadataFile = "volume.json"
+	lockFile     = ".lock"
+)
+
+var (
+	// ErrNotFound is returned when a volume doesn't exist
+	ErrNotFound = errors.New("no such volume")
+	// ErrInUse is returned when removing a volume referenced by a container
+	ErrInUse = errors.New("volume is in use")
+
+	validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+)
+
+// Volume holds the metadata of a named volume
+type Volume struct {
+	Name string `json:"name"`
+	// Mountpoint is the host directory holding the volume data
+	Mountpoint string            `json:"mountpoint"`
+	CreatedAt  time.Time         `json:"createdAt"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	// Containers are the IDs of the containers referencing the volume
+	Containers []string `json:"containers,omitempty"`
+}
+
+// Store keeps each volume in its own directory, holding the metadata and the data directory
+type Store struct {
+	path string
+	// containerExists reports whether the container with the given ID still exists,
+	// so that references left by containers which are gone can be dropped
+	containerExists func(id string) bool
+}
+
+// NewStore returns a store keeping the volumes under path. A reference held by a container
+// is valid as long as containerExists reports the container exists.
+func NewStore(path string, containerExists func(id string) bool) *Store {
+	return &Store{path: path, containerExists: containerExists}
+}
+
+// UserStorePath returns the store path of the calling user: DefaultStorePath for root,
+// a directory under $XDG_DATA_HOME, or ~/.local/share, otherwise
+func UserStorePath() (string, error) {
+	if os.Geteuid() == 0 {
+		return DefaultStorePath, nil
+	}
+	if dataHome := os.Getenv("XDG_DATA_HOME"); dataHome != "" {
+		return filepath.Join(dataHome, "coso", "volumes"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share", "coso", "volumes"), nil
+}
+
+// ValidateName checks name can be used as a volume name
+func ValidateName(name string) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid volume name '%s', only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name)
+	}
+	return nil
+}
+
+// Create creates a new volume, failing if it already exists
+func (s *Store) Create(name string, labels map[string]string) (*Volume, error) {
+	if err := ValidateName(name); err != nil {
+		return nil, err
+	}
+
+	var v *Volume
+	err := s.locked(func() error {
+		if _, err := s.load(name); err == nil {
+			return fmt.Errorf("volume '%s' already exists", name)
+		}
+		var err error
+		v, err = s.create(name, labels)
+		return err
+	})
+	return v, err
+}
+
+// Get returns the volume with the given name
+func (s *Store) Get(name string) (*Volume, error) {
+	if err := ValidateName(name); err != nil {
+		return nil, err
+	}
+
+	var v *Volume
+	err := s.locked(func() error {
+		var err error
+		v, err = s.load(name)
+		return err
+	})
+	return v, err
+}
+
+// List returns all the volumes, sorted by name. Directories which aren't volumes are skipped.
+func (s *Store) List() ([]*Volume, error) {
+	var volumes []*Volume
+	err := s.locked(func() error {
+		entries, err := os.ReadDir(s.path)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() || ValidateName(entry.Name()) != nil {
+				continue
+			}
+			v, err := s.load(entry.Name())
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			volumes = append(volumes, v)
+		}
+		return nil
+	})
+
+	sort.Slice(volumes, func(i, j int) bool { return volumes[i].Name < volumes[j].Name })
+	return volumes, err
+}
+
+// Remove deletes the volume and its data. Volumes still referenced by a container
+// are only removed when force is set.
+func (s *Store) Remove(name string, force bool) error {
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+
+	return s.locked(func() error {
+		v, err := s.load(name)
+		if err != nil {
+			return err
+		}
+
+		s.pruneReferences(v)
+		if len(v.Containers) > 0 && !force {
+			return fmt.Errorf("%w: '%s' is referenced by containers %s", ErrInUse, name, strings.Join(v.Containers, ", "))
+		}
+		return os.RemoveAll(filepath.Join(s.path, name))
+	})
+}
+
+// Acquire adds a reference from the container to the volume, creating the volume if it doesn't exist
+func (s *Store) Acquire(name, containerID string) (*Volume, error) {
+	if err := ValidateName(name); err != nil {
+		return nil, err
+	}
+
+	var v *Volume
+	err := s.locked(func() error {
+		var err error
+		v, err = s.load(name)
+		if errors.Is(err, ErrNotFound) {
+			v, err = s.create(name, nil)
+		}
+		if err != nil {
+			return err
+		}
+
+		s.pruneReferences(v)
+		for _, id := range v.Containers {
+			if id == containerID {
+				return nil
+			}
+		}
+		v.Containers = append(v.Containers, containerID)
+		return s.save(v)
+	})
+	return v, err
+}
+
+// Release drops the reference from the container to the volume
+func (s *Store) Release(name, containerID string) error {
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+
+	return s.locked(func() error {
+		v, err := s.load(name)
+		if err != nil {
+			return err
+		}
+
+		containers := v.Containers[:0]
+		for _, id := range v.Containers {
+			if id != containerID {
+				containers = append(containers, id)
+			}
+		}
+		v.Containers = containers
+		return s.save(v)
+	})
+}
+
+// pruneReferences drops the references held by containers which don't exist anymore
+func (s *Store) pruneReferences(v *Volume) {
+	containers := v.Containers[:0]
+	for _, id := range v.Containers {
+		if s.containerExists(id) {
+			containers = append(containers, id)
+		}
+	}
+	v.Containers = containers
+}
+
+func (s *Store) create(name string, labels map[string]string) (*Volume, error) {
+	v := &Volume{
+		Name:       name,
+		Mountpoint: filepath.Join(s.path, name, dataDir),
+		CreatedAt:  time.Now().UTC(),
+		Labels:     labels,
+	}
+	if err := os.MkdirAll(v.Mountpoint, 0755); err != nil {
+		return nil, err
+	}
+	if err := s.save(v); err != nil {
+		os.RemoveAll(filepath.Join(s.path, name))
+		return nil, err
+	}
+	return v, nil
+}
+
+func (s *Store) load(name string) (*Volume, error) {
+	content, err := os.ReadFile(filepath.Join(s.path, name, metadataFile))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var v Volume
+	if err := json.Unmarshal(content, &v); err != nil {
+		return nil, fmt.Errorf("invalid metadata of volume '%s': %w", name, err)
+	}
+	return &v, nil
+}
+
+// save writes the volume metadata atomically
+func (s *Store) save(v *Volume) error {
+	content, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(s.path, v.Name, metadataFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// locked runs fn holding an exclusive lock on the store, serializing concurrent coso processes
+func (s *Store) locked(fn func() error) error {
+	if err := os.MkdirAll(s.path, 0755); err != nil {
+		return err
+	}
+	lock, err := os.OpenFile(filepath.Join(s.path, lockFile), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	return fn()
+}
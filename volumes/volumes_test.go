@@ -0,0 +1,138 @@
+package volumes
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Store", func() {
+
+	var (
+		dir        string
+		store      *Store
+		containers map[string]bool
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "coso-volumes")
+		Expect(err).NotTo(HaveOccurred())
+
+		containers = map[string]bool{}
+		store = NewStore(dir, func(id string) bool { return containers[id] })
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	Describe("Create", func() {
+		It("creates the data directory and the metadata", func() {
+			v, err := store.Create("db", map[string]string{"team": "qa"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(v.Mountpoint).To(Equal(filepath.Join(dir, "db", "_data")))
+			Expect(v.Mountpoint).To(BeADirectory())
+
+			loaded, err := store.Get("db")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(loaded.Labels).To(Equal(map[string]string{"team": "qa"}))
+		})
+
+		It("fails when the volume exists", func() {
+			_, err := store.Create("db", nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = store.Create("db", nil)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("rejects invalid names", func() {
+			for _, name := range []string{"", "../db", ".db", "my/db"} {
+				_, err := store.Create(name, nil)
+				Expect(err).To(HaveOccurred(), name)
+			}
+		})
+	})
+
+	Describe("List", func() {
+		It("returns the volumes sorted by name", func() {
+			for _, name := range []string{"web", "cache", "db"} {
+				_, err := store.Create(name, nil)
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			volumes, err := store.List()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(volumes).To(HaveLen(3))
+			Expect([]string{volumes[0].Name, volumes[1].Name, volumes[2].Name}).To(Equal([]string{"cache", "db", "web"}))
+		})
+
+		It("skips directories which aren't volumes", func() {
+			_, err := store.Create("db", nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(os.Mkdir(filepath.Join(dir, "stray"), 0755)).To(Succeed())
+
+			volumes, err := store.List()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(volumes).To(HaveLen(1))
+			Expect(volumes[0].Name).To(Equal("db"))
+		})
+	})
+
+	It("rejects invalid names in every method taking one", func() {
+		_, err := store.Get("..")
+		Expect(err).To(MatchError(ContainSubstring("invalid volume name")))
+		err = store.Remove("..", true)
+		Expect(err).To(MatchError(ContainSubstring("invalid volume name")))
+		err = store.Release("../x", "c1")
+		Expect(err).To(MatchError(ContainSubstring("invalid volume name")))
+		_, err = store.Acquire("../x", "c1")
+		Expect(err).To(MatchError(ContainSubstring("invalid volume name")))
+	})
+
+	Describe("references", func() {
+		It("creates missing volumes on acquire", func() {
+			containers["c1"] = true
+			v, err := store.Acquire("db", "c1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(v.Containers).To(Equal([]string{"c1"}))
+			Expect(v.Mountpoint).To(BeADirectory())
+		})
+
+		It("prevents removing volumes in use", func() {
+			containers["c1"] = true
+			_, err := store.Acquire("db", "c1")
+			Expect(err).NotTo(HaveOccurred())
+
+			err = store.Remove("db", false)
+			Expect(errors.Is(err, ErrInUse)).To(BeTrue())
+
+			Expect(store.Release("db", "c1")).To(Succeed())
+			Expect(store.Remove("db", false)).To(Succeed())
+			Expect(filepath.Join(dir, "db")).NotTo(BeAnExistingFile())
+		})
+
+		It("ignores references of containers which are gone", func() {
+			containers["c1"] = true
+			_, err := store.Acquire("db", "c1")
+			Expect(err).NotTo(HaveOccurred())
+
+			delete(containers, "c1")
+			Expect(store.Remove("db", false)).To(Succeed())
+		})
+
+		It("removes volumes in use when forced", func() {
+			containers["c1"] = true
+			_, err := store.Acquire("db", "c1")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(store.Remove("db", true)).To(Succeed())
+			_, err = store.Get("db")
+			Expect(errors.Is(err, ErrNotFound)).To(BeTrue())
+		})
+	})
+})